@@ -0,0 +1,54 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command dtconfig prints the effective configuration of the exporter with the source of each setting and all
+// problems of the configuration. It reads the environment variables and the config file like the exporter does,
+// so it has to be run with the environment and in the working directory of the application.
+//
+// Usage:
+//
+//	go run github.com/dynatrace-oss/opentelemetry-exporter-go/core/cmd/dtconfig [-json]
+//
+// The exit code is 1 if the configuration has problems.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+func main() {
+	printJson := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	report := configuration.LoadConfigurationReport()
+	if *printJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	} else {
+		fmt.Print(report.String())
+	}
+
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type configFileFormat string
+
+const (
+	configFileFormat_Json configFileFormat = "json"
+	configFileFormat_Yaml configFileFormat = "yaml"
+	configFileFormat_Toml configFileFormat = "toml"
+)
+
+// configFileFormatOf determines the format of a config file by its extension, JSON is used for unknown extensions.
+func configFileFormatOf(filePath string) configFileFormat {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		return configFileFormat_Yaml
+	case ".toml":
+		return configFileFormat_Toml
+	default:
+		return configFileFormat_Json
+	}
+}
+
+// configDocument is the format independent content of a config file. Keys are the paths of the values,
+// e.g. "Connection.AuthToken", they are mapped to the line in which they are defined.
+type configDocument struct {
+	values   map[string]interface{}
+	keyLines map[string]int
+}
+
+// decodeConfigFile parses a config file in the given format. All formats are mapped to fileConfig the same way:
+// keys are matched case-insensitively to the field names and unknown keys are rejected with their line number.
+func decodeConfigFile(format configFileFormat, data []byte) (fileConfig, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return fileConfig{}, errors.New("config file is empty")
+	}
+
+	var doc configDocument
+	var err error
+	switch format {
+	case configFileFormat_Yaml:
+		doc, err = parseYamlConfigDocument(data)
+	case configFileFormat_Toml:
+		doc, err = parseTomlConfigDocument(data)
+	default:
+		doc, err = parseJsonConfigDocument(data)
+	}
+	if err != nil {
+		return fileConfig{}, err
+	}
+
+	if err := doc.checkUnknownKeys(doc.values, reflect.TypeOf(fileConfig{}), ""); err != nil {
+		return fileConfig{}, err
+	}
+
+	// the values of all formats are converted to JSON, so that they are decoded by the same rules
+	normalized, err := json.Marshal(doc.values)
+	if err != nil {
+		return fileConfig{}, err
+	}
+
+	var config fileConfig
+	if err := json.Unmarshal(normalized, &config); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return fileConfig{}, fmt.Errorf("%sinvalid value of key %q, expected %s",
+				doc.linePrefix(typeErr.Field), typeErr.Field, typeErr.Type)
+		}
+		return fileConfig{}, err
+	}
+
+	config.keyLines = map[string]int{}
+	doc.collectPresentKeys(doc.values, "", config.keyLines)
+	return config, nil
+}
+
+// collectPresentKeys records the lower case paths of all keys of the given values with their lines.
+func (doc configDocument) collectPresentKeys(values map[string]interface{}, path string, keyLines map[string]int) {
+	for key, value := range values {
+		keyPath := joinKeyPath(path, key)
+		keyLines[strings.ToLower(keyPath)] = doc.keyLines[keyPath]
+		if nested, ok := value.(map[string]interface{}); ok {
+			doc.collectPresentKeys(nested, keyPath, keyLines)
+		}
+	}
+}
+
+// checkUnknownKeys returns an error for the first key, in the order of lines, which is not a field of the given type.
+func (doc configDocument) checkUnknownKeys(values map[string]interface{}, t reflect.Type, path string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return doc.keyLines[joinKeyPath(path, keys[i])] < doc.keyLines[joinKeyPath(path, keys[j])]
+	})
+
+	for _, key := range keys {
+		keyPath := joinKeyPath(path, key)
+		field, found := fieldByKey(t, key)
+		if !found {
+			return fmt.Errorf("%sunknown key %q", doc.linePrefix(keyPath), keyPath)
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if nested, ok := values[key].(map[string]interface{}); ok && fieldType.Kind() == reflect.Struct {
+			if err := doc.checkUnknownKeys(nested, fieldType, keyPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// linePrefix returns the line of the given key path for error messages, the case of the path is ignored.
+func (doc configDocument) linePrefix(keyPath string) string {
+	for path, line := range doc.keyLines {
+		if strings.EqualFold(path, keyPath) {
+			return "line " + strconv.Itoa(line) + ": "
+		}
+	}
+
+	return ""
+}
+
+// fieldByKey finds the exported field matching the key case-insensitively, like encoding/json does.
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath == "" && strings.EqualFold(field.Name, key) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func joinKeyPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// lineAt returns the line number of the given byte offset.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func parseJsonConfigDocument(data []byte) (configDocument, error) {
+	doc := configDocument{keyLines: map[string]int{}}
+	if err := json.Unmarshal(data, &doc.values); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return doc, fmt.Errorf("line %d: %s", lineAt(data, syntaxErr.Offset), syntaxErr)
+		}
+		return doc, errors.New("config file must contain an object")
+	}
+
+	// the document is valid, so its tokens can be read without further error handling of the structure
+	err := collectJsonKeyLines(json.NewDecoder(bytes.NewReader(data)), data, "", doc.keyLines)
+	return doc, err
+}
+
+// collectJsonKeyLines reads a single JSON value and records the lines of all keys it contains.
+func collectJsonKeyLines(decoder *json.Decoder, data []byte, path string, keyLines map[string]int) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+
+			keyPath := joinKeyPath(path, key.(string))
+			keyLines[keyPath] = lineAt(data, decoder.InputOffset())
+			if err := collectJsonKeyLines(decoder, data, keyPath, keyLines); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for decoder.More() {
+			if err := collectJsonKeyLines(decoder, data, path, keyLines); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+
+	// closing delimiter of the object or array
+	_, err = decoder.Token()
+	return err
+}
+
+func parseYamlConfigDocument(data []byte) (configDocument, error) {
+	doc := configDocument{keyLines: map[string]int{}}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return doc, err
+	}
+
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return doc, errors.New("config file must contain a mapping")
+	}
+	collectYamlKeyLines(root.Content[0], "", doc.keyLines)
+
+	if err := root.Content[0].Decode(&doc.values); err != nil {
+		return doc, err
+	}
+
+	return doc, nil
+}
+
+// collectYamlKeyLines records the lines of all keys of the given node.
+func collectYamlKeyLines(node *yaml.Node, path string, keyLines map[string]int) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyPath := joinKeyPath(path, node.Content[i].Value)
+			keyLines[keyPath] = node.Content[i].Line
+			collectYamlKeyLines(node.Content[i+1], keyPath, keyLines)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			collectYamlKeyLines(item, path, keyLines)
+		}
+	}
+}
+
+func parseTomlConfigDocument(data []byte) (configDocument, error) {
+	doc := configDocument{keyLines: map[string]int{}}
+
+	metaData, err := toml.Decode(string(data), &doc.values)
+	if err != nil {
+		return doc, err
+	}
+
+	// the TOML decoder does not expose the positions of keys, so they are looked up in the table headers
+	// and key/value lines of the document
+	lines := strings.Split(string(data), "\n")
+	for _, key := range metaData.Keys() {
+		if line := findTomlKeyLine(lines, key); line > 0 {
+			doc.keyLines[strings.Join(key, ".")] = line
+		}
+	}
+
+	return doc, nil
+}
+
+// findTomlKeyLine returns the line in which the given key is defined, either as a table header or as the key of
+// a key/value pair. Returns 0 if the line can not be determined, e.g. for keys of inline tables.
+func findTomlKeyLine(lines []string, key toml.Key) int {
+	fullKey := strings.Join(key, ".")
+	table := ""
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			header := normalizeTomlKey(strings.Trim(line, "[]"))
+			if header == fullKey {
+				return i + 1
+			}
+			table = header
+			continue
+		}
+
+		if separator := strings.Index(line, "="); separator > 0 && !strings.HasPrefix(line, "#") {
+			if joinKeyPath(table, normalizeTomlKey(line[:separator])) == fullKey {
+				return i + 1
+			}
+		}
+	}
+
+	return 0
+}
+
+// normalizeTomlKey removes white space and quotes around the parts of a dotted key.
+func normalizeTomlKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+
+	return strings.Join(parts, ".")
+}
@@ -15,20 +15,39 @@
 package configuration
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 )
 
 type fileConfig struct {
-	AgentActive bool
+	// path is the path of the file the config has been read from, it is not part of the file content
+	path string
+	// keyLines maps the lower case paths of the keys present in the file to their lines, a line is 0 if it is unknown.
+	// It is nil if the config has not been decoded from a file.
+	keyLines map[string]int
+	// AgentActive is a pointer to tell an explicit false from an absent value, which means active.
+	AgentActive *bool
 	ClusterID   int
 	Tenant      string
 	Connection  struct {
-		AuthToken string
-		BaseUrl   string
+		AuthToken               string
+		AuthTokenFile           string
+		BaseUrl                 string
+		BaseUrls                UrlList
+		ProxyUrl                string
+		ProxyUsername           string
+		ProxyPassword           string
+		CaBundleFile            string
+		ClientCertFile          string
+		ClientKeyFile           string
+		TlsMinVersion           TlsVersion
+		MaxIdleConns            int
+		MaxIdleConnsPerHost     int
+		IdleConnTimeoutMs       int
+		EndpointSelection       EndpointSelection
+		EndpointProbeIntervalMs int
 	}
 	RUM struct {
 		ClientIpHeaders []string
@@ -48,66 +67,130 @@ type fileConfig struct {
 	Debug struct {
 		AddStackOnStart bool
 	}
+	Export struct {
+		Compression     ExportCompression
+		PersistentQueue struct {
+			Directory string
+			MaxSizeMb int
+		}
+		FlushConnTimeoutMs       int
+		FlushDataTimeoutMs       int
+		RegularConnTimeoutMs     int
+		RegularDataTimeoutMs     int
+		FlushOrShutdownTimeoutMs int
+		ChunkSizeKb              int
+		Concurrency              int
+		CircuitBreaker           struct {
+			FailureThreshold int
+			OpenDurationMs   int
+			RetentionPolicy  CircuitBreakerRetentionPolicy
+		}
+	}
+	SpanWatchlist struct {
+		Size              int
+		OverflowPolicy    SpanWatchlistOverflowPolicy
+		OpenSpanTimeoutMs int
+	}
+	ServiceName        string
+	ResourceAttributes string
+	Traces             struct {
+		Sampler    TracesSampler
+		SamplerArg string
+	}
+	SpanLimits struct {
+		AttributeValueLength   int
+		AttributeCount         int
+		EventCount             int
+		AttributePerEventCount int
+		LinkCount              int
+		AttributePerLinkCount  int
+	}
 }
 
 type configFileReader interface {
 	readConfigFromFile() (fileConfig, error)
 }
 
-type jsonConfigFileReader struct {
+// config file names in the order in which they are looked for in each directory
+var configFileNames = []string{"dtconfig.json", "dtconfig.yaml", "dtconfig.yml", "dtconfig.toml"}
+
+// dtConfigFileReader reads the config file in JSON, YAML or TOML format, the format is determined by the file extension.
+type dtConfigFileReader struct {
 }
 
-// readConfigFromFile looks for a config file "dtconfig.json" and attempts to parse it.
-// Returns an error if the file can't be read or the parsing fails.
-func (j *jsonConfigFileReader) readConfigFromFile() (cfg fileConfig, err error) {
-	filePaths := j.configFilePaths()
+// readConfigFromFile looks for a config file "dtconfig.json", "dtconfig.yaml", "dtconfig.yml" or "dtconfig.toml"
+// and attempts to parse it. Returns an error if the file can't be read or the parsing fails.
+func (r *dtConfigFileReader) readConfigFromFile() (cfg fileConfig, err error) {
+	filePaths := r.configFilePaths()
 
 	if len(filePaths) == 0 {
 		return fileConfig{}, errors.New("could not determine any file paths to read config file from")
 	}
 
 	for _, filePath := range filePaths {
-		cfg, err = j.readConfigFromFileByPath(filePath)
-		if err == nil {
+		var pathErr error
+		cfg, pathErr = r.readConfigFromFileByPath(filePath)
+		if pathErr == nil {
 			return cfg, nil
 		}
+
+		// an existing file which can not be parsed is a more helpful error than a missing one
+		if err == nil || !errors.Is(pathErr, os.ErrNotExist) {
+			err = pathErr
+		}
 	}
 
 	return cfg, err
 }
 
-// configFilePaths returns all possible file paths to look for the config file in.
-func (j *jsonConfigFileReader) configFilePaths() []string {
+// configFilePaths returns all possible file paths to look for the config file in: the path given by
+// DT_CONFIG_FILE_PATH, the directories of the detected platform and the working directory.
+func (r *dtConfigFileReader) configFilePaths() []string {
 	var filePaths []string
 
 	if configFilePathFromEnv := os.Getenv("DT_CONFIG_FILE_PATH"); configFilePathFromEnv != "" {
 		filePaths = append(filePaths, configFilePathFromEnv)
 	}
 
-	// When running in a Google Cloud Functions Go runtime, we need to find the config file at a different path.
-	// For reference on the K_SERVICE environment variable, see:
-	// https://cloud.google.com/functions/docs/configuring/env-var#runtime_environment_variables_set_automatically
-	_, inGcf := os.LookupEnv("K_SERVICE")
-	if inGcf {
-		// In the GCF Go runtime, the root directory of your function source code is
-		// beneath the current working directory at ./serverless_function_source_code
-		// See https://cloud.google.com/functions/docs/concepts/execution-environment#memory-file-system
-		filePaths = append(filePaths, "./serverless_function_source_code/dtconfig.json")
+	for _, dir := range detectPlatform().configFileDirs() {
+		filePaths = appendConfigFilePaths(filePaths, dir)
 	}
 
-	filePaths = append(filePaths, "./dtconfig.json")
+	filePaths = appendConfigFilePaths(filePaths, ".")
 	return filePaths
 }
 
-func (j *jsonConfigFileReader) readConfigFromFileByPath(filePath string) (fileConfig, error) {
+// appendConfigFilePaths appends the paths of all supported config file names in the given directory.
+func appendConfigFilePaths(filePaths []string, dir string) []string {
+	for _, name := range configFileNames {
+		filePaths = append(filePaths, dir+"/"+name)
+	}
+
+	return filePaths
+}
+
+func (r *dtConfigFileReader) readConfigFromFileByPath(filePath string) (fileConfig, error) {
 	fileData, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return fileConfig{}, err
 	}
 
-	var config fileConfig
-	decoder := json.NewDecoder(bytes.NewReader(fileData))
-	decoder.DisallowUnknownFields()
-	err = decoder.Decode(&config)
-	return config, err
+	config, err := decodeConfigFile(configFileFormatOf(filePath), fileData)
+	if err != nil {
+		return fileConfig{}, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	config.path = filePath
+	return config, expandConfigReferences(&config)
+}
+
+// fixedPathConfigFileReader reads the config file from a single given path, e.g. to reload the config file
+// which has been found on startup.
+type fixedPathConfigFileReader struct {
+	dtConfigFileReader
+	filePath string
+}
+
+func (f *fixedPathConfigFileReader) readConfigFromFile() (fileConfig, error) {
+	return f.readConfigFromFileByPath(f.filePath)
 }
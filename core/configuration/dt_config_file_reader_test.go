@@ -15,53 +15,67 @@
 package configuration
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestJsonConfigFileReader_NoErrorForValidFile(t *testing.T) {
-	reader := jsonConfigFileReader{}
+func TestConfigFileReader_NoErrorForValidFile(t *testing.T) {
+	reader := dtConfigFileReader{}
 	_, err := reader.readConfigFromFileByPath("./testdata/dtconfig_test_valid.json")
 	assert.NoError(t, err)
 }
 
-func TestJsonConfigFileReader_CustomPath(t *testing.T) {
+func TestConfigFileReader_CustomPath(t *testing.T) {
 	os.Setenv("DT_CONFIG_FILE_PATH", "./testdata/subfolder/dtconfig_test_valid.json")
 	defer os.Unsetenv("DT_CONFIG_FILE_PATH")
 
-	reader := jsonConfigFileReader{}
+	reader := dtConfigFileReader{}
 	cfg, err := reader.readConfigFromFile()
 	assert.NoError(t, err)
 	assert.Equal(t, "subfolder_config", cfg.Tenant)
 }
 
-func TestJsonConfigFileReader_ErrorForInvalidFile(t *testing.T) {
-	reader := jsonConfigFileReader{}
+func TestConfigFileReader_ErrorForInvalidFile(t *testing.T) {
+	reader := dtConfigFileReader{}
 	_, err := reader.readConfigFromFileByPath("./testdata/dtconfig_test_invalid.json")
 	assert.Error(t, err)
 }
 
-func TestJsonConfigFileReader_ErrorForMissingFile(t *testing.T) {
-	reader := jsonConfigFileReader{}
+func TestConfigFileReader_ErrorForMissingFile(t *testing.T) {
+	reader := dtConfigFileReader{}
 	_, err := reader.readConfigFromFileByPath("./testdata/dtconfig_test_missing.json")
 	assert.Error(t, err)
 }
 
-func TestJsonConfigFileReader_ErrorForEmptyFile(t *testing.T) {
-	reader := jsonConfigFileReader{}
+func TestConfigFileReader_ErrorForEmptyFile(t *testing.T) {
+	reader := dtConfigFileReader{}
 	_, err := reader.readConfigFromFileByPath("./testdata/dtconfig_test_empty.json")
 	assert.Error(t, err)
 }
 
-func TestJsonConfigFileReader_ValidFileIsCorrectlyDeserialized(t *testing.T) {
-	reader := jsonConfigFileReader{}
-	config, err := reader.readConfigFromFileByPath("./testdata/dtconfig_test_valid.json")
+// configFileFormatTests contains the extensions of all supported config file formats, every test of the shared
+// test suite is run for each of them.
+var configFileFormatTests = []string{"json", "yaml", "toml"}
 
-	assert.NoError(t, err)
+func TestConfigFileReader_ValidFileIsCorrectlyDeserialized(t *testing.T) {
+	for _, format := range configFileFormatTests {
+		t.Run(format, func(t *testing.T) {
+			reader := dtConfigFileReader{}
+			config, err := reader.readConfigFromFileByPath("./testdata/dtconfig_test_valid." + format)
+
+			assert.NoError(t, err)
+			assertValidTestConfig(t, config)
+		})
+	}
+}
 
-	assert.Equal(t, config.AgentActive, true)
+func assertValidTestConfig(t *testing.T, config fileConfig) {
+
+	assert.Equal(t, *config.AgentActive, true)
 	assert.Equal(t, config.ClusterID, 12345)
 	assert.Equal(t, config.Tenant, "schnitzel")
 	assert.Equal(t, config.Connection.BaseUrl, "https://ag.xyz.com")
@@ -74,4 +88,150 @@ func TestJsonConfigFileReader_ValidFileIsCorrectlyDeserialized(t *testing.T) {
 	assert.Equal(t, config.Logging.Destination, LoggingDestination_Stderr)
 	assert.Equal(t, config.Logging.Go.Flags, "Exporter=true,Propagator=false")
 	assert.Equal(t, config.Debug.AddStackOnStart, true)
+	assert.Equal(t, config.Export.FlushConnTimeoutMs, 1500)
+	assert.Equal(t, config.Export.FlushDataTimeoutMs, 4500)
+	assert.Equal(t, config.Export.RegularConnTimeoutMs, 5000)
+	assert.Equal(t, config.Export.RegularDataTimeoutMs, 30000)
+	assert.Equal(t, config.Export.FlushOrShutdownTimeoutMs, 20000)
+}
+
+// writeConfigFileForTest writes a config file in a temporary directory and returns its path.
+func writeConfigFileForTest(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// configFileSuiteCase is a config file with equivalent content in every supported format.
+type configFileSuiteCase map[string]string
+
+func TestConfigFileReader_UnknownKeysAreReportedWithLine(t *testing.T) {
+	files := configFileSuiteCase{
+		"json": "{\n  \"Tenant\": \"tenant\",\n  \"Connection\": {\n    \"AuthTokn\": \"token\"\n  }\n}",
+		"yaml": "Tenant: tenant\nConnection:\n\n  AuthTokn: token\n",
+		"toml": "Tenant = \"tenant\"\n[Connection]\n\nAuthTokn = \"token\"\n",
+	}
+
+	for _, format := range configFileFormatTests {
+		t.Run(format, func(t *testing.T) {
+			path := writeConfigFileForTest(t, "dtconfig."+format, files[format])
+			_, err := (&dtConfigFileReader{}).readConfigFromFileByPath(path)
+			assert.EqualError(t, err, path+`: line 4: unknown key "Connection.AuthTokn"`)
+		})
+	}
+}
+
+func TestConfigFileReader_UnknownTopLevelKeyIsReported(t *testing.T) {
+	files := configFileSuiteCase{
+		"json": "{\"ClusterID\": 1,\n\"Unknown\": true}",
+		"yaml": "ClusterID: 1\nUnknown: true",
+		"toml": "ClusterID = 1\nUnknown = true",
+	}
+
+	for _, format := range configFileFormatTests {
+		t.Run(format, func(t *testing.T) {
+			path := writeConfigFileForTest(t, "dtconfig."+format, files[format])
+			_, err := (&dtConfigFileReader{}).readConfigFromFileByPath(path)
+			assert.EqualError(t, err, path+`: line 2: unknown key "Unknown"`)
+		})
+	}
+}
+
+func TestConfigFileReader_InvalidValueIsReportedWithLine(t *testing.T) {
+	files := configFileSuiteCase{
+		"json": "{\n\"Testability\": {\n\"SpanProcessingIntervalMs\": \"fast\"}}",
+		"yaml": "Testability:\n\n  SpanProcessingIntervalMs: fast",
+		"toml": "[Testability]\n\nSpanProcessingIntervalMs = \"fast\"",
+	}
+
+	for _, format := range configFileFormatTests {
+		t.Run(format, func(t *testing.T) {
+			path := writeConfigFileForTest(t, "dtconfig."+format, files[format])
+			_, err := (&dtConfigFileReader{}).readConfigFromFileByPath(path)
+			assert.ErrorContains(t, err, `line 3: invalid value of key "Testability.SpanProcessingIntervalMs", expected int`)
+		})
+	}
+}
+
+func TestConfigFileReader_KeysAreCaseInsensitive(t *testing.T) {
+	files := configFileSuiteCase{
+		"json": `{"tenant": "tenant", "connection": {"baseurl": "https://example.com"}}`,
+		"yaml": "tenant: tenant\nconnection:\n  baseurl: https://example.com",
+		"toml": "tenant = \"tenant\"\n[connection]\nbaseurl = \"https://example.com\"",
+	}
+
+	for _, format := range configFileFormatTests {
+		t.Run(format, func(t *testing.T) {
+			path := writeConfigFileForTest(t, "dtconfig."+format, files[format])
+			cfg, err := (&dtConfigFileReader{}).readConfigFromFileByPath(path)
+			assert.NoError(t, err)
+			assert.Equal(t, "tenant", cfg.Tenant)
+			assert.Equal(t, "https://example.com", cfg.Connection.BaseUrl)
+		})
+	}
+}
+
+func TestConfigFileReader_ErrorForEmptyFileInAllFormats(t *testing.T) {
+	for _, format := range configFileFormatTests {
+		t.Run(format, func(t *testing.T) {
+			path := writeConfigFileForTest(t, "dtconfig."+format, "\n")
+			_, err := (&dtConfigFileReader{}).readConfigFromFileByPath(path)
+			assert.ErrorContains(t, err, "config file is empty")
+		})
+	}
+}
+
+func TestConfigFileReader_SyntaxErrorIsReportedWithLine(t *testing.T) {
+	files := configFileSuiteCase{
+		"json": "{\n\"Tenant\": \"tenant\",\n}",
+		"yaml": "Tenant: tenant\n\"Connection: x\n",
+		"toml": "Tenant = \"tenant\"\n\nConnection = \n",
+	}
+
+	for _, format := range configFileFormatTests {
+		t.Run(format, func(t *testing.T) {
+			path := writeConfigFileForTest(t, "dtconfig."+format, files[format])
+			_, err := (&dtConfigFileReader{}).readConfigFromFileByPath(path)
+			assert.ErrorContains(t, err, "line ")
+		})
+	}
+}
+
+func TestConfigFileReader_SearchOrder(t *testing.T) {
+	setPlatformEnvForTest(t, nil)
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd) //nolint:errcheck
+
+	reader := &dtConfigFileReader{}
+	assert.Equal(t, []string{"./dtconfig.json", "./dtconfig.yaml", "./dtconfig.yml", "./dtconfig.toml"}, reader.configFilePaths())
+
+	assert.NoError(t, ioutil.WriteFile("dtconfig.toml", []byte(`Tenant = "toml"`), 0600))
+	cfg, err := reader.readConfigFromFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "toml", cfg.Tenant)
+	assert.Equal(t, "./dtconfig.toml", cfg.path)
+
+	assert.NoError(t, ioutil.WriteFile("dtconfig.yml", []byte("Tenant: yml"), 0600))
+	cfg, err = reader.readConfigFromFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "yml", cfg.Tenant, "YAML is looked for before TOML")
+
+	// an invalid file is reported instead of the missing files which are looked for
+	assert.NoError(t, os.Remove("dtconfig.yml"))
+	assert.NoError(t, os.Remove("dtconfig.toml"))
+	assert.NoError(t, ioutil.WriteFile("dtconfig.yaml", []byte("Unknown: yaml"), 0600))
+	_, err = reader.readConfigFromFile()
+	assert.EqualError(t, err, `./dtconfig.yaml: line 1: unknown key "Unknown"`)
+}
+
+func TestConfigFileReader_GcfSearchOrder(t *testing.T) {
+	setPlatformEnvForTest(t, map[string]string{"K_SERVICE": "function", "FUNCTION_TARGET": "Handler"})
+
+	paths := (&dtConfigFileReader{}).configFilePaths()
+	assert.Equal(t, "./serverless_function_source_code/dtconfig.json", paths[0])
+	assert.Equal(t, "./serverless_function_source_code/dtconfig.toml", paths[3])
+	assert.Equal(t, "./dtconfig.json", paths[4])
 }
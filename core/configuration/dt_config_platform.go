@@ -0,0 +1,113 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Platform is the environment in which the application runs. It determines the directories in which the config file
+// is looked for in addition to the working directory.
+type Platform string
+
+const (
+	Platform_AwsLambda            Platform = "aws-lambda"
+	Platform_AzureFunctions       Platform = "azure-functions"
+	Platform_GoogleCloudFunctions Platform = "google-cloud-functions"
+	Platform_GoogleCloudRun       Platform = "google-cloud-run"
+	Platform_Kubernetes           Platform = "kubernetes"
+	// Platform_Unknown is used if none of the supported platforms is detected.
+	Platform_Unknown Platform = "unknown"
+)
+
+var (
+	// awsLambdaLayerDir is the directory into which the content of AWS Lambda layers is extracted.
+	awsLambdaLayerDir = "/opt"
+	// kubernetesConfigMapDir is the conventional directory to mount a ConfigMap containing the config file into.
+	kubernetesConfigMapDir = "/etc/dynatrace"
+	// googleCloudRunAppDir is the directory of the application in container images built by Google Cloud Buildpacks.
+	googleCloudRunAppDir = "/workspace"
+	// googleCloudFunctionsSourceDir is the directory of the function source code in the GCF Go runtime, beneath the
+	// working directory, see https://cloud.google.com/functions/docs/concepts/execution-environment#memory-file-system
+	googleCloudFunctionsSourceDir = "./serverless_function_source_code"
+)
+
+// detectPlatform determines the platform by environment variables which are set by the platform itself.
+func detectPlatform() Platform {
+	switch {
+	// see https://docs.aws.amazon.com/lambda/latest/dg/configuration-envvars.html#configuration-envvars-runtime
+	case os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" || os.Getenv("LAMBDA_TASK_ROOT") != "":
+		return Platform_AwsLambda
+	// see https://learn.microsoft.com/en-us/azure/azure-functions/functions-app-settings#functions_worker_runtime
+	case os.Getenv("FUNCTIONS_WORKER_RUNTIME") != "":
+		return Platform_AzureFunctions
+	case isGoogleCloudFunction():
+		return Platform_GoogleCloudFunctions
+	// see https://cloud.google.com/run/docs/container-contract#env-vars
+	case os.Getenv("K_SERVICE") != "" || os.Getenv("CLOUD_RUN_JOB") != "":
+		return Platform_GoogleCloudRun
+	case os.Getenv("KUBERNETES_SERVICE_HOST") != "":
+		return Platform_Kubernetes
+	default:
+		return Platform_Unknown
+	}
+}
+
+// isGoogleCloudFunction tells whether the application runs as a Google Cloud Function. Newer runtimes run on Cloud Run
+// and set K_SERVICE like Cloud Run services, but only functions set FUNCTION_TARGET or have their source code
+// directory beneath the working directory. Legacy runtimes set FUNCTION_NAME or X_GOOGLE_FUNCTION_NAME instead, see
+// https://cloud.google.com/functions/docs/configuring/env-var#runtime_environment_variables_set_automatically
+func isGoogleCloudFunction() bool {
+	if os.Getenv("FUNCTION_NAME") != "" || os.Getenv("X_GOOGLE_FUNCTION_NAME") != "" {
+		return true
+	}
+	if os.Getenv("K_SERVICE") == "" {
+		return false
+	}
+	if os.Getenv("FUNCTION_TARGET") != "" {
+		return true
+	}
+	info, err := os.Stat(googleCloudFunctionsSourceDir)
+	return err == nil && info.IsDir()
+}
+
+// configFileDirs returns the directories specific to the platform in which the config file is looked for before
+// the working directory.
+func (p Platform) configFileDirs() []string {
+	switch p {
+	case Platform_AwsLambda:
+		// the function code is extracted to LAMBDA_TASK_ROOT, a config file may also be provided by a layer
+		var dirs []string
+		if taskRoot := os.Getenv("LAMBDA_TASK_ROOT"); taskRoot != "" {
+			dirs = append(dirs, taskRoot)
+		}
+		return append(dirs, awsLambdaLayerDir)
+	case Platform_AzureFunctions:
+		// the function app is deployed to HOME/site/wwwroot, which is not necessarily the working directory
+		if home := os.Getenv("HOME"); home != "" {
+			return []string{filepath.Join(home, "site", "wwwroot")}
+		}
+		return nil
+	case Platform_GoogleCloudFunctions:
+		return []string{googleCloudFunctionsSourceDir}
+	case Platform_GoogleCloudRun:
+		return []string{googleCloudRunAppDir}
+	case Platform_Kubernetes:
+		return []string{kubernetesConfigMapDir}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// platformEnvVars are all environment variables which are used to detect the platform.
+var platformEnvVars = []string{
+	"AWS_LAMBDA_FUNCTION_NAME", "LAMBDA_TASK_ROOT", "FUNCTIONS_WORKER_RUNTIME", "K_SERVICE", "FUNCTION_TARGET",
+	"FUNCTION_NAME", "X_GOOGLE_FUNCTION_NAME", "CLOUD_RUN_JOB", "KUBERNETES_SERVICE_HOST",
+}
+
+// setPlatformEnvForTest fakes the environment of a platform: all environment variables of other platforms are unset
+// and the given ones are set. The previous environment is restored when the test ends.
+func setPlatformEnvForTest(t *testing.T, env map[string]string) {
+	for _, key := range append(platformEnvVars, "HOME") {
+		if value, found := os.LookupEnv(key); found {
+			key := key
+			t.Cleanup(func() { os.Setenv(key, value) })
+		}
+		os.Unsetenv(key)
+	}
+
+	for key, value := range env {
+		key := key
+		os.Setenv(key, value)
+		t.Cleanup(func() { os.Unsetenv(key) })
+	}
+}
+
+// setPlatformDirForTest replaces a platform directory with a temporary directory for the duration of the test.
+func setPlatformDirForTest(t *testing.T, dir *string) string {
+	previous := *dir
+	*dir = t.TempDir()
+	t.Cleanup(func() { *dir = previous })
+	return *dir
+}
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		env      map[string]string
+		platform Platform
+	}{
+		{map[string]string{}, Platform_Unknown},
+		{map[string]string{"AWS_LAMBDA_FUNCTION_NAME": "function", "LAMBDA_TASK_ROOT": "/var/task"}, Platform_AwsLambda},
+		{map[string]string{"LAMBDA_TASK_ROOT": "/var/task"}, Platform_AwsLambda},
+		{map[string]string{"FUNCTIONS_WORKER_RUNTIME": "custom"}, Platform_AzureFunctions},
+		{map[string]string{"K_SERVICE": "function", "FUNCTION_TARGET": "Handler"}, Platform_GoogleCloudFunctions},
+		{map[string]string{"FUNCTION_NAME": "function", "X_GOOGLE_FUNCTION_NAME": "function"}, Platform_GoogleCloudFunctions},
+		{map[string]string{"X_GOOGLE_FUNCTION_NAME": "function"}, Platform_GoogleCloudFunctions},
+		{map[string]string{"K_SERVICE": "service"}, Platform_GoogleCloudRun},
+		{map[string]string{"CLOUD_RUN_JOB": "job"}, Platform_GoogleCloudRun},
+		{map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"}, Platform_Kubernetes},
+		{map[string]string{"K_SERVICE": "service", "KUBERNETES_SERVICE_HOST": "10.0.0.1"}, Platform_GoogleCloudRun},
+	}
+
+	for _, test := range tests {
+		setPlatformEnvForTest(t, test.env)
+		assert.Equal(t, test.platform, detectPlatform(), "environment %v", test.env)
+	}
+}
+
+func TestDetectPlatform_GoogleCloudFunctionsSourceDir(t *testing.T) {
+	setPlatformEnvForTest(t, map[string]string{"K_SERVICE": "function"})
+	sourceDir := filepath.Join(setPlatformDirForTest(t, &googleCloudFunctionsSourceDir), "serverless_function_source_code")
+	googleCloudFunctionsSourceDir = sourceDir
+	assert.Equal(t, Platform_GoogleCloudRun, detectPlatform())
+
+	assert.NoError(t, os.Mkdir(sourceDir, 0755))
+	assert.Equal(t, Platform_GoogleCloudFunctions, detectPlatform())
+}
+
+func TestConfigFileReader_AwsLambda(t *testing.T) {
+	taskRoot := t.TempDir()
+	setPlatformEnvForTest(t, map[string]string{"LAMBDA_TASK_ROOT": taskRoot})
+	layerDir := setPlatformDirForTest(t, &awsLambdaLayerDir)
+
+	paths := (&dtConfigFileReader{}).configFilePaths()
+	assert.Equal(t, taskRoot+"/dtconfig.json", paths[0])
+	assert.Equal(t, layerDir+"/dtconfig.json", paths[4])
+	assert.Equal(t, "./dtconfig.json", paths[8])
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(layerDir, "dtconfig.yaml"), []byte("Tenant: layer"), 0600))
+	cfg, err := (&dtConfigFileReader{}).readConfigFromFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "layer", cfg.Tenant)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(taskRoot, "dtconfig.json"), []byte(`{"Tenant": "function"}`), 0600))
+	cfg, err = (&dtConfigFileReader{}).readConfigFromFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "function", cfg.Tenant, "the function code takes precedence over layers")
+}
+
+func TestConfigFileReader_AzureFunctions(t *testing.T) {
+	home := t.TempDir()
+	setPlatformEnvForTest(t, map[string]string{"FUNCTIONS_WORKER_RUNTIME": "custom", "HOME": home})
+	wwwroot := filepath.Join(home, "site", "wwwroot")
+	assert.NoError(t, os.MkdirAll(wwwroot, 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(wwwroot, "dtconfig.toml"), []byte(`Tenant = "azure"`), 0600))
+
+	cfg, err := (&dtConfigFileReader{}).readConfigFromFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "azure", cfg.Tenant)
+	assert.Equal(t, wwwroot+"/dtconfig.toml", cfg.path)
+}
+
+func TestConfigFileReader_GoogleCloudRun(t *testing.T) {
+	setPlatformEnvForTest(t, map[string]string{"K_SERVICE": "service"})
+	appDir := setPlatformDirForTest(t, &googleCloudRunAppDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(appDir, "dtconfig.json"), []byte(`{"Tenant": "run"}`), 0600))
+
+	cfg, err := (&dtConfigFileReader{}).readConfigFromFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "run", cfg.Tenant)
+}
+
+func TestConfigFileReader_Kubernetes(t *testing.T) {
+	setPlatformEnvForTest(t, map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"})
+	configMapDir := setPlatformDirForTest(t, &kubernetesConfigMapDir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(configMapDir, "dtconfig.yaml"), []byte("Tenant: k8s"), 0600))
+
+	cfg, err := (&dtConfigFileReader{}).readConfigFromFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "k8s", cfg.Tenant)
+
+	os.Setenv("DT_CONFIG_FILE_PATH", "./testdata/dtconfig_test_valid.json")
+	defer os.Unsetenv("DT_CONFIG_FILE_PATH")
+	cfg, err = (&dtConfigFileReader{}).readConfigFromFile()
+	assert.NoError(t, err)
+	assert.Equal(t, "schnitzel", cfg.Tenant, "DT_CONFIG_FILE_PATH takes precedence")
+}
+
+func TestPlatformConfiguration(t *testing.T) {
+	setPlatformEnvForTest(t, map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"})
+
+	config, err := loadConfiguration(createMockConfigFileReaderWithRequiredFields())
+	assert.NoError(t, err)
+	assert.Equal(t, Platform_Kubernetes, config.Platform)
+
+	config, err = BuildConfiguration(DtConfiguration{AgentDisabled: true, Platform: Platform_AwsLambda})
+	assert.NoError(t, err)
+	assert.Equal(t, Platform_AwsLambda, config.Platform, "a given platform is kept")
+
+	assert.Contains(t, loadConfigurationReport(createMockConfigFileReaderWithRequiredFields()).String(),
+		"Platform: kubernetes\n")
+}
@@ -21,7 +21,9 @@ import (
 	"fmt"
 	"math/rand"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration/internal/util"
@@ -42,7 +44,30 @@ const (
 	DefaultMaxSpansWatchlistSize    = 2048
 )
 
+const (
+	DefaultPersistentQueueMaxSizeMb = 50
+	DefaultExportChunkSizeKb        = 1024
+	MaxExportChunkSizeKb            = 64 * 1024
+	DefaultExportConcurrency        = 1
+)
+
+const (
+	DefaultCircuitBreakerFailureThreshold = 5
+	DefaultCircuitBreakerOpenDurationMs   = 30000
+)
+
+const DefaultEndpointProbeIntervalMs = 10000
+
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 2
+	DefaultIdleConnTimeoutMs   = 90000
+)
+
 type DtConfiguration struct {
+	// AgentDisabled is set if AgentActive is false in the config file or DT_AGENT_ACTIVE is false. Nothing is exported
+	// and only W3C trace context is propagated then. Required values do not need to be specified.
+	AgentDisabled            bool
 	ClusterId                int32
 	Tenant                   string
 	tenantId                 int32
@@ -54,6 +79,104 @@ type DtConfiguration struct {
 	LoggingFlags             string
 	RumClientIpHeaders       []string
 	DebugAddStackOnStart     bool
+	// PersistentQueueDirectory is the directory in which span chunks that could not be sent are stored until they
+	// can be sent again. The persistent queue is disabled if it is empty.
+	PersistentQueueDirectory string
+	PersistentQueueMaxSizeMb int
+	ExportCompression        ExportCompression
+	// SpanWatchlistSize is the maximum number of spans which are tracked until they are exported.
+	SpanWatchlistSize int
+	// SpanWatchlistOverflowPolicy determines what happens to a span if the span watchlist is full.
+	SpanWatchlistOverflowPolicy SpanWatchlistOverflowPolicy
+	// OpenSpanTimeoutMs is the time after which a span which has not been ended is exported as dropped
+	// and no longer tracked.
+	OpenSpanTimeoutMs int
+	// KeepAliveIntervalMs is the interval in which spans which have not been ended are sent again, so that Dynatrace
+	// Cluster does not consider them lost.
+	KeepAliveIntervalMs int
+	// FlushExportConnTimeoutMs and FlushExportDataTimeoutMs are the connection and data timeouts of export requests
+	// which are sent by a flush or shutdown operation.
+	FlushExportConnTimeoutMs int
+	FlushExportDataTimeoutMs int
+	// RegularExportConnTimeoutMs and RegularExportDataTimeoutMs are the connection and data timeouts of export requests
+	// which are sent periodically.
+	RegularExportConnTimeoutMs int
+	RegularExportDataTimeoutMs int
+	// FlushOrShutdownTimeoutMs is the maximum duration of a flush or shutdown operation if the context passed by the
+	// caller has no deadline. Defaults to the sum of the flush connection and data timeouts.
+	FlushOrShutdownTimeoutMs int
+	// AuthTokenFile is the path of a file which contains the auth token, e.g. a mounted secret. The token read from
+	// the file takes precedence over AuthToken. The file is watched and a changed token is used for subsequent requests.
+	AuthTokenFile string
+	// ConfigFilePath is the path of the config file the configuration has been read from. It is empty if no config
+	// file has been found. The file is watched and changed connection and logging settings are applied at runtime.
+	ConfigFilePath string
+	// Platform is the detected environment in which the application runs, e.g. AWS Lambda or Kubernetes.
+	Platform Platform
+	// ServiceName and ResourceAttributes are added to the resource of exported spans. The service name takes
+	// precedence over the one of the spans' resource, other attributes of the spans' resource take precedence over
+	// ResourceAttributes. ResourceAttributes is a comma separated list of key=value pairs with percent-encoded values
+	// like OTEL_RESOURCE_ATTRIBUTES, a ServiceName takes precedence over its service.name.
+	ServiceName        string
+	ResourceAttributes string
+	// TracesSampler and TracesSamplerArg configure the sampler of the wrapped SDK TracerProvider like
+	// OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG do. The SDK default is used if TracesSampler is empty.
+	TracesSampler    TracesSampler
+	TracesSamplerArg string
+	// The span limits configure the wrapped SDK TracerProvider. The SDK default is used for a limit of 0,
+	// a negative limit means unlimited.
+	SpanAttributeValueLengthLimit   int
+	SpanAttributeCountLimit         int
+	SpanEventCountLimit             int
+	SpanAttributePerEventCountLimit int
+	SpanLinkCountLimit              int
+	SpanAttributePerLinkCountLimit  int
+	// Warnings are problems which do not prevent the configuration from being used, e.g. environment variables which
+	// can not be parsed or OTEL_* environment variables which are overridden by Dynatrace settings. They are logged
+	// on startup.
+	Warnings []string
+	// ProxyUrl is the URL of the proxy through which spans are sent, e.g. "https://proxy.example.com:3128". The proxy
+	// given by HTTPS_PROXY and NO_PROXY is used if it is empty. ProxyUsername and ProxyPassword are the credentials
+	// for the proxy, they take precedence over credentials which are part of the ProxyUrl.
+	ProxyUrl      string
+	ProxyUsername string
+	ProxyPassword string
+	// CaBundleFile is the path of a PEM file with CA certificates which are trusted in addition to the system ones,
+	// e.g. the CA of an ActiveGate with a self-signed certificate.
+	CaBundleFile string
+	// ClientCertFile and ClientKeyFile are the paths of the PEM encoded client certificate and key which are presented
+	// to the server for mutual TLS.
+	ClientCertFile string
+	ClientKeyFile  string
+	// TlsMinVersion is the minimum TLS version which is accepted, "1.2" by default.
+	TlsMinVersion TlsVersion
+	// MaxIdleConns, MaxIdleConnsPerHost and IdleConnTimeoutMs limit the idle connections which are kept open for
+	// subsequent requests.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeoutMs   int
+	// ExportChunkSizeKb is the size which spans are grouped into per export request, a single span may exceed it.
+	// A smaller size is used once the server rejects a request as too large.
+	ExportChunkSizeKb int
+	// ExportConcurrency is the maximum number of export requests which are sent concurrently if the spans of an export
+	// are split into several chunks. Serialization runs ahead of the requests by as many chunks.
+	ExportConcurrency int
+	// CircuitBreakerFailureThreshold is the number of consecutive failed export requests after which the circuit
+	// breaker opens. Exports are skipped while it is open, after CircuitBreakerOpenDurationMs a single export probes
+	// whether the endpoint is reachable again. CircuitBreakerRetentionPolicy determines what happens to the spans
+	// which are due for export while the circuit breaker is open.
+	CircuitBreakerFailureThreshold int
+	CircuitBreakerOpenDurationMs   int
+	CircuitBreakerRetentionPolicy  CircuitBreakerRetentionPolicy
+	// BaseUrls are the base URLs of redundant endpoints, e.g. several ActiveGates. If set, they take precedence over
+	// BaseUrl, which is set to the first of them. Requests fail over to another endpoint on connection errors and
+	// 5xx responses, EndpointSelection determines the order in which the endpoints are used. Failed endpoints are
+	// probed every EndpointProbeIntervalMs until they are reachable again.
+	BaseUrls                UrlList
+	EndpointSelection       EndpointSelection
+	EndpointProbeIntervalMs int
+	// complete is set once default values have been set and derived values have been calculated.
+	complete bool
 }
 
 type LoggingDestination string
@@ -64,6 +187,74 @@ const (
 	LoggingDestination_Stderr LoggingDestination = "stderr"
 )
 
+type ExportCompression string
+
+// SpanWatchlistOverflowPolicy determines how a span is handled if the span watchlist is full.
+type SpanWatchlistOverflowPolicy string
+
+const (
+	// SpanWatchlistOverflowPolicy_Reject does not track the span, it is never exported.
+	SpanWatchlistOverflowPolicy_Reject SpanWatchlistOverflowPolicy = "reject"
+	// SpanWatchlistOverflowPolicy_EvictOldest removes the oldest open span from the watchlist and exports it as dropped.
+	SpanWatchlistOverflowPolicy_EvictOldest SpanWatchlistOverflowPolicy = "evict-oldest"
+	// SpanWatchlistOverflowPolicy_Export triggers an immediate export of ended spans to free capacity.
+	// A span which still does not fit into the watchlist is rejected.
+	SpanWatchlistOverflowPolicy_Export SpanWatchlistOverflowPolicy = "export"
+)
+
+const (
+	ExportCompression_None ExportCompression = "none"
+	ExportCompression_Gzip ExportCompression = "gzip"
+)
+
+// CircuitBreakerRetentionPolicy determines how the spans which are due for export are handled while the circuit
+// breaker is open.
+type CircuitBreakerRetentionPolicy string
+
+const (
+	// CircuitBreakerRetentionPolicy_Retain keeps the spans in the span watchlist, they are exported once the circuit
+	// breaker is closed. The span watchlist overflow policy applies if it fills up in the meantime.
+	CircuitBreakerRetentionPolicy_Retain CircuitBreakerRetentionPolicy = "retain"
+	// CircuitBreakerRetentionPolicy_Drop removes the spans from the span watchlist without exporting them.
+	CircuitBreakerRetentionPolicy_Drop CircuitBreakerRetentionPolicy = "drop"
+)
+
+// EndpointSelection determines the order in which several redundant endpoints are used.
+type EndpointSelection string
+
+const (
+	// EndpointSelection_Priority sends all requests to the first healthy endpoint in the order of BaseUrls. Requests
+	// stay with the endpoint they have failed over to until an endpoint listed before it is reachable again.
+	EndpointSelection_Priority EndpointSelection = "priority"
+	// EndpointSelection_RoundRobin distributes the requests among all healthy endpoints in turn.
+	EndpointSelection_RoundRobin EndpointSelection = "round-robin"
+)
+
+// UrlList is a list of URLs. In environment variables, the URLs are separated by commas.
+type UrlList []string
+
+// TlsVersion is the version of the TLS protocol.
+type TlsVersion string
+
+const (
+	TlsVersion_1_0 TlsVersion = "1.0"
+	TlsVersion_1_1 TlsVersion = "1.1"
+	TlsVersion_1_2 TlsVersion = "1.2"
+	TlsVersion_1_3 TlsVersion = "1.3"
+)
+
+// TracesSampler is the sampler of the wrapped SDK TracerProvider, the values are those of OTEL_TRACES_SAMPLER.
+type TracesSampler string
+
+const (
+	TracesSampler_AlwaysOn                TracesSampler = "always_on"
+	TracesSampler_AlwaysOff               TracesSampler = "always_off"
+	TracesSampler_TraceIdRatio            TracesSampler = "traceidratio"
+	TracesSampler_ParentBasedAlwaysOn     TracesSampler = "parentbased_always_on"
+	TracesSampler_ParentBasedAlwaysOff    TracesSampler = "parentbased_always_off"
+	TracesSampler_ParentBasedTraceIdRatio TracesSampler = "parentbased_traceidratio"
+)
+
 func (config *DtConfiguration) TenantId() int32 {
 	return config.tenantId
 }
@@ -75,11 +266,27 @@ func (config *DtConfiguration) QualifiedTenantId() QualifiedTenantId {
 	}
 }
 
+// TraceIdRatio returns the ratio of the trace ID ratio based samplers given by TracesSamplerArg, 1 if it is empty.
+func (config *DtConfiguration) TraceIdRatio() float64 {
+	ratio, err := parseTraceIdRatio(config.TracesSamplerArg)
+	if err != nil {
+		return 1
+	}
+	return ratio
+}
+
+// ResourceAttributeValues returns the key=value pairs of ResourceAttributes, pairs without a key are ignored.
+func (config *DtConfiguration) ResourceAttributeValues() map[string]string {
+	values, _ := parseResourceAttributes(config.ResourceAttributes)
+	return values
+}
+
 // ConfigurationProvider
 // Usage: create an instance of ConfigurationProvider and call GetConfiguration() to get the configuration.
 // You may pass around the ConfigurationProvider or the returned DtConfiguration to other parts of the application.
 // You may also use the GlobalConfigurationProvider singleton instead of creating your own instance.
 type ConfigurationProvider struct {
+	lock          sync.Mutex
 	configuration *DtConfiguration
 }
 
@@ -87,9 +294,13 @@ var GlobalConfigurationProvider = &ConfigurationProvider{}
 
 // GetConfiguration returns configuration from environment variables or from file.
 // Will return a cached configuration when called multiple times.
+// It is safe to call it concurrently, the configuration is loaded by one caller at a time.
 func (cp *ConfigurationProvider) GetConfiguration() (*DtConfiguration, error) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
 	if cp.configuration == nil {
-		config, err := loadConfiguration(&jsonConfigFileReader{})
+		config, err := loadConfiguration(&dtConfigFileReader{})
 		if err != nil {
 			return nil, err
 		}
@@ -104,35 +315,256 @@ func loadConfiguration(configFileReader configFileReader) (*DtConfiguration, err
 	fileConfig, err := configFileReader.readConfigFromFile()
 	if err != nil {
 		fmt.Println("Could not read configuration file: " + err.Error())
+		fileConfig.path = ""
+	}
+
+	return configurationFromFileConfig(fileConfig)
+}
+
+// LoadConfigurationFromFile reads the config file at the given path and consolidates it with the configuration
+// provided by environment variables, like GetConfiguration does. Unlike GetConfiguration, an error is returned if
+// the file can not be read, so that a config file which is being written is not mistaken for a missing one.
+// A new AgentId is generated, the result is not cached.
+func LoadConfigurationFromFile(filePath string) (*DtConfiguration, error) {
+	fileConfig, err := (&fixedPathConfigFileReader{filePath: filePath}).readConfigFromFile()
+	if err != nil {
+		return nil, err
 	}
 
+	return configurationFromFileConfig(fileConfig)
+}
+
+// configurationFromFileConfig creates a configuration from the values read from the config file, which are
+// overridden by environment variables.
+func configurationFromFileConfig(fileConfig fileConfig) (*DtConfiguration, error) {
+	config, _ := resolveConfiguration(fileConfig)
+	if err := completeConfiguration(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// resolveConfiguration takes the value of each setting from its environment variable or from the config file.
+// Standard OTEL_* environment variables are used for settings which are provided neither way.
+// The returned resolver records the source of each value and the environment variables which can not be parsed.
+func resolveConfiguration(fileConfig fileConfig) (*DtConfiguration, *configSettingsResolver) {
+	r := newConfigSettingsResolver(fileConfig)
+
+	fileAgentActive := true
+	if fileConfig.AgentActive != nil {
+		fileAgentActive = *fileConfig.AgentActive
+	}
+	agentActive := r.resolveBool("AgentActive", "DT_AGENT_ACTIVE", fileAgentActive, fileConfig.AgentActive != nil)
+
 	config := &DtConfiguration{
-		AgentId:                  generateAgentId(),
-		ClusterId:                int32(util.GetIntFromEnvWithDefault("DT_CLUSTER_ID", fileConfig.ClusterID)),
-		Tenant:                   util.GetStringFromEnvWithDefault("DT_TENANT", fileConfig.Tenant),
-		BaseUrl:                  util.GetStringFromEnvWithDefault("DT_CONNECTION_BASE_URL", fileConfig.Connection.BaseUrl),
-		AuthToken:                util.GetStringFromEnvWithDefault("DT_CONNECTION_AUTH_TOKEN", fileConfig.Connection.AuthToken),
-		SpanProcessingIntervalMs: util.GetIntFromEnvWithDefault("DT_TESTABILITY_SPAN_PROCESSING_INTERVAL_MS", fileConfig.Testability.SpanProcessingIntervalMs),
-		RumClientIpHeaders:       util.GetStringSliceFromEnvWithDefault("DT_RUM_CLIENT_IP_HEADERS", fileConfig.RUM.ClientIpHeaders),
-		DebugAddStackOnStart:     util.GetBoolFromEnvWithDefault("DT_DEBUG_ADD_STACK_ON_START", fileConfig.Debug.AddStackOnStart),
-		LoggingDestination:       LoggingDestination(util.GetStringFromEnvWithDefault("DT_LOGGING_DESTINATION", string(fileConfig.Logging.Destination))),
-		LoggingFlags:             util.GetStringFromEnvWithDefault("DT_LOGGING_GO_FLAGS", fileConfig.Logging.Go.Flags),
+		AgentId:        generateAgentId(),
+		ConfigFilePath: fileConfig.path,
+		AgentDisabled:  !agentActive,
+		ClusterId:      int32(r.resolveInt("ClusterID", "DT_CLUSTER_ID", fileConfig.ClusterID)),
+		Tenant:         r.resolveString("Tenant", "DT_TENANT", fileConfig.Tenant),
+		BaseUrl:        r.resolveString("Connection.BaseUrl", "DT_CONNECTION_BASE_URL", fileConfig.Connection.BaseUrl),
+		BaseUrls:       r.resolveUrls("Connection.BaseUrls", "DT_CONNECTION_BASE_URLS", fileConfig.Connection.BaseUrls),
+		EndpointSelection: EndpointSelection(r.resolveString("Connection.EndpointSelection",
+			"DT_CONNECTION_ENDPOINT_SELECTION", string(fileConfig.Connection.EndpointSelection))),
+		EndpointProbeIntervalMs: r.resolveInt("Connection.EndpointProbeIntervalMs",
+			"DT_CONNECTION_ENDPOINT_PROBE_INTERVAL_MS", fileConfig.Connection.EndpointProbeIntervalMs),
+		AuthToken: r.resolveString("Connection.AuthToken", "DT_CONNECTION_AUTH_TOKEN", fileConfig.Connection.AuthToken),
+		AuthTokenFile: r.resolveString("Connection.AuthTokenFile", "DT_CONNECTION_AUTH_TOKEN_FILE",
+			fileConfig.Connection.AuthTokenFile),
+		ProxyUrl: r.resolveString("Connection.ProxyUrl", "DT_CONNECTION_PROXY_URL", fileConfig.Connection.ProxyUrl),
+		ProxyUsername: r.resolveString("Connection.ProxyUsername", "DT_CONNECTION_PROXY_USERNAME",
+			fileConfig.Connection.ProxyUsername),
+		ProxyPassword: r.resolveString("Connection.ProxyPassword", "DT_CONNECTION_PROXY_PASSWORD",
+			fileConfig.Connection.ProxyPassword),
+		CaBundleFile: r.resolveString("Connection.CaBundleFile", "DT_CONNECTION_CA_BUNDLE_FILE",
+			fileConfig.Connection.CaBundleFile),
+		ClientCertFile: r.resolveString("Connection.ClientCertFile", "DT_CONNECTION_CLIENT_CERT_FILE",
+			fileConfig.Connection.ClientCertFile),
+		ClientKeyFile: r.resolveString("Connection.ClientKeyFile", "DT_CONNECTION_CLIENT_KEY_FILE",
+			fileConfig.Connection.ClientKeyFile),
+		TlsMinVersion: TlsVersion(r.resolveString("Connection.TlsMinVersion", "DT_CONNECTION_TLS_MIN_VERSION",
+			string(fileConfig.Connection.TlsMinVersion))),
+		MaxIdleConns: r.resolveInt("Connection.MaxIdleConns", "DT_CONNECTION_MAX_IDLE_CONNS",
+			fileConfig.Connection.MaxIdleConns),
+		MaxIdleConnsPerHost: r.resolveInt("Connection.MaxIdleConnsPerHost", "DT_CONNECTION_MAX_IDLE_CONNS_PER_HOST",
+			fileConfig.Connection.MaxIdleConnsPerHost),
+		IdleConnTimeoutMs: r.resolveInt("Connection.IdleConnTimeoutMs", "DT_CONNECTION_IDLE_CONN_TIMEOUT_MS",
+			fileConfig.Connection.IdleConnTimeoutMs),
+		SpanProcessingIntervalMs: r.resolveInt("Testability.SpanProcessingIntervalMs",
+			"DT_TESTABILITY_SPAN_PROCESSING_INTERVAL_MS", fileConfig.Testability.SpanProcessingIntervalMs),
+		KeepAliveIntervalMs: r.resolveInt("Testability.KeepAliveIntervalMs", "DT_TESTABILITY_KEEP_ALIVE_INTERVAL_MS",
+			fileConfig.Testability.KeepAliveIntervalMs),
+		RumClientIpHeaders: r.resolveStrings("RUM.ClientIpHeaders", "DT_RUM_CLIENT_IP_HEADERS",
+			fileConfig.RUM.ClientIpHeaders),
+		DebugAddStackOnStart: r.resolveBool("Debug.AddStackOnStart", "DT_DEBUG_ADD_STACK_ON_START",
+			fileConfig.Debug.AddStackOnStart, fileConfig.Debug.AddStackOnStart),
+		LoggingDestination: LoggingDestination(r.resolveString("Logging.Destination", "DT_LOGGING_DESTINATION",
+			string(fileConfig.Logging.Destination))),
+		LoggingFlags: r.resolveString("Logging.Go.Flags", "DT_LOGGING_GO_FLAGS", fileConfig.Logging.Go.Flags),
+		PersistentQueueDirectory: r.resolveString("Export.PersistentQueue.Directory",
+			"DT_EXPORT_PERSISTENT_QUEUE_DIRECTORY", fileConfig.Export.PersistentQueue.Directory),
+		PersistentQueueMaxSizeMb: r.resolveInt("Export.PersistentQueue.MaxSizeMb",
+			"DT_EXPORT_PERSISTENT_QUEUE_MAX_SIZE_MB", fileConfig.Export.PersistentQueue.MaxSizeMb),
+		ExportCompression: ExportCompression(r.resolveString("Export.Compression", "DT_EXPORT_COMPRESSION",
+			string(fileConfig.Export.Compression))),
+		FlushExportConnTimeoutMs: r.resolveInt("Export.FlushConnTimeoutMs", "DT_EXPORT_FLUSH_CONN_TIMEOUT_MS",
+			fileConfig.Export.FlushConnTimeoutMs),
+		FlushExportDataTimeoutMs: r.resolveInt("Export.FlushDataTimeoutMs", "DT_EXPORT_FLUSH_DATA_TIMEOUT_MS",
+			fileConfig.Export.FlushDataTimeoutMs),
+		RegularExportConnTimeoutMs: r.resolveInt("Export.RegularConnTimeoutMs", "DT_EXPORT_REGULAR_CONN_TIMEOUT_MS",
+			fileConfig.Export.RegularConnTimeoutMs),
+		RegularExportDataTimeoutMs: r.resolveInt("Export.RegularDataTimeoutMs", "DT_EXPORT_REGULAR_DATA_TIMEOUT_MS",
+			fileConfig.Export.RegularDataTimeoutMs),
+		FlushOrShutdownTimeoutMs: r.resolveInt("Export.FlushOrShutdownTimeoutMs",
+			"DT_EXPORT_FLUSH_OR_SHUTDOWN_TIMEOUT_MS", fileConfig.Export.FlushOrShutdownTimeoutMs),
+		ExportChunkSizeKb: r.resolveInt("Export.ChunkSizeKb", "DT_EXPORT_CHUNK_SIZE_KB", fileConfig.Export.ChunkSizeKb),
+		ExportConcurrency: r.resolveInt("Export.Concurrency", "DT_EXPORT_CONCURRENCY", fileConfig.Export.Concurrency),
+		CircuitBreakerFailureThreshold: r.resolveInt("Export.CircuitBreaker.FailureThreshold",
+			"DT_EXPORT_CIRCUIT_BREAKER_FAILURE_THRESHOLD", fileConfig.Export.CircuitBreaker.FailureThreshold),
+		CircuitBreakerOpenDurationMs: r.resolveInt("Export.CircuitBreaker.OpenDurationMs",
+			"DT_EXPORT_CIRCUIT_BREAKER_OPEN_DURATION_MS", fileConfig.Export.CircuitBreaker.OpenDurationMs),
+		CircuitBreakerRetentionPolicy: CircuitBreakerRetentionPolicy(r.resolveString(
+			"Export.CircuitBreaker.RetentionPolicy", "DT_EXPORT_CIRCUIT_BREAKER_RETENTION_POLICY",
+			string(fileConfig.Export.CircuitBreaker.RetentionPolicy))),
+		SpanWatchlistSize: r.resolveInt("SpanWatchlist.Size", "DT_SPAN_WATCHLIST_SIZE", fileConfig.SpanWatchlist.Size),
+		SpanWatchlistOverflowPolicy: SpanWatchlistOverflowPolicy(r.resolveString("SpanWatchlist.OverflowPolicy",
+			"DT_SPAN_WATCHLIST_OVERFLOW_POLICY", string(fileConfig.SpanWatchlist.OverflowPolicy))),
+		OpenSpanTimeoutMs: r.resolveInt("SpanWatchlist.OpenSpanTimeoutMs", "DT_SPAN_WATCHLIST_OPEN_SPAN_TIMEOUT_MS",
+			fileConfig.SpanWatchlist.OpenSpanTimeoutMs),
+		ServiceName: r.resolveString("ServiceName", "DT_SERVICE_NAME", fileConfig.ServiceName, "OTEL_SERVICE_NAME"),
+		ResourceAttributes: r.resolveString("ResourceAttributes", "DT_RESOURCE_ATTRIBUTES",
+			fileConfig.ResourceAttributes, "OTEL_RESOURCE_ATTRIBUTES"),
+		TracesSampler: TracesSampler(r.resolveString("Traces.Sampler", "DT_TRACES_SAMPLER",
+			string(fileConfig.Traces.Sampler), "OTEL_TRACES_SAMPLER")),
+		TracesSamplerArg: r.resolveString("Traces.SamplerArg", "DT_TRACES_SAMPLER_ARG", fileConfig.Traces.SamplerArg,
+			"OTEL_TRACES_SAMPLER_ARG"),
+		SpanAttributeValueLengthLimit: r.resolveInt("SpanLimits.AttributeValueLength",
+			"DT_SPAN_LIMITS_ATTRIBUTE_VALUE_LENGTH", fileConfig.SpanLimits.AttributeValueLength,
+			"OTEL_SPAN_ATTRIBUTE_VALUE_LENGTH_LIMIT", "OTEL_ATTRIBUTE_VALUE_LENGTH_LIMIT"),
+		SpanAttributeCountLimit: r.resolveInt("SpanLimits.AttributeCount", "DT_SPAN_LIMITS_ATTRIBUTE_COUNT",
+			fileConfig.SpanLimits.AttributeCount, "OTEL_SPAN_ATTRIBUTE_COUNT_LIMIT", "OTEL_ATTRIBUTE_COUNT_LIMIT"),
+		SpanEventCountLimit: r.resolveInt("SpanLimits.EventCount", "DT_SPAN_LIMITS_EVENT_COUNT",
+			fileConfig.SpanLimits.EventCount, "OTEL_SPAN_EVENT_COUNT_LIMIT"),
+		SpanAttributePerEventCountLimit: r.resolveInt("SpanLimits.AttributePerEventCount",
+			"DT_SPAN_LIMITS_ATTRIBUTE_PER_EVENT_COUNT", fileConfig.SpanLimits.AttributePerEventCount,
+			"OTEL_EVENT_ATTRIBUTE_COUNT_LIMIT"),
+		SpanLinkCountLimit: r.resolveInt("SpanLimits.LinkCount", "DT_SPAN_LIMITS_LINK_COUNT",
+			fileConfig.SpanLimits.LinkCount, "OTEL_SPAN_LINK_COUNT_LIMIT"),
+		SpanAttributePerLinkCountLimit: r.resolveInt("SpanLimits.AttributePerLinkCount",
+			"DT_SPAN_LIMITS_ATTRIBUTE_PER_LINK_COUNT", fileConfig.SpanLimits.AttributePerLinkCount,
+			"OTEL_LINK_ATTRIBUTE_COUNT_LIMIT"),
 	}
+	config.Warnings = r.warnings()
 
-	// A potential trailing forward slash in BaseUrl value must be gracefully handled
-	config.BaseUrl = strings.TrimSuffix(config.BaseUrl, "/")
+	return config, r
+}
+
+// BuildConfiguration creates a configuration from programmatically provided values, e.g. when the tenant, token and
+// base URL are obtained from a secret store at runtime. The given values are copied, default values are applied and
+// the result is validated the same way as configuration provided by environment variables or by file.
+// A new AgentId is generated if none is provided.
+func BuildConfiguration(values DtConfiguration) (*DtConfiguration, error) {
+	config := values
+	if config.RumClientIpHeaders != nil {
+		config.RumClientIpHeaders = append([]string(nil), values.RumClientIpHeaders...)
+	}
+	if config.BaseUrls != nil {
+		config.BaseUrls = append(UrlList(nil), values.BaseUrls...)
+	}
+
+	if config.AgentId == 0 {
+		config.AgentId = generateAgentId()
+	}
+
+	if err := completeConfiguration(&config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Validate checks that the configuration contains all required values and that they have a valid format.
+func (config *DtConfiguration) Validate() error {
+	return validateConfiguration(config)
+}
+
+// Complete returns the configuration with default values and derived values like BuildConfiguration does, or the
+// first validation problem. A configuration which has been created with BuildConfiguration or loaded by
+// GlobalConfigurationProvider is only validated and returned itself, otherwise a completed copy is returned.
+func (config *DtConfiguration) Complete() (*DtConfiguration, error) {
+	if config.complete {
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+
+	return BuildConfiguration(*config)
+}
+
+// completeConfiguration normalizes the configuration, sets default values, validates it and calculates derived values.
+func completeConfiguration(config *DtConfiguration) error {
+	if err := normalizeConfiguration(config); err != nil {
+		return err
+	}
 
-	setDefaultConfigValues(config)
 	if validationErr := validateConfiguration(config); validationErr != nil {
-		return nil, validationErr
+		return validationErr
 	}
 
 	config.tenantId = util.CalculateTenantId(config.Tenant)
+	config.complete = true
+	return nil
+}
 
-	return config, nil
+// normalizeConfiguration trims the BaseUrl and BaseUrls, reads the AuthTokenFile and sets default values. Default
+// values are set even if the AuthTokenFile can not be read.
+func normalizeConfiguration(config *DtConfiguration) error {
+	// A potential trailing forward slash in BaseUrl value must be gracefully handled
+	config.BaseUrl = strings.TrimSuffix(config.BaseUrl, "/")
+	normalizeBaseUrls(config)
+	config.ProxyUrl = strings.TrimSpace(config.ProxyUrl)
+	config.TlsMinVersion = TlsVersion(strings.TrimSpace(string(config.TlsMinVersion)))
+	config.TracesSampler = TracesSampler(strings.ToLower(strings.TrimSpace(string(config.TracesSampler))))
+
+	var err error
+	if config.AuthTokenFile != "" && !config.AgentDisabled {
+		var authToken string
+		if authToken, err = readSecretFile(config.AuthTokenFile); err == nil {
+			config.AuthToken = authToken
+		} else {
+			err = fmt.Errorf("AuthTokenFile can not be read: %w", err)
+		}
+	}
+
+	setDefaultConfigValues(config)
+	return err
+}
+
+// normalizeBaseUrls trims the BaseUrls and removes empty ones. BaseUrl is set to the first of the BaseUrls, which take
+// precedence, or BaseUrls are set to the BaseUrl if they are not specified.
+func normalizeBaseUrls(config *DtConfiguration) {
+	var baseUrls UrlList
+	for _, baseUrl := range config.BaseUrls {
+		if baseUrl = strings.TrimSuffix(strings.TrimSpace(baseUrl), "/"); baseUrl != "" {
+			baseUrls = append(baseUrls, baseUrl)
+		}
+	}
+
+	if len(baseUrls) > 0 {
+		config.BaseUrl = baseUrls[0]
+		config.BaseUrls = baseUrls
+	} else if config.BaseUrl != "" {
+		config.BaseUrls = UrlList{config.BaseUrl}
+	}
 }
 
 func setDefaultConfigValues(config *DtConfiguration) {
+	if config.Platform == "" {
+		config.Platform = detectPlatform()
+	}
+
 	if config.LoggingDestination == "" {
 		config.LoggingDestination = LoggingDestination_Off
 	}
@@ -144,39 +576,345 @@ func setDefaultConfigValues(config *DtConfiguration) {
 	if config.SpanProcessingIntervalMs == 0 {
 		config.SpanProcessingIntervalMs = DefaultSpanProcessingIntervalMs
 	}
+
+	if config.PersistentQueueMaxSizeMb == 0 {
+		config.PersistentQueueMaxSizeMb = DefaultPersistentQueueMaxSizeMb
+	}
+
+	if config.ExportCompression == "" {
+		config.ExportCompression = ExportCompression_None
+	}
+
+	if config.ExportChunkSizeKb == 0 {
+		config.ExportChunkSizeKb = DefaultExportChunkSizeKb
+	}
+
+	if config.ExportConcurrency == 0 {
+		config.ExportConcurrency = DefaultExportConcurrency
+	}
+
+	if config.CircuitBreakerFailureThreshold == 0 {
+		config.CircuitBreakerFailureThreshold = DefaultCircuitBreakerFailureThreshold
+	}
+
+	if config.CircuitBreakerOpenDurationMs == 0 {
+		config.CircuitBreakerOpenDurationMs = DefaultCircuitBreakerOpenDurationMs
+	}
+
+	if config.CircuitBreakerRetentionPolicy == "" {
+		config.CircuitBreakerRetentionPolicy = CircuitBreakerRetentionPolicy_Retain
+	}
+
+	if config.SpanWatchlistSize == 0 {
+		config.SpanWatchlistSize = DefaultMaxSpansWatchlistSize
+	}
+
+	if config.SpanWatchlistOverflowPolicy == "" {
+		config.SpanWatchlistOverflowPolicy = SpanWatchlistOverflowPolicy_Reject
+	}
+
+	if config.OpenSpanTimeoutMs == 0 {
+		config.OpenSpanTimeoutMs = DefaultOpenSpanTimeoutMs
+	}
+
+	if config.KeepAliveIntervalMs == 0 {
+		config.KeepAliveIntervalMs = DefaultKeepAliveIntervalMs
+	}
+
+	if config.FlushExportConnTimeoutMs == 0 {
+		config.FlushExportConnTimeoutMs = DefaultFlushExportConnTimeoutMs
+	}
+
+	if config.FlushExportDataTimeoutMs == 0 {
+		config.FlushExportDataTimeoutMs = DefaultFlushExportDataTimeoutMs
+	}
+
+	if config.RegularExportConnTimeoutMs == 0 {
+		config.RegularExportConnTimeoutMs = DefaultRegularExportConnTimeoutMs
+	}
+
+	if config.RegularExportDataTimeoutMs == 0 {
+		config.RegularExportDataTimeoutMs = DefaultRegularExportDataTimeoutMs
+	}
+
+	if config.FlushOrShutdownTimeoutMs == 0 {
+		config.FlushOrShutdownTimeoutMs = config.FlushExportConnTimeoutMs + config.FlushExportDataTimeoutMs
+	}
+
+	if config.TlsMinVersion == "" {
+		config.TlsMinVersion = TlsVersion_1_2
+	}
+
+	if config.MaxIdleConns == 0 {
+		config.MaxIdleConns = DefaultMaxIdleConns
+	}
+
+	if config.MaxIdleConnsPerHost == 0 {
+		config.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+
+	if config.IdleConnTimeoutMs == 0 {
+		config.IdleConnTimeoutMs = DefaultIdleConnTimeoutMs
+	}
+
+	if config.EndpointSelection == "" {
+		config.EndpointSelection = EndpointSelection_Priority
+	}
+
+	if config.EndpointProbeIntervalMs == 0 {
+		config.EndpointProbeIntervalMs = DefaultEndpointProbeIntervalMs
+	}
 }
 
 func validateConfiguration(config *DtConfiguration) error {
+	if problems := validationProblems(config); len(problems) > 0 {
+		return problems[0]
+	}
+
+	return nil
+}
+
+// validationProblems checks all values of the configuration and returns an error for each invalid one.
+// The first error is the one returned by validateConfiguration.
+func validationProblems(config *DtConfiguration) []error {
+	var problems []error
+	if !config.AgentDisabled {
+		problems = append(problems, requiredValueProblems(config)...)
+	}
+
+	switch config.LoggingDestination {
+	case LoggingDestination_Off, LoggingDestination_Stdout, LoggingDestination_Stderr:
+		// valid, do nothing
+	default:
+		problems = append(problems, fmt.Errorf("LoggingDestination must be one of: %s, %s, %s",
+			LoggingDestination_Off, LoggingDestination_Stdout, LoggingDestination_Stderr))
+	}
+
+	if config.PersistentQueueMaxSizeMb < 0 {
+		problems = append(problems, errors.New("PersistentQueueMaxSizeMb must not be negative."))
+	}
+
+	switch config.ExportCompression {
+	case "", ExportCompression_None, ExportCompression_Gzip:
+		// valid, do nothing
+	default:
+		problems = append(problems,
+			fmt.Errorf("ExportCompression must be one of: %s, %s", ExportCompression_None, ExportCompression_Gzip))
+	}
+
+	if config.ExportChunkSizeKb < 0 || config.ExportChunkSizeKb > MaxExportChunkSizeKb {
+		problems = append(problems, fmt.Errorf("ExportChunkSizeKb must be between 0 and %d.", MaxExportChunkSizeKb))
+	}
+
+	if config.ExportConcurrency < 0 {
+		problems = append(problems, errors.New("ExportConcurrency must not be negative."))
+	}
+
+	if config.CircuitBreakerFailureThreshold < 0 {
+		problems = append(problems, errors.New("CircuitBreakerFailureThreshold must not be negative."))
+	}
+
+	if config.CircuitBreakerOpenDurationMs < 0 {
+		problems = append(problems, errors.New("CircuitBreakerOpenDurationMs must not be negative."))
+	}
+
+	switch config.CircuitBreakerRetentionPolicy {
+	case "", CircuitBreakerRetentionPolicy_Retain, CircuitBreakerRetentionPolicy_Drop:
+		// valid, do nothing
+	default:
+		problems = append(problems, fmt.Errorf("CircuitBreakerRetentionPolicy must be one of: %s, %s",
+			CircuitBreakerRetentionPolicy_Retain, CircuitBreakerRetentionPolicy_Drop))
+	}
+
+	if config.SpanWatchlistSize < 0 {
+		problems = append(problems, errors.New("SpanWatchlistSize must not be negative."))
+	}
+
+	if config.SpanProcessingIntervalMs < 0 {
+		problems = append(problems, errors.New("SpanProcessingIntervalMs must not be negative."))
+	}
+
+	if config.OpenSpanTimeoutMs < 0 {
+		problems = append(problems, errors.New("OpenSpanTimeoutMs must not be negative."))
+	}
+
+	if config.KeepAliveIntervalMs < 0 {
+		problems = append(problems, errors.New("KeepAliveIntervalMs must not be negative."))
+	}
+
+	if config.KeepAliveIntervalMs > 0 && config.OpenSpanTimeoutMs > 0 && config.KeepAliveIntervalMs >= config.OpenSpanTimeoutMs {
+		problems = append(problems, errors.New("KeepAliveIntervalMs must be less than OpenSpanTimeoutMs."))
+	}
+
+	timeouts := []struct {
+		name  string
+		value int
+	}{
+		{"FlushExportConnTimeoutMs", config.FlushExportConnTimeoutMs},
+		{"FlushExportDataTimeoutMs", config.FlushExportDataTimeoutMs},
+		{"RegularExportConnTimeoutMs", config.RegularExportConnTimeoutMs},
+		{"RegularExportDataTimeoutMs", config.RegularExportDataTimeoutMs},
+		{"FlushOrShutdownTimeoutMs", config.FlushOrShutdownTimeoutMs},
+		{"IdleConnTimeoutMs", config.IdleConnTimeoutMs},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			problems = append(problems, fmt.Errorf("%s must not be negative.", timeout.name))
+		}
+	}
+
+	switch config.SpanWatchlistOverflowPolicy {
+	case "", SpanWatchlistOverflowPolicy_Reject, SpanWatchlistOverflowPolicy_EvictOldest, SpanWatchlistOverflowPolicy_Export:
+		// valid, do nothing
+	default:
+		problems = append(problems, fmt.Errorf("SpanWatchlistOverflowPolicy must be one of: %s, %s, %s",
+			SpanWatchlistOverflowPolicy_Reject, SpanWatchlistOverflowPolicy_EvictOldest, SpanWatchlistOverflowPolicy_Export))
+	}
+
+	switch config.TracesSampler {
+	case "", TracesSampler_AlwaysOn, TracesSampler_AlwaysOff, TracesSampler_ParentBasedAlwaysOn,
+		TracesSampler_ParentBasedAlwaysOff:
+		// valid, do nothing
+	case TracesSampler_TraceIdRatio, TracesSampler_ParentBasedTraceIdRatio:
+		if _, err := parseTraceIdRatio(config.TracesSamplerArg); err != nil {
+			problems = append(problems, err)
+		}
+	default:
+		problems = append(problems, fmt.Errorf("TracesSampler must be one of: %s, %s, %s, %s, %s, %s",
+			TracesSampler_AlwaysOn, TracesSampler_AlwaysOff, TracesSampler_TraceIdRatio, TracesSampler_ParentBasedAlwaysOn,
+			TracesSampler_ParentBasedAlwaysOff, TracesSampler_ParentBasedTraceIdRatio))
+	}
+
+	if _, err := parseResourceAttributes(config.ResourceAttributes); err != nil {
+		problems = append(problems, err)
+	}
+
+	return append(problems, connectionProblems(config)...)
+}
+
+// connectionProblems checks the proxy, TLS and connection pool settings. The files are not read, an unreadable
+// certificate is reported when the span exporter is created.
+func connectionProblems(config *DtConfiguration) []error {
+	var problems []error
+	if config.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(config.ProxyUrl)
+		if err != nil || proxyUrl.Host == "" ||
+			(proxyUrl.Scheme != "http" && proxyUrl.Scheme != "https" && proxyUrl.Scheme != "socks5") {
+			problems = append(problems, errors.New("ProxyUrl does not have valid format."))
+		}
+	} else if config.ProxyUsername != "" || config.ProxyPassword != "" {
+		problems = append(problems, errors.New("ProxyUsername and ProxyPassword require a ProxyUrl."))
+	}
+
+	if (config.ClientCertFile == "") != (config.ClientKeyFile == "") {
+		problems = append(problems, errors.New("ClientCertFile and ClientKeyFile must be specified together."))
+	}
+
+	switch config.TlsMinVersion {
+	case "", TlsVersion_1_0, TlsVersion_1_1, TlsVersion_1_2, TlsVersion_1_3:
+		// valid, do nothing
+	default:
+		problems = append(problems, fmt.Errorf("TlsMinVersion must be one of: %s, %s, %s, %s",
+			TlsVersion_1_0, TlsVersion_1_1, TlsVersion_1_2, TlsVersion_1_3))
+	}
+
+	if config.MaxIdleConns < 0 {
+		problems = append(problems, errors.New("MaxIdleConns must not be negative."))
+	}
+
+	if config.MaxIdleConnsPerHost < 0 {
+		problems = append(problems, errors.New("MaxIdleConnsPerHost must not be negative."))
+	}
+
+	// the first of the BaseUrls is checked as BaseUrl
+	for i, baseUrl := range config.BaseUrls {
+		if _, err := url.ParseRequestURI(baseUrl); i > 0 && err != nil {
+			problems = append(problems, errors.New("BaseUrls do not have valid format."))
+			break
+		}
+	}
+
+	switch config.EndpointSelection {
+	case "", EndpointSelection_Priority, EndpointSelection_RoundRobin:
+		// valid, do nothing
+	default:
+		problems = append(problems, fmt.Errorf("EndpointSelection must be one of: %s, %s",
+			EndpointSelection_Priority, EndpointSelection_RoundRobin))
+	}
+
+	if config.EndpointProbeIntervalMs < 0 {
+		problems = append(problems, errors.New("EndpointProbeIntervalMs must not be negative."))
+	}
+
+	return problems
+}
+
+// parseTraceIdRatio parses the argument of the trace ID ratio based samplers, the ratio is 1 if it is empty.
+func parseTraceIdRatio(samplerArg string) (float64, error) {
+	if strings.TrimSpace(samplerArg) == "" {
+		return 1, nil
+	}
+
+	ratio, err := strconv.ParseFloat(strings.TrimSpace(samplerArg), 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		return 1, errors.New("TracesSamplerArg must be a number between 0 and 1.")
+	}
+	return ratio, nil
+}
+
+// parseResourceAttributes parses a comma separated list of key=value pairs and decodes the percent-encoded values like
+// the SDK does for OTEL_RESOURCE_ATTRIBUTES. Pairs without a key or with an invalid value are not returned.
+func parseResourceAttributes(resourceAttributes string) (map[string]string, error) {
+	values := map[string]string{}
+	if strings.TrimSpace(resourceAttributes) == "" {
+		return values, nil
+	}
+
+	var err error
+	for _, pair := range strings.Split(resourceAttributes, ",") {
+		keyValue := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if len(keyValue) != 2 || key == "" {
+			err = errors.New("ResourceAttributes must be a comma separated list of key=value pairs.")
+			continue
+		}
+
+		value, decodeErr := url.PathUnescape(strings.TrimSpace(keyValue[1]))
+		if decodeErr != nil {
+			err = fmt.Errorf("ResourceAttributes must contain percent-encoded values, the value of %s is invalid.", key)
+			continue
+		}
+		values[key] = value
+	}
+
+	return values, err
+}
+
+// requiredValueProblems checks the values which are required to export spans and to propagate the Dynatrace trace context.
+func requiredValueProblems(config *DtConfiguration) []error {
+	var problems []error
 	if config.Tenant == "" {
-		return errors.New("Tenant must be specified in configuration.")
+		problems = append(problems, errors.New("Tenant must be specified in configuration."))
 	}
 
 	if config.ClusterId == 0 {
-		return errors.New("ClusterId must be specified in configuration.")
+		problems = append(problems, errors.New("ClusterId must be specified in configuration."))
 	}
 
 	if config.BaseUrl == "" {
-		return errors.New("BaseUrl must be specified in configuration.")
+		problems = append(problems, errors.New("BaseUrl must be specified in configuration."))
 	} else {
 		_, err := url.ParseRequestURI(config.BaseUrl)
 		if err != nil {
-			return errors.New("BaseUrl does does not have valid format.")
+			problems = append(problems, errors.New("BaseUrl does does not have valid format."))
 		}
 	}
 
 	if config.AuthToken == "" {
-		return errors.New("AuthToken must be specified in configuration.")
+		problems = append(problems, errors.New("AuthToken must be specified in configuration."))
 	}
 
-	switch config.LoggingDestination {
-	case LoggingDestination_Off, LoggingDestination_Stdout, LoggingDestination_Stderr:
-		// valid, do nothing
-	default:
-		return fmt.Errorf("LoggingDestionation must be one of: %s, %s, %s",
-			LoggingDestination_Off, LoggingDestination_Stdout, LoggingDestination_Stderr)
-	}
-
-	return nil
+	return problems
 }
 
 func generateAgentId() int64 {
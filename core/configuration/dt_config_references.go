@@ -0,0 +1,150 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const (
+	cReferenceStart      = "${"
+	cReferenceEnd        = "}"
+	cFileReferencePrefix = "file:"
+)
+
+// expandConfigReferences replaces references in all string values of the config file, so that secrets do not have
+// to be stored in the config file itself:
+//
+//	${ENV_VAR}     is replaced by the value of the environment variable ENV_VAR
+//	${file:/path}  is replaced by the content of the file, without leading and trailing white space
+//	$${            is replaced by a literal ${
+//
+// Errors name the config file key, but never contain the resolved values.
+func expandConfigReferences(cfg *fileConfig) error {
+	return expandReferencesInStruct(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func expandReferencesInStruct(v reflect.Value, path string) error {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// unexported fields are not read from the file
+			continue
+		}
+
+		fieldPath := field.Name
+		if path != "" {
+			fieldPath = path + "." + field.Name
+		}
+
+		if err := expandReferencesInValue(v.Field(i), fieldPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func expandReferencesInValue(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		expanded, err := expandReferences(v.String())
+		if err != nil {
+			return fmt.Errorf("can not expand %s: %w", path, err)
+		}
+		v.SetString(expanded)
+	case reflect.Struct:
+		return expandReferencesInStruct(v, path)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandReferencesInValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// expandReferences replaces all references in the given value.
+func expandReferences(value string) (string, error) {
+	var expanded strings.Builder
+	for {
+		start := strings.Index(value, cReferenceStart)
+		if start < 0 {
+			expanded.WriteString(value)
+			return expanded.String(), nil
+		}
+
+		if start > 0 && value[start-1] == '$' {
+			expanded.WriteString(value[:start-1] + cReferenceStart)
+			value = value[start+len(cReferenceStart):]
+			continue
+		}
+
+		end := strings.Index(value[start:], cReferenceEnd)
+		if end < 0 {
+			return "", errors.New("reference is not terminated by " + cReferenceEnd)
+		}
+
+		resolved, err := resolveReference(value[start+len(cReferenceStart) : start+end])
+		if err != nil {
+			return "", err
+		}
+
+		expanded.WriteString(value[:start] + resolved)
+		value = value[start+end+len(cReferenceEnd):]
+	}
+}
+
+// resolveReference returns the value of a single reference, i.e. the content between ${ and }.
+func resolveReference(reference string) (string, error) {
+	if strings.HasPrefix(reference, cFileReferencePrefix) {
+		path := strings.TrimPrefix(reference, cFileReferencePrefix)
+		return readSecretFile(path)
+	}
+
+	if reference == "" {
+		return "", errors.New("reference does not name an environment variable")
+	}
+
+	value, found := os.LookupEnv(reference)
+	if !found {
+		return "", fmt.Errorf("environment variable %s is not set", reference)
+	}
+
+	return value, nil
+}
+
+// readSecretFile reads a value from a file, e.g. a mounted Kubernetes secret. Leading and trailing white space,
+// typically a trailing line break, is removed.
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("file reference does not contain a path")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
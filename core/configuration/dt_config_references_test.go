@@ -0,0 +1,106 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandReferences(t *testing.T) {
+	os.Setenv("DT_TEST_REFERENCE", "value")
+	defer os.Unsetenv("DT_TEST_REFERENCE")
+
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(t, ioutil.WriteFile(secretPath, []byte("  secret\n"), 0600))
+
+	tests := map[string]string{
+		"plain":                      "plain",
+		"${DT_TEST_REFERENCE}":       "value",
+		"a-${DT_TEST_REFERENCE}-b":   "a-value-b",
+		"${file:" + secretPath + "}": "secret",
+		"${DT_TEST_REFERENCE}/${file:" + secretPath + "}": "value/secret",
+		"$${DT_TEST_REFERENCE}":                           "${DT_TEST_REFERENCE}",
+		"$DT_TEST_REFERENCE":                              "$DT_TEST_REFERENCE",
+	}
+	for value, expected := range tests {
+		expanded, err := expandReferences(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, expanded, value)
+	}
+}
+
+func TestExpandReferencesErrors(t *testing.T) {
+	for _, value := range []string{
+		"${DT_TEST_REFERENCE_NOT_SET}",
+		"${}",
+		"${DT_TEST_REFERENCE",
+		"${file:}",
+		"${file:" + filepath.Join(t.TempDir(), "missing") + "}",
+	} {
+		_, err := expandReferences(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestExpandConfigReferences(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, ioutil.WriteFile(secretPath, []byte("dt0a01.secret\n"), 0600))
+	os.Setenv("DT_TEST_TENANT", "tenant")
+	os.Setenv("DT_TEST_IP_HEADER", "x-client-ip")
+	defer os.Unsetenv("DT_TEST_TENANT")
+	defer os.Unsetenv("DT_TEST_IP_HEADER")
+
+	cfg := fileConfig{Tenant: "${DT_TEST_TENANT}"}
+	cfg.Connection.AuthToken = "${file:" + secretPath + "}"
+	cfg.Logging.Destination = "${DT_TEST_TENANT}"
+	cfg.RUM.ClientIpHeaders = []string{"forwarded", "${DT_TEST_IP_HEADER}"}
+
+	assert.NoError(t, expandConfigReferences(&cfg))
+	assert.Equal(t, "tenant", cfg.Tenant)
+	assert.Equal(t, "dt0a01.secret", cfg.Connection.AuthToken)
+	assert.EqualValues(t, "tenant", cfg.Logging.Destination, "named string types are expanded as well")
+	assert.Equal(t, []string{"forwarded", "x-client-ip"}, cfg.RUM.ClientIpHeaders)
+}
+
+func TestExpandConfigReferencesErrorNamesKey(t *testing.T) {
+	cfg := fileConfig{}
+	cfg.RUM.ClientIpHeaders = []string{"forwarded", "${DT_TEST_REFERENCE_NOT_SET}"}
+
+	err := expandConfigReferences(&cfg)
+	assert.EqualError(t, err, "can not expand RUM.ClientIpHeaders[1]: environment variable DT_TEST_REFERENCE_NOT_SET is not set")
+}
+
+func TestJsonConfigFileReader_ExpandsReferences(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, ioutil.WriteFile(tokenPath, []byte("dt0a01.secret"), 0600))
+	os.Setenv("DT_TEST_BASE_URL", "https://example.com")
+	defer os.Unsetenv("DT_TEST_BASE_URL")
+
+	configPath := filepath.Join(t.TempDir(), "dtconfig.json")
+	assert.NoError(t, ioutil.WriteFile(configPath, []byte(`{"Connection": {
+		"BaseUrl": "${DT_TEST_BASE_URL}/e/tenant",
+		"AuthToken": "${file:`+tokenPath+`}"
+	}}`), 0600))
+
+	cfg, err := (&dtConfigFileReader{}).readConfigFromFileByPath(configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/e/tenant", cfg.Connection.BaseUrl)
+	assert.Equal(t, "dt0a01.secret", cfg.Connection.AuthToken)
+}
@@ -0,0 +1,511 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration/internal/util"
+)
+
+// ConfigurationSource tells where the effective value of a setting comes from.
+type ConfigurationSource string
+
+const (
+	ConfigurationSource_Env     ConfigurationSource = "env"
+	ConfigurationSource_File    ConfigurationSource = "file"
+	ConfigurationSource_Default ConfigurationSource = "default"
+	// ConfigurationSource_Unset is used for settings which are neither provided nor have a default value.
+	ConfigurationSource_Unset ConfigurationSource = "unset"
+)
+
+const cRedactedValue = "<redacted>"
+
+// secretConfigKeys are the settings whose values are never part of a report.
+var secretConfigKeys = map[string]bool{
+	"Connection.AuthToken":     true,
+	"Connection.ProxyPassword": true,
+}
+
+// ConfigurationSetting is the effective value of a setting and where it comes from.
+type ConfigurationSetting struct {
+	// Key is the path of the setting in the config file, e.g. "Connection.BaseUrl".
+	Key string `json:"key"`
+	// EnvVar is the environment variable which overrides the value of the config file. If the value has been taken
+	// from an OTEL_* environment variable, since the setting is not provided otherwise, it is that variable.
+	EnvVar string `json:"envVar"`
+	// Value is the effective value after default values have been applied. Secrets are redacted.
+	Value  string              `json:"value"`
+	Source ConfigurationSource `json:"source"`
+	// FilePath is the path of the file the value has been read from, if the source is a file. This is either the
+	// config file or, for the auth token, the AuthTokenFile.
+	FilePath string `json:"filePath,omitempty"`
+	// FileLine is the line of the key in the config file, it is 0 if the line is unknown.
+	FileLine int `json:"fileLine,omitempty"`
+}
+
+// Origin describes the source of the setting, e.g. "env DT_TENANT" or "file ./dtconfig.yaml:3".
+func (s ConfigurationSetting) Origin() string {
+	switch s.Source {
+	case ConfigurationSource_Env:
+		return "env " + s.EnvVar
+	case ConfigurationSource_File:
+		if s.FileLine > 0 {
+			return "file " + s.FilePath + ":" + strconv.Itoa(s.FileLine)
+		}
+		return "file " + s.FilePath
+	default:
+		return string(s.Source)
+	}
+}
+
+// ConfigurationReport lists every effective setting with its source and all problems of the configuration.
+type ConfigurationReport struct {
+	// ConfigFilePath is the path of the config file which has been found, it is empty if there is none.
+	ConfigFilePath string `json:"configFilePath"`
+	// Platform is the detected platform, it determines the directories in which the config file is looked for.
+	Platform Platform               `json:"platform"`
+	Settings []ConfigurationSetting `json:"settings"`
+	// Problems contains the environment variables which can not be parsed and are ignored, an unreadable config file
+	// or AuthTokenFile and all validation errors.
+	Problems []string `json:"problems"`
+	// Warnings contains the OTEL_* environment variables which are ignored since a Dynatrace setting takes precedence.
+	Warnings []string `json:"warnings"`
+}
+
+// LoadConfigurationReport loads the configuration from environment variables and from file, like GetConfiguration
+// does, and reports where each value comes from. Unlike GetConfiguration, loading does not stop at the first problem,
+// all problems are part of the report.
+func LoadConfigurationReport() *ConfigurationReport {
+	return loadConfigurationReport(&dtConfigFileReader{})
+}
+
+func loadConfigurationReport(configFileReader configFileReader) *ConfigurationReport {
+	report := &ConfigurationReport{Problems: []string{}, Warnings: []string{}}
+
+	fileConfig, err := configFileReader.readConfigFromFile()
+	if err != nil {
+		// a missing config file is fine, unless a path has been given explicitly
+		if !errors.Is(err, os.ErrNotExist) || os.Getenv("DT_CONFIG_FILE_PATH") != "" {
+			report.Problems = append(report.Problems, "Config file can not be read: "+err.Error())
+		}
+		fileConfig.path = ""
+	}
+
+	config, resolver := resolveConfiguration(fileConfig)
+	report.ConfigFilePath = config.ConfigFilePath
+	report.Platform = detectPlatform()
+	report.Problems = append(report.Problems, resolver.problems...)
+	report.Warnings = append(report.Warnings, resolver.conflicts...)
+
+	if err := normalizeConfiguration(config); err != nil {
+		report.Problems = append(report.Problems, err.Error())
+	} else if config.AuthTokenFile != "" && !config.AgentDisabled {
+		if authToken, found := resolver.setting("Connection.AuthToken"); found {
+			authToken.source = ConfigurationSource_File
+			authToken.filePath = config.AuthTokenFile
+			authToken.fileLine = 0
+		}
+	}
+
+	for _, problem := range validationProblems(config) {
+		report.Problems = append(report.Problems, problem.Error())
+	}
+
+	values := config.settingValues()
+	for _, setting := range resolver.settings {
+		report.Settings = append(report.Settings, setting.report(values[setting.key]))
+	}
+
+	return report
+}
+
+// String formats the report as a table of settings followed by the list of problems.
+func (r *ConfigurationReport) String() string {
+	var sb strings.Builder
+
+	configFilePath := r.ConfigFilePath
+	if configFilePath == "" {
+		configFilePath = "none"
+	}
+	sb.WriteString("Platform: " + string(r.Platform) + "\n")
+	sb.WriteString("Config file: " + configFilePath + "\n\n")
+
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, setting := range r.Settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, setting.Value, setting.Origin())
+	}
+	w.Flush()
+
+	if len(r.Warnings) > 0 {
+		sb.WriteString("\nWarnings:\n")
+		for _, warning := range r.Warnings {
+			sb.WriteString("  - " + warning + "\n")
+		}
+	}
+
+	if len(r.Problems) == 0 {
+		sb.WriteString("\nNo problems found.\n")
+		return sb.String()
+	}
+
+	sb.WriteString("\nProblems:\n")
+	for _, problem := range r.Problems {
+		sb.WriteString("  - " + problem + "\n")
+	}
+
+	return sb.String()
+}
+
+// configSetting records where the value of a configuration field comes from.
+type configSetting struct {
+	key      string
+	envVar   string
+	source   ConfigurationSource
+	filePath string
+	fileLine int
+}
+
+// report describes the setting with the given effective value, which is taken after default values have been applied.
+func (s *configSetting) report(value interface{}) ConfigurationSetting {
+	source := s.source
+	if source == ConfigurationSource_Unset && !isZeroConfigValue(value) {
+		source = ConfigurationSource_Default
+	}
+
+	return ConfigurationSetting{
+		Key:      s.key,
+		EnvVar:   s.envVar,
+		Value:    redactConfigValue(s.key, value),
+		Source:   source,
+		FilePath: s.filePath,
+		FileLine: s.fileLine,
+	}
+}
+
+func isZeroConfigValue(value interface{}) bool {
+	switch v := value.(type) {
+	case int:
+		return v == 0
+	case int32:
+		return v == 0
+	case bool:
+		return !v
+	case []string:
+		return len(v) == 0
+	case UrlList:
+		return len(v) == 0
+	default:
+		// the remaining settings are strings or string based enums
+		return fmt.Sprint(value) == ""
+	}
+}
+
+// settingValues returns the effective value of each setting of the configuration by its key.
+func (config *DtConfiguration) settingValues() map[string]interface{} {
+	return map[string]interface{}{
+		"AgentActive":                            !config.AgentDisabled,
+		"ClusterID":                              config.ClusterId,
+		"Tenant":                                 config.Tenant,
+		"Connection.BaseUrl":                     config.BaseUrl,
+		"Connection.BaseUrls":                    config.BaseUrls,
+		"Connection.EndpointSelection":           config.EndpointSelection,
+		"Connection.EndpointProbeIntervalMs":     config.EndpointProbeIntervalMs,
+		"Connection.AuthToken":                   config.AuthToken,
+		"Connection.AuthTokenFile":               config.AuthTokenFile,
+		"Connection.ProxyUrl":                    config.ProxyUrl,
+		"Connection.ProxyUsername":               config.ProxyUsername,
+		"Connection.ProxyPassword":               config.ProxyPassword,
+		"Connection.CaBundleFile":                config.CaBundleFile,
+		"Connection.ClientCertFile":              config.ClientCertFile,
+		"Connection.ClientKeyFile":               config.ClientKeyFile,
+		"Connection.TlsMinVersion":               config.TlsMinVersion,
+		"Connection.MaxIdleConns":                config.MaxIdleConns,
+		"Connection.MaxIdleConnsPerHost":         config.MaxIdleConnsPerHost,
+		"Connection.IdleConnTimeoutMs":           config.IdleConnTimeoutMs,
+		"Testability.SpanProcessingIntervalMs":   config.SpanProcessingIntervalMs,
+		"Testability.KeepAliveIntervalMs":        config.KeepAliveIntervalMs,
+		"RUM.ClientIpHeaders":                    config.RumClientIpHeaders,
+		"Debug.AddStackOnStart":                  config.DebugAddStackOnStart,
+		"Logging.Destination":                    config.LoggingDestination,
+		"Logging.Go.Flags":                       config.LoggingFlags,
+		"Export.PersistentQueue.Directory":       config.PersistentQueueDirectory,
+		"Export.PersistentQueue.MaxSizeMb":       config.PersistentQueueMaxSizeMb,
+		"Export.Compression":                     config.ExportCompression,
+		"Export.FlushConnTimeoutMs":              config.FlushExportConnTimeoutMs,
+		"Export.FlushDataTimeoutMs":              config.FlushExportDataTimeoutMs,
+		"Export.RegularConnTimeoutMs":            config.RegularExportConnTimeoutMs,
+		"Export.RegularDataTimeoutMs":            config.RegularExportDataTimeoutMs,
+		"Export.FlushOrShutdownTimeoutMs":        config.FlushOrShutdownTimeoutMs,
+		"Export.ChunkSizeKb":                     config.ExportChunkSizeKb,
+		"Export.Concurrency":                     config.ExportConcurrency,
+		"Export.CircuitBreaker.FailureThreshold": config.CircuitBreakerFailureThreshold,
+		"Export.CircuitBreaker.OpenDurationMs":   config.CircuitBreakerOpenDurationMs,
+		"Export.CircuitBreaker.RetentionPolicy":  config.CircuitBreakerRetentionPolicy,
+		"SpanWatchlist.Size":                     config.SpanWatchlistSize,
+		"SpanWatchlist.OverflowPolicy":           config.SpanWatchlistOverflowPolicy,
+		"SpanWatchlist.OpenSpanTimeoutMs":        config.OpenSpanTimeoutMs,
+		"ServiceName":                            config.ServiceName,
+		"ResourceAttributes":                     config.ResourceAttributes,
+		"Traces.Sampler":                         config.TracesSampler,
+		"Traces.SamplerArg":                      config.TracesSamplerArg,
+		"SpanLimits.AttributeValueLength":        config.SpanAttributeValueLengthLimit,
+		"SpanLimits.AttributeCount":              config.SpanAttributeCountLimit,
+		"SpanLimits.EventCount":                  config.SpanEventCountLimit,
+		"SpanLimits.AttributePerEventCount":      config.SpanAttributePerEventCountLimit,
+		"SpanLimits.LinkCount":                   config.SpanLinkCountLimit,
+		"SpanLimits.AttributePerLinkCount":       config.SpanAttributePerLinkCountLimit,
+	}
+}
+
+// redactConfigValue formats the value of a setting, secrets and the user info of URLs are redacted.
+func redactConfigValue(key string, value interface{}) string {
+	str := fmt.Sprint(value)
+	if secretConfigKeys[key] && str != "" {
+		return cRedactedValue
+	}
+
+	if urls, ok := value.(UrlList); ok {
+		redacted := make([]string, len(urls))
+		for i, u := range urls {
+			redacted[i] = redactConfigValue(key, u)
+		}
+		return fmt.Sprint(redacted)
+	}
+
+	if u, err := url.Parse(str); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+
+	return str
+}
+
+// configSettingsResolver takes the values of settings from environment variables or from the config file and
+// records where each value comes from.
+type configSettingsResolver struct {
+	fileConfig fileConfig
+	settings   []*configSetting
+	// problems are the environment variables whose values can not be parsed and are ignored
+	problems []string
+	// conflicts are the OTEL_* environment variables which are ignored since a Dynatrace setting takes precedence
+	conflicts []string
+}
+
+func newConfigSettingsResolver(fileConfig fileConfig) *configSettingsResolver {
+	return &configSettingsResolver{fileConfig: fileConfig}
+}
+
+// resolveString returns the value of the environment variable or, if it is not set, the value of the key in the
+// config file. The OTEL_* environment variables are fallbacks in the given order, they are reported as conflicts if
+// they are overridden.
+func (r *configSettingsResolver) resolveString(key string, envVar string, fileValue string, otelEnvVars ...string) string {
+	setting := r.addSetting(key, envVar)
+	sameValue := func(value string) func(string) bool {
+		return func(otelEnvVar string) bool {
+			return os.Getenv(otelEnvVar) == value
+		}
+	}
+
+	if value, found := os.LookupEnv(envVar); found {
+		r.fromEnv(setting, envVar, otelEnvVars, sameValue(value))
+		return value
+	}
+
+	if r.fileConfig.isPresent(key, fileValue != "") {
+		r.fromFile(setting, otelEnvVars, sameValue(fileValue))
+		return fileValue
+	}
+
+	for _, otelEnvVar := range otelEnvVars {
+		if value, found := os.LookupEnv(otelEnvVar); found {
+			r.fromEnv(setting, otelEnvVar, nil, nil)
+			return value
+		}
+	}
+
+	return fileValue
+}
+
+// resolveInt is like resolveString for integer settings. Environment variables which can not be parsed are
+// reported as problems and ignored.
+func (r *configSettingsResolver) resolveInt(key string, envVar string, fileValue int, otelEnvVars ...string) int {
+	setting := r.addSetting(key, envVar)
+	sameValue := func(value int) func(string) bool {
+		return func(otelEnvVar string) bool {
+			otelValue, _, err := util.LookupIntFromEnv(otelEnvVar)
+			return err == nil && otelValue == value
+		}
+	}
+
+	if value, found := r.lookupInt(envVar); found {
+		r.fromEnv(setting, envVar, otelEnvVars, sameValue(value))
+		return value
+	}
+
+	if r.fileConfig.isPresent(key, fileValue != 0) {
+		r.fromFile(setting, otelEnvVars, sameValue(fileValue))
+		return fileValue
+	}
+
+	for _, otelEnvVar := range otelEnvVars {
+		if value, found := r.lookupInt(otelEnvVar); found {
+			r.fromEnv(setting, otelEnvVar, nil, nil)
+			return value
+		}
+	}
+
+	return fileValue
+}
+
+// resolveBool is like resolveString for boolean settings. Environment variables which can not be parsed are
+// reported as problems and ignored. The file value is used if the key is present in the config file.
+func (r *configSettingsResolver) resolveBool(key string, envVar string, fileValue bool, filePresent bool) bool {
+	setting := r.addSetting(key, envVar)
+	if value, found, err := util.LookupBoolFromEnv(envVar); err != nil {
+		r.addProblem(envVar, err)
+	} else if found {
+		r.fromEnv(setting, envVar, nil, nil)
+		return value
+	}
+
+	if r.fileConfig.isPresent(key, filePresent) {
+		r.fromFile(setting, nil, nil)
+	}
+	return fileValue
+}
+
+// resolveStrings is like resolveString for lists, the value of the environment variable is separated by colons.
+func (r *configSettingsResolver) resolveStrings(key string, envVar string, fileValue []string) []string {
+	setting := r.addSetting(key, envVar)
+	if value, found := util.LookupStringSliceFromEnv(envVar); found {
+		r.fromEnv(setting, envVar, nil, nil)
+		return value
+	}
+
+	if r.fileConfig.isPresent(key, len(fileValue) > 0) {
+		r.fromFile(setting, nil, nil)
+	}
+	return fileValue
+}
+
+// resolveUrls is like resolveString for lists of URLs, the value of the environment variable is separated by commas
+// since URLs contain colons.
+func (r *configSettingsResolver) resolveUrls(key string, envVar string, fileValue UrlList) UrlList {
+	setting := r.addSetting(key, envVar)
+	if value, found := util.LookupCommaSeparatedStringsFromEnv(envVar); found {
+		r.fromEnv(setting, envVar, nil, nil)
+		return value
+	}
+
+	if r.fileConfig.isPresent(key, len(fileValue) > 0) {
+		r.fromFile(setting, nil, nil)
+	}
+	return fileValue
+}
+
+func (r *configSettingsResolver) addSetting(key string, envVar string) *configSetting {
+	setting := &configSetting{key: key, envVar: envVar, source: ConfigurationSource_Unset}
+	r.settings = append(r.settings, setting)
+	return setting
+}
+
+// lookupInt parses the environment variable as an integer, a value which can not be parsed is reported as a problem.
+func (r *configSettingsResolver) lookupInt(envVar string) (int, bool) {
+	value, found, err := util.LookupIntFromEnv(envVar)
+	if err != nil {
+		r.addProblem(envVar, err)
+		return 0, false
+	}
+
+	return value, found
+}
+
+func (r *configSettingsResolver) addProblem(envVar string, err error) {
+	r.problems = append(r.problems, fmt.Sprintf("%s: %s, the value is ignored", envVar, err))
+}
+
+// fromEnv records that the value of the setting is taken from the environment variable.
+func (r *configSettingsResolver) fromEnv(
+	setting *configSetting,
+	envVar string,
+	otelEnvVars []string,
+	sameValue func(otelEnvVar string) bool,
+) {
+	setting.source = ConfigurationSource_Env
+	setting.envVar = envVar
+	r.checkOtelConflicts(envVar, otelEnvVars, sameValue)
+}
+
+// fromFile records that the value of the setting is taken from the config file.
+func (r *configSettingsResolver) fromFile(
+	setting *configSetting,
+	otelEnvVars []string,
+	sameValue func(otelEnvVar string) bool,
+) {
+	setting.source = ConfigurationSource_File
+	setting.filePath = r.fileConfig.path
+	setting.fileLine = r.fileConfig.keyLines[strings.ToLower(setting.key)]
+	r.checkOtelConflicts(setting.key+" of the config file", otelEnvVars, sameValue)
+}
+
+// checkOtelConflicts records the OTEL_* environment variables which are set to a different value than the
+// Dynatrace setting which takes precedence.
+func (r *configSettingsResolver) checkOtelConflicts(
+	precedence string,
+	otelEnvVars []string,
+	sameValue func(otelEnvVar string) bool,
+) {
+	for _, otelEnvVar := range otelEnvVars {
+		if _, found := os.LookupEnv(otelEnvVar); found && !sameValue(otelEnvVar) {
+			r.conflicts = append(r.conflicts, fmt.Sprintf("%s is ignored, %s takes precedence", otelEnvVar, precedence))
+		}
+	}
+}
+
+// warnings returns the problems and conflicts of all resolved settings.
+func (r *configSettingsResolver) warnings() []string {
+	var warnings []string
+	warnings = append(warnings, r.problems...)
+	return append(warnings, r.conflicts...)
+}
+
+// setting returns the setting of the given key and whether it has been resolved.
+func (r *configSettingsResolver) setting(key string) (*configSetting, bool) {
+	for _, setting := range r.settings {
+		if setting.key == key {
+			return setting, true
+		}
+	}
+
+	return nil, false
+}
+
+// isPresent tells whether the key is present in the config file. If the config has not been decoded from a file,
+// keys with a non-zero value are considered present.
+func (c fileConfig) isPresent(key string, nonZero bool) bool {
+	if c.keyLines == nil {
+		return nonZero
+	}
+
+	_, present := c.keyLines[strings.ToLower(key)]
+	return present
+}
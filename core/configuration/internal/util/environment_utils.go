@@ -29,32 +29,81 @@ func GetStringFromEnvWithDefault(key, defaultValue string) string {
 }
 
 func GetIntFromEnvWithDefault(key string, defaultValue int) int {
-	if str, found := os.LookupEnv(key); found {
-		intVal, err := strconv.Atoi(str)
-		if err != nil {
-			fmt.Printf("Could not parse integer value from environment variable %s\n", key)
-			return defaultValue
-		}
+	intVal, found, err := LookupIntFromEnv(key)
+	if err != nil {
+		fmt.Printf("Could not parse integer value from environment variable %s\n", key)
+		return defaultValue
+	}
+	if found {
 		return intVal
 	}
 	return defaultValue
 }
 
 func GetBoolFromEnvWithDefault(key string, defaultValue bool) bool {
-	if str, found := os.LookupEnv(key); found {
-		b, err := strconv.ParseBool(str)
-		if err != nil {
-			fmt.Printf("Could not parse boolean value from environment variable %s\n", key)
-			return defaultValue
-		}
+	b, found, err := LookupBoolFromEnv(key)
+	if err != nil {
+		fmt.Printf("Could not parse boolean value from environment variable %s\n", key)
+		return defaultValue
+	}
+	if found {
 		return b
 	}
 	return defaultValue
 }
 
 func GetStringSliceFromEnvWithDefault(key string, defaultValue []string) []string {
-	if str, found := os.LookupEnv(key); found {
-		return strings.Split(str, ":")
+	if values, found := LookupStringSliceFromEnv(key); found {
+		return values
 	}
 	return defaultValue
 }
+
+// LookupIntFromEnv returns the integer value of the environment variable and whether it is set.
+// An error is returned if the variable is set to a value which is not an integer.
+func LookupIntFromEnv(key string) (int, bool, error) {
+	str, found := os.LookupEnv(key)
+	if !found {
+		return 0, false, nil
+	}
+
+	intVal, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, true, fmt.Errorf("can not parse %q as integer", str)
+	}
+	return intVal, true, nil
+}
+
+// LookupBoolFromEnv returns the boolean value of the environment variable and whether it is set.
+// An error is returned if the variable is set to a value which is not a boolean.
+func LookupBoolFromEnv(key string) (bool, bool, error) {
+	str, found := os.LookupEnv(key)
+	if !found {
+		return false, false, nil
+	}
+
+	b, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, true, fmt.Errorf("can not parse %q as boolean", str)
+	}
+	return b, true, nil
+}
+
+// LookupStringSliceFromEnv returns the colon separated values of the environment variable and whether it is set.
+func LookupStringSliceFromEnv(key string) ([]string, bool) {
+	str, found := os.LookupEnv(key)
+	if !found {
+		return nil, false
+	}
+	return strings.Split(str, ":"), true
+}
+
+// LookupCommaSeparatedStringsFromEnv returns the comma separated values of the environment variable and whether it
+// is set.
+func LookupCommaSeparatedStringsFromEnv(key string) ([]string, bool) {
+	str, found := os.LookupEnv(key)
+	if !found {
+		return nil, false
+	}
+	return strings.Split(str, ","), true
+}
@@ -121,3 +121,61 @@ func TestGetStringSliceFromEnvWithDefault_UseDefault(t *testing.T) {
 	assert.Equal(t, GetStringSliceFromEnvWithDefault(ENV_KEY, []string{"a", "b", "c"}), []string{"a", "b", "c"})
 	assert.Equal(t, GetStringSliceFromEnvWithDefault(ENV_KEY, []string{}), []string{})
 }
+
+func TestLookupIntFromEnv(t *testing.T) {
+	defer os.Unsetenv(ENV_KEY)
+	_, found, err := LookupIntFromEnv(ENV_KEY)
+	assert.False(t, found)
+	assert.NoError(t, err)
+
+	os.Setenv(ENV_KEY, "123")
+	intVal, found, err := LookupIntFromEnv(ENV_KEY)
+	assert.Equal(t, intVal, 123)
+	assert.True(t, found)
+	assert.NoError(t, err)
+
+	os.Setenv(ENV_KEY, "foo")
+	_, found, err = LookupIntFromEnv(ENV_KEY)
+	assert.True(t, found)
+	assert.EqualError(t, err, `can not parse "foo" as integer`)
+}
+
+func TestLookupBoolFromEnv(t *testing.T) {
+	defer os.Unsetenv(ENV_KEY)
+	_, found, err := LookupBoolFromEnv(ENV_KEY)
+	assert.False(t, found)
+	assert.NoError(t, err)
+
+	os.Setenv(ENV_KEY, "false")
+	b, found, err := LookupBoolFromEnv(ENV_KEY)
+	assert.Equal(t, b, false)
+	assert.True(t, found)
+	assert.NoError(t, err)
+
+	os.Setenv(ENV_KEY, "foo")
+	_, found, err = LookupBoolFromEnv(ENV_KEY)
+	assert.True(t, found)
+	assert.EqualError(t, err, `can not parse "foo" as boolean`)
+}
+
+func TestLookupStringSliceFromEnv(t *testing.T) {
+	defer os.Unsetenv(ENV_KEY)
+	_, found := LookupStringSliceFromEnv(ENV_KEY)
+	assert.False(t, found)
+
+	os.Setenv(ENV_KEY, "foo:bar")
+	values, found := LookupStringSliceFromEnv(ENV_KEY)
+	assert.Equal(t, values, []string{"foo", "bar"})
+	assert.True(t, found)
+}
+
+func TestLookupCommaSeparatedStringsFromEnv(t *testing.T) {
+	defer os.Unsetenv(ENV_KEY)
+	_, found := LookupCommaSeparatedStringsFromEnv(ENV_KEY)
+	assert.False(t, found)
+
+	os.Setenv(ENV_KEY, "http://foo:80,http://bar:80")
+	values, found := LookupCommaSeparatedStringsFromEnv(ENV_KEY)
+	assert.Equal(t, values, []string{"http://foo:80", "http://bar:80"})
+	assert.True(t, found)
+}
@@ -40,16 +40,24 @@ const (
 var internalDtLogger dtLogger
 
 type dtLogger struct {
+	// lock guards logger and flags, since the logger may be reconfigured while it is in use
+	lock          sync.RWMutex
 	logger        *log.Logger
 	configureOnce sync.Once
 	flags         debugLogFlags
 }
 
 func (p *dtLogger) enabled() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
 	return p.logger != nil
 }
 
 func (p *dtLogger) debugFlagEnabled(flag string) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
 	if p.flags == nil {
 		return false
 	}
@@ -58,6 +66,9 @@ func (p *dtLogger) debugFlagEnabled(flag string) bool {
 }
 
 func (p *dtLogger) configure(dest configuration.LoggingDestination, flags debugLogFlags) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	switch dest {
 	case configuration.LoggingDestination_Off:
 		p.logger = nil
@@ -71,7 +82,10 @@ func (p *dtLogger) configure(dest configuration.LoggingDestination, flags debugL
 }
 
 func (p *dtLogger) log(kind logKind, component string, msg string) {
-	if !p.enabled() {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.logger == nil {
 		return
 	}
 
@@ -83,8 +97,7 @@ func (p *dtLogger) log(kind logKind, component string, msg string) {
 }
 
 type ComponentLogger struct {
-	componentName    string
-	debugFlagEnabled bool
+	componentName string
 }
 
 func init() {
@@ -97,6 +110,22 @@ func init() {
 	}
 }
 
+// Configure configures the internal logger with the given configuration unless it has already been configured.
+// It must be called before any component logger is created if the global configuration is not used.
+func Configure(config *configuration.DtConfiguration) {
+	internalDtLogger.configureOnce.Do(func() {
+		configureFromConfig(config)
+	})
+}
+
+// Reconfigure configures the internal logger with the given configuration even if it has already been configured,
+// e.g. once a valid configuration is found after a configuration error. Existing component loggers use the new
+// configuration as well.
+func Reconfigure(config *configuration.DtConfiguration) {
+	internalDtLogger.configureOnce.Do(func() {})
+	configureFromConfig(config)
+}
+
 func NewComponentLogger(componentName string) *ComponentLogger {
 	internalDtLogger.configureOnce.Do(func() {
 		config, err := configuration.GlobalConfigurationProvider.GetConfiguration()
@@ -105,19 +134,21 @@ func NewComponentLogger(componentName string) *ComponentLogger {
 			return
 		}
 
-		flags := parseLogFlags(config.LoggingFlags)
-		internalDtLogger.configure(config.LoggingDestination, flags)
-
-		logStartupBanner(config)
+		configureFromConfig(config)
 	})
 
 	return newComponentLogger(componentName)
 }
 
+func configureFromConfig(config *configuration.DtConfiguration) {
+	flags := parseLogFlags(config.LoggingFlags)
+	internalDtLogger.configure(config.LoggingDestination, flags)
+
+	logStartupBanner(config)
+}
+
 func newComponentLogger(componentName string) *ComponentLogger {
-	logger := &ComponentLogger{componentName: componentName}
-	logger.debugFlagEnabled = internalDtLogger.debugFlagEnabled(componentName)
-	return logger
+	return &ComponentLogger{componentName: componentName}
 }
 
 func (p *ComponentLogger) Enabled() bool {
@@ -125,7 +156,7 @@ func (p *ComponentLogger) Enabled() bool {
 }
 
 func (p *ComponentLogger) DebugEnabled() bool {
-	return p.Enabled() && p.debugFlagEnabled
+	return p.Enabled() && internalDtLogger.debugFlagEnabled(p.componentName)
 }
 
 func (p *ComponentLogger) Debug(msg string) {
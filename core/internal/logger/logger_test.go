@@ -191,3 +191,18 @@ func replaceStderr(t *testing.T) (*os.File, *os.File) {
 
 	return origin, r
 }
+
+func TestExistingComponentLoggerFollowsReconfiguration(t *testing.T) {
+	internalDtLogger.configure(configuration.LoggingDestination_Off, nil)
+	logger := newComponentLogger("ReconfiguredComponent")
+	require.False(t, logger.Enabled())
+
+	Reconfigure(&configuration.DtConfiguration{
+		LoggingDestination: configuration.LoggingDestination_Stdout,
+		LoggingFlags:       "ReconfiguredComponent=true",
+	})
+	require.True(t, logger.Enabled())
+	require.True(t, logger.DebugEnabled())
+
+	internalDtLogger.configure(configuration.LoggingDestination_Off, nil)
+}
@@ -16,8 +16,10 @@ package logger
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
@@ -37,13 +39,59 @@ func logStartupBanner(config *configuration.DtConfiguration) {
 	}
 
 	logger.Infof("Local timezone .............. %s", getUTCOffsetToLocalTimezone())
+	logger.Infof("Cloud platform .............. %s", config.Platform)
+	if config.ConfigFilePath != "" {
+		logger.Infof("Config file ................. %s", config.ConfigFilePath)
+	}
+	for _, warning := range config.Warnings {
+		logger.Warnf("Configuration warning ....... %s", warning)
+	}
+	if config.AgentDisabled {
+		logger.Info("Agent active ................ false, spans are neither recorded nor exported")
+		return
+	}
 	logger.Infof("Cluster ID .................. %#x", uint32(config.ClusterId))
 	logger.Infof("Tenant ...................... %s", config.Tenant)
 	logger.Infof("Agent ID .................... %#x", uint64(config.AgentId))
-	logger.Infof("Connection URL .............. %s", config.BaseUrl)
+	if len(config.BaseUrls) > 1 {
+		redactedUrls := make([]string, len(config.BaseUrls))
+		for i, baseUrl := range config.BaseUrls {
+			redactedUrls[i] = redactUrl(baseUrl)
+		}
+		logger.Infof("Connection URLs ............. %s (%s)", strings.Join(redactedUrls, ", "), config.EndpointSelection)
+	} else {
+		logger.Infof("Connection URL .............. %s", redactUrl(config.BaseUrl))
+	}
+	if config.AuthTokenFile != "" {
+		logger.Infof("Auth token file ............. %s", config.AuthTokenFile)
+	}
+	if config.ProxyUrl != "" {
+		logger.Infof("Proxy URL ................... %s", redactUrl(config.ProxyUrl))
+	}
+	if config.CaBundleFile != "" {
+		logger.Infof("CA bundle file .............. %s", config.CaBundleFile)
+	}
+	if config.ClientCertFile != "" {
+		logger.Infof("Client certificate file ..... %s", config.ClientCertFile)
+	}
+	logger.Infof("TLS minimum version ......... %s", config.TlsMinVersion)
 	logger.Infof("Span processing interval .... %d", config.SpanProcessingIntervalMs)
 	logger.Infof("Logging destination ......... %s", config.LoggingDestination)
 	logger.Infof("Logging flags ............... %s", config.LoggingFlags)
+	logger.Infof("Export compression .......... %s", config.ExportCompression)
+	logger.Infof("Export chunk size ........... %d KB", config.ExportChunkSizeKb)
+	logger.Infof("Export concurrency .......... %d", config.ExportConcurrency)
+	logger.Infof("Circuit breaker ............. %d failures, open %d ms, retention policy %s",
+		config.CircuitBreakerFailureThreshold, config.CircuitBreakerOpenDurationMs, config.CircuitBreakerRetentionPolicy)
+	logger.Infof("Span watchlist .............. %d spans, overflow policy %s", config.SpanWatchlistSize, config.SpanWatchlistOverflowPolicy)
+	logger.Infof("Open span timeout ........... %d ms", config.OpenSpanTimeoutMs)
+	logger.Infof("Keep alive interval ......... %d ms", config.KeepAliveIntervalMs)
+	logger.Infof("Export timeouts ............. flush %d/%d ms, regular %d/%d ms, flush or shutdown %d ms",
+		config.FlushExportConnTimeoutMs, config.FlushExportDataTimeoutMs,
+		config.RegularExportConnTimeoutMs, config.RegularExportDataTimeoutMs, config.FlushOrShutdownTimeoutMs)
+	if config.PersistentQueueDirectory != "" {
+		logger.Infof("Persistent queue directory .. %s (max %d MB)", config.PersistentQueueDirectory, config.PersistentQueueMaxSizeMb)
+	}
 	logger.Infof("Process ID .................. %d", os.Getpid())
 	logger.Infof("Command line is ............. %s", os.Args)
 
@@ -59,6 +107,16 @@ func logStartupBanner(config *configuration.DtConfiguration) {
 
 }
 
+// redactUrl replaces a password contained in the URL, since secrets must never be logged.
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+
+	return u.Redacted()
+}
+
 func getUTCOffsetToLocalTimezone() string {
 	_, offset := time.Now().Zone()
 
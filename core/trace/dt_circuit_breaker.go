@@ -0,0 +1,166 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/logger"
+)
+
+// CircuitBreakerState is the state of the circuit breaker around the export endpoint.
+type CircuitBreakerState string
+
+const (
+	// CircuitBreakerState_Closed lets all exports through.
+	CircuitBreakerState_Closed CircuitBreakerState = "closed"
+	// CircuitBreakerState_Open skips all exports since the endpoint has failed repeatedly.
+	CircuitBreakerState_Open CircuitBreakerState = "open"
+	// CircuitBreakerState_HalfOpen lets a single export through which probes whether the endpoint is reachable again.
+	CircuitBreakerState_HalfOpen CircuitBreakerState = "half-open"
+)
+
+var errCircuitBreakerOpen = errors.New("circuit breaker is open, export is skipped")
+
+// dtCircuitBreaker skips exports once a number of consecutive export requests has failed, so that neither CPU time
+// is spent on serialization nor the connection and data timeouts are waited for while the endpoint is unreachable.
+// After the open duration, a single export operation probes the endpoint. It closes the circuit breaker if a request
+// succeeds and opens it again if a request fails.
+type dtCircuitBreaker struct {
+	logger           *logger.ComponentLogger
+	stats            *dtStats
+	failureThreshold int
+	openDuration     time.Duration
+	// now returns the current time, it is replaced by tests
+	now func() time.Time
+
+	lock                sync.Mutex
+	state               CircuitBreakerState
+	consecutiveFailures int
+	openedAt            time.Time
+	// probing is set while the export operation which probes the endpoint in the half-open state is in progress
+	probing bool
+}
+
+func newDtCircuitBreaker(config *configuration.DtConfiguration, stats *dtStats) *dtCircuitBreaker {
+	failureThreshold := config.CircuitBreakerFailureThreshold
+	if failureThreshold <= 0 {
+		failureThreshold = configuration.DefaultCircuitBreakerFailureThreshold
+	}
+
+	stats.setCircuitBreakerState(CircuitBreakerState_Closed)
+	return &dtCircuitBreaker{
+		logger:           logger.NewComponentLogger("CircuitBreaker"),
+		stats:            stats,
+		failureThreshold: failureThreshold,
+		openDuration: durationMsOrDefault(config.CircuitBreakerOpenDurationMs,
+			configuration.DefaultCircuitBreakerOpenDurationMs),
+		now:   time.Now,
+		state: CircuitBreakerState_Closed,
+	}
+}
+
+// allowExport reports whether an export operation may send requests. It is checked once per export operation, before
+// the spans leave the span watchlist. Once the open duration has elapsed, the circuit breaker becomes half-open and
+// lets a single export operation through, which must call exportFinished when it is done.
+func (b *dtCircuitBreaker) allowExport() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case CircuitBreakerState_Closed:
+		return true
+	case CircuitBreakerState_Open:
+		if b.now().Sub(b.openedAt) < b.openDuration {
+			return false
+		}
+		b.setState(CircuitBreakerState_HalfOpen)
+		b.logger.Info("Circuit breaker is half-open, the next export probes the endpoint")
+	}
+
+	if b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// exportFinished ends the probing export operation. If it has not sent any request, the next export operation
+// probes the endpoint instead.
+func (b *dtCircuitBreaker) exportFinished() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+}
+
+// recordResult updates the state with the result of an export request. Temporary failures such as connection errors,
+// timeouts and responses which are worth retrying count as failures, cancelled requests are ignored and any other
+// result is a success since the endpoint has been reached.
+func (b *dtCircuitBreaker) recordResult(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	failed := isTemporaryExportError(err)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !failed {
+		b.consecutiveFailures = 0
+		if b.state != CircuitBreakerState_Closed {
+			b.setState(CircuitBreakerState_Closed)
+			b.logger.Info("Circuit breaker is closed, the endpoint is reachable again")
+		}
+		return
+	}
+
+	b.consecutiveFailures++
+	switch {
+	case b.state == CircuitBreakerState_HalfOpen:
+		b.open()
+		b.logger.Warnf("Circuit breaker is open again, the endpoint is still unreachable, exports are skipped for %s",
+			b.openDuration)
+	case b.state == CircuitBreakerState_Closed && b.consecutiveFailures >= b.failureThreshold:
+		b.open()
+		b.logger.Warnf("Circuit breaker is open after %d consecutive failed export requests, exports are skipped for %s",
+			b.consecutiveFailures, b.openDuration)
+	}
+}
+
+// open skips exports for the open duration, must be called with lock held.
+func (b *dtCircuitBreaker) open() {
+	b.openedAt = b.now()
+	b.setState(CircuitBreakerState_Open)
+	b.stats.recordCircuitBreakerOpened()
+}
+
+// setState changes the state and makes it visible in the statistics, must be called with lock held.
+func (b *dtCircuitBreaker) setState(state CircuitBreakerState) {
+	b.state = state
+	b.stats.setCircuitBreakerState(state)
+}
+
+// getState returns the current state.
+func (b *dtCircuitBreaker) getState() CircuitBreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+var errTestUnavailable = &retryableError{err: errors.New("unexpected response code: 503")}
+
+// newCircuitBreakerForTest creates a dtCircuitBreaker with a fake clock.
+func newCircuitBreakerForTest(failureThreshold, openDurationMs int) (*dtCircuitBreaker, *dtStats, *fakeClock) {
+	config := &configuration.DtConfiguration{
+		CircuitBreakerFailureThreshold: failureThreshold,
+		CircuitBreakerOpenDurationMs:   openDurationMs,
+	}
+	stats := newDtStats()
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	breaker := newDtCircuitBreaker(config, stats)
+	breaker.now = clock.Now
+	return breaker, stats, clock
+}
+
+func TestCircuitBreakerDefaults(t *testing.T) {
+	breaker, stats, _ := newCircuitBreakerForTest(0, 0)
+
+	require.Equal(t, configuration.DefaultCircuitBreakerFailureThreshold, breaker.failureThreshold)
+	require.Equal(t, time.Duration(configuration.DefaultCircuitBreakerOpenDurationMs)*time.Millisecond,
+		breaker.openDuration)
+	require.Equal(t, CircuitBreakerState_Closed, breaker.getState())
+	require.Equal(t, CircuitBreakerState_Closed, stats.snapshot().CircuitBreakerState)
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	breaker, stats, _ := newCircuitBreakerForTest(3, 1000)
+
+	breaker.recordResult(errTestUnavailable)
+	breaker.recordResult(errTestUnavailable)
+	breaker.recordResult(nil)
+	breaker.recordResult(errTestUnavailable)
+	breaker.recordResult(errTestUnavailable)
+	require.Equal(t, CircuitBreakerState_Closed, breaker.getState(), "a success must reset the consecutive failures")
+	require.True(t, breaker.allowExport())
+	breaker.exportFinished()
+
+	breaker.recordResult(errTestUnavailable)
+	require.Equal(t, CircuitBreakerState_Open, breaker.getState())
+	require.False(t, breaker.allowExport())
+
+	snapshot := stats.snapshot()
+	require.Equal(t, CircuitBreakerState_Open, snapshot.CircuitBreakerState)
+	require.EqualValues(t, 1, snapshot.CircuitBreakerOpened)
+}
+
+func TestCircuitBreakerIgnoresPermanentAndCancelledErrors(t *testing.T) {
+	breaker, _, _ := newCircuitBreakerForTest(1, 1000)
+
+	breaker.recordResult(context.Canceled)
+	breaker.recordResult(errNotAuthorizedRequest)
+	breaker.recordResult(errPayloadTooLarge)
+	breaker.recordResult(errors.New("unexpected response code: 400"))
+	require.Equal(t, CircuitBreakerState_Closed, breaker.getState())
+
+	breaker.recordResult(context.DeadlineExceeded)
+	require.Equal(t, CircuitBreakerState_Open, breaker.getState(), "a timeout must count as failure")
+}
+
+func TestCircuitBreakerHalfOpenProbeSucceeds(t *testing.T) {
+	breaker, stats, clock := newCircuitBreakerForTest(1, 1000)
+
+	breaker.recordResult(errTestUnavailable)
+	clock.Advance(999 * time.Millisecond)
+	require.False(t, breaker.allowExport())
+
+	clock.Advance(time.Millisecond)
+	require.True(t, breaker.allowExport())
+	require.Equal(t, CircuitBreakerState_HalfOpen, breaker.getState())
+	require.False(t, breaker.allowExport())
+
+	breaker.recordResult(nil)
+	breaker.exportFinished()
+	require.Equal(t, CircuitBreakerState_Closed, breaker.getState())
+	require.Equal(t, CircuitBreakerState_Closed, stats.snapshot().CircuitBreakerState)
+	require.True(t, breaker.allowExport())
+}
+
+func TestCircuitBreakerHalfOpenProbeFails(t *testing.T) {
+	breaker, stats, clock := newCircuitBreakerForTest(3, 1000)
+
+	for i := 0; i < 3; i++ {
+		breaker.recordResult(errTestUnavailable)
+	}
+	clock.Advance(time.Second)
+	require.True(t, breaker.allowExport())
+
+	breaker.recordResult(errTestUnavailable)
+	breaker.exportFinished()
+	require.Equal(t, CircuitBreakerState_Open, breaker.getState(), "a single failed probe must open it again")
+	require.False(t, breaker.allowExport())
+	require.EqualValues(t, 2, stats.snapshot().CircuitBreakerOpened)
+
+	clock.Advance(time.Second)
+	require.True(t, breaker.allowExport())
+}
+
+func TestCircuitBreakerProbeWithoutRequests(t *testing.T) {
+	breaker, _, clock := newCircuitBreakerForTest(1, 1000)
+
+	breaker.recordResult(errTestUnavailable)
+	clock.Advance(time.Second)
+	require.True(t, breaker.allowExport())
+	breaker.exportFinished()
+
+	require.Equal(t, CircuitBreakerState_HalfOpen, breaker.getState())
+	require.True(t, breaker.allowExport(), "the next export must probe the endpoint if no request has been sent")
+}
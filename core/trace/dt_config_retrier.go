@@ -0,0 +1,211 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/logger"
+)
+
+// interval in which loading the configuration is retried in lenient mode
+const cConfigRetryInterval = 30 * time.Second
+
+// configWarningOnce ensures that the lenient mode warning is printed only once per process
+var configWarningOnce sync.Once
+
+// warnConfigurationUnavailable prints a warning that the Dynatrace export is not active. The warning is printed to
+// stderr, since the internal logger can not be configured without a valid configuration.
+func warnConfigurationUnavailable(err error) {
+	configWarningOnce.Do(func() {
+		fmt.Fprintf(os.Stderr, "[Dynatrace] Configuration is missing or invalid, spans are not exported "+
+			"and only W3C trace context is propagated until a valid configuration is found: %s\n", err)
+	})
+}
+
+// dtConfigRetrier periodically retries loading the configuration after it has failed in lenient mode and passes the
+// configuration to the registered callbacks once it is valid. The retrying goroutine is started with the first
+// callback and stops once the configuration has been loaded or once no callback is pending anymore. Followers are
+// passed the configuration as well, but they do not keep retrying alive.
+type dtConfigRetrier struct {
+	load     func() (*configuration.DtConfiguration, error)
+	interval time.Duration
+
+	lock      sync.Mutex
+	config    *configuration.DtConfiguration
+	callbacks []*pendingConfigCallback
+	followers []func(*configuration.DtConfiguration)
+	// stop is closed to stop the retrying goroutine, it is nil while the goroutine is not running
+	stop chan struct{}
+}
+
+// pendingConfigCallback is a callback which waits for a valid configuration, it is a pointer so that it can be
+// identified when it is canceled.
+type pendingConfigCallback struct {
+	callback func(*configuration.DtConfiguration)
+}
+
+// globalConfigRetrier retries loading the configuration provided by configuration.GlobalConfigurationProvider,
+// which caches the configuration only once it is valid.
+var globalConfigRetrier = newDtConfigRetrier(configuration.GlobalConfigurationProvider.GetConfiguration, cConfigRetryInterval)
+
+func newDtConfigRetrier(load func() (*configuration.DtConfiguration, error), interval time.Duration) *dtConfigRetrier {
+	return &dtConfigRetrier{
+		load:     load,
+		interval: interval,
+	}
+}
+
+// onConfigLoaded registers a callback which is called once a valid configuration has been loaded.
+// The callback is called immediately if the configuration has already been loaded. The returned function cancels
+// the callback, retrying stops once no callback is pending anymore.
+func (r *dtConfigRetrier) onConfigLoaded(callback func(*configuration.DtConfiguration)) func() {
+	r.lock.Lock()
+	if config := r.config; config != nil {
+		r.lock.Unlock()
+		callback(config)
+		return func() {}
+	}
+
+	pending := &pendingConfigCallback{callback: callback}
+	r.callbacks = append(r.callbacks, pending)
+	if r.stop == nil {
+		r.stop = make(chan struct{})
+		go r.run(r.stop)
+	}
+	r.lock.Unlock()
+
+	return func() {
+		r.cancel(pending)
+	}
+}
+
+// followConfigLoaded registers a callback which is called once a valid configuration has been loaded for a callback
+// registered by onConfigLoaded. It is called immediately if the configuration has already been loaded. Unlike
+// onConfigLoaded, it neither starts retrying nor keeps it going, so it never needs to be canceled.
+func (r *dtConfigRetrier) followConfigLoaded(follower func(*configuration.DtConfiguration)) {
+	r.lock.Lock()
+	if config := r.config; config != nil {
+		r.lock.Unlock()
+		follower(config)
+		return
+	}
+
+	r.followers = append(r.followers, follower)
+	r.lock.Unlock()
+}
+
+// cancel removes a pending callback and stops retrying if it has been the last one.
+func (r *dtConfigRetrier) cancel(pending *pendingConfigCallback) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, callback := range r.callbacks {
+		if callback == pending {
+			r.callbacks = append(r.callbacks[:i], r.callbacks[i+1:]...)
+			break
+		}
+	}
+
+	if len(r.callbacks) == 0 && r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+func (r *dtConfigRetrier) run(stop chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		config, err := r.load()
+		if err != nil {
+			continue
+		}
+
+		r.lock.Lock()
+		select {
+		case <-stop:
+			// all callbacks have been canceled while the configuration has been loaded
+			r.lock.Unlock()
+			return
+		default:
+		}
+		callbacks, followers := r.setConfigLocked(config)
+		r.lock.Unlock()
+
+		notifyConfigLoaded(config, callbacks, followers)
+		return
+	}
+}
+
+// configLoaded passes a valid configuration which has been loaded elsewhere, e.g. by a component created after the
+// configuration has become available, to the pending callbacks and followers and stops retrying.
+func (r *dtConfigRetrier) configLoaded(config *configuration.DtConfiguration) {
+	r.lock.Lock()
+	if r.config != nil {
+		r.lock.Unlock()
+		return
+	}
+
+	if r.stop != nil {
+		close(r.stop)
+	}
+	callbacks, followers := r.setConfigLocked(config)
+	r.lock.Unlock()
+
+	if len(callbacks) > 0 || len(followers) > 0 {
+		notifyConfigLoaded(config, callbacks, followers)
+	}
+}
+
+// setConfigLocked records the loaded configuration and returns the callbacks and followers which are waiting for it.
+// The lock must be held.
+func (r *dtConfigRetrier) setConfigLocked(config *configuration.DtConfiguration) (
+	[]*pendingConfigCallback,
+	[]func(*configuration.DtConfiguration),
+) {
+	r.config = config
+	callbacks, followers := r.callbacks, r.followers
+	r.callbacks, r.followers = nil, nil
+	r.stop = nil
+	return callbacks, followers
+}
+
+func notifyConfigLoaded(
+	config *configuration.DtConfiguration,
+	callbacks []*pendingConfigCallback,
+	followers []func(*configuration.DtConfiguration),
+) {
+	logger.Reconfigure(config)
+	logger.NewComponentLogger("TracerProvider").Info("Valid configuration has been found, exporting is started")
+
+	for _, pending := range callbacks {
+		pending.callback(config)
+	}
+	for _, follower := range followers {
+		follower(config)
+	}
+}
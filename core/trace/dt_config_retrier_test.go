@@ -0,0 +1,209 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+// withConfigRetrier sets the retrier which loads the configuration instead of the global one.
+func withConfigRetrier(r *dtConfigRetrier) Option {
+	return optionFunc(func(o *options) {
+		o.configRetrier = r
+	})
+}
+
+// newFailingConfigRetrier creates a retrier which fails to load the configuration until it is made available.
+func newFailingConfigRetrier(config *configuration.DtConfiguration) (*dtConfigRetrier, func()) {
+	var available int32
+	r := newDtConfigRetrier(func() (*configuration.DtConfiguration, error) {
+		if atomic.LoadInt32(&available) == 0 {
+			return nil, errors.New("config file not found")
+		}
+		return config, nil
+	}, 10*time.Millisecond)
+
+	return r, func() { atomic.StoreInt32(&available, 1) }
+}
+
+func TestLenientTracerProviderWithInvalidConfiguration(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp, err := NewTracerProviderWithOptions(
+		WithDtConfiguration(&configuration.DtConfiguration{}),
+		WithLenientConfiguration(),
+		WithTracerProviderOptions(sdktrace.WithSpanProcessor(recorder)))
+	require.NoError(t, err)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	require.True(t, span.IsRecording(), "spans are recorded locally")
+	require.True(t, span.SpanContext().IsValid())
+	span.End()
+	require.Len(t, recorder.Ended(), 1)
+
+	require.NoError(t, tp.ForceFlush(context.Background()))
+	require.NoError(t, tp.Shutdown(context.Background()))
+	require.Equal(t, Stats{}, tp.Stats())
+}
+
+func TestNonLenientTracerProviderWithInvalidConfiguration(t *testing.T) {
+	_, err := NewTracerProviderWithOptions(WithDtConfiguration(&configuration.DtConfiguration{}))
+	require.Error(t, err)
+
+	_, err = NewTextMapPropagator(WithDtConfiguration(&configuration.DtConfiguration{}))
+	require.Error(t, err)
+}
+
+func TestLenientTextMapPropagatorWithInvalidConfiguration(t *testing.T) {
+	p, err := NewTextMapPropagator(WithDtConfiguration(&configuration.DtConfiguration{}), WithLenientConfiguration())
+	require.NoError(t, err)
+
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx := p.Extract(context.Background(), propagation.HeaderCarrier{"Traceparent": []string{traceparent}})
+
+	c := propagation.HeaderCarrier{}
+	p.Inject(ctx, c)
+	require.Equal(t, traceparent, c.Get(traceparentHeader))
+	require.Empty(t, c.Get(xDtHeader))
+}
+
+func TestLenientTracerProviderStartsExportingOnceConfigurationIsFound(t *testing.T) {
+	var numExports int32
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&numExports, 1)
+	})
+	defer testServer.Close()
+	config, err := configuration.BuildConfiguration(*config)
+	require.NoError(t, err)
+
+	retrier, makeAvailable := newFailingConfigRetrier(config)
+	tp, err := NewTracerProviderWithOptions(withConfigRetrier(retrier), WithLenientConfiguration())
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+	p, err := NewTextMapPropagator(withConfigRetrier(retrier), WithLenientConfiguration())
+	require.NoError(t, err)
+
+	tracer := tp.Tracer("test")
+	_, span := tracer.Start(context.Background(), "span before config")
+	require.NotNil(t, span)
+	require.False(t, tp.isConfigured())
+	_, isDtSpan := span.(*dtSpan)
+	require.False(t, isDtSpan)
+	span.End()
+
+	makeAvailable()
+	require.Eventually(t, tp.isConfigured, time.Second, 10*time.Millisecond)
+
+	// tracers which have been created before are exporting as well
+	ctx, span := tracer.Start(context.Background(), "span after config")
+	_, isDtSpan = span.(*dtSpan)
+	require.True(t, isDtSpan)
+
+	c := propagation.HeaderCarrier{}
+	p.Inject(ctx, c)
+	require.NotEmpty(t, c.Get(xDtHeader), "Dynatrace trace context is propagated once the configuration is found")
+
+	span.End()
+	require.NoError(t, tp.ForceFlush(context.Background()))
+	require.EqualValues(t, 1, atomic.LoadInt32(&numExports))
+	require.EqualValues(t, 1, tp.Stats().SpansEnded)
+}
+
+func TestLenientTracerProviderShutdownBeforeConfigurationIsFound(t *testing.T) {
+	retrier, makeAvailable := newFailingConfigRetrier(testConfig)
+	tp, err := NewTracerProviderWithOptions(withConfigRetrier(retrier), WithLenientConfiguration())
+	require.NoError(t, err)
+	require.NoError(t, tp.Shutdown(context.Background()))
+
+	makeAvailable()
+	configLoaded := make(chan struct{})
+	retrier.onConfigLoaded(func(*configuration.DtConfiguration) { close(configLoaded) })
+	<-configLoaded
+
+	require.False(t, tp.isConfigured(), "a provider which has been shut down must not start exporting")
+}
+
+func TestConfigRetrierStopsOnceNoCallbackIsPending(t *testing.T) {
+	var numLoads int32
+	retrier := newDtConfigRetrier(func() (*configuration.DtConfiguration, error) {
+		atomic.AddInt32(&numLoads, 1)
+		return nil, errors.New("config file not found")
+	}, 10*time.Millisecond)
+
+	cancelA := retrier.onConfigLoaded(func(*configuration.DtConfiguration) {})
+	cancelB := retrier.onConfigLoaded(func(*configuration.DtConfiguration) {})
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&numLoads) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// retrying goes on as long as a callback is pending
+	cancelA()
+	numLoadsBefore := atomic.LoadInt32(&numLoads)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&numLoads) > numLoadsBefore
+	}, time.Second, 10*time.Millisecond)
+
+	cancelB()
+	time.Sleep(30 * time.Millisecond)
+	numLoadsBefore = atomic.LoadInt32(&numLoads)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, numLoadsBefore, atomic.LoadInt32(&numLoads), "retrying must have stopped")
+}
+
+func TestLenientTextMapPropagatorDoesNotKeepRetrying(t *testing.T) {
+	retrier, makeAvailable := newFailingConfigRetrier(testConfig)
+	p, err := NewTextMapPropagator(withConfigRetrier(retrier), WithLenientConfiguration())
+	require.NoError(t, err)
+
+	retrier.lock.Lock()
+	require.Nil(t, retrier.stop, "a propagator must not start retrying on its own")
+	retrier.lock.Unlock()
+
+	// the propagator is configured once a tracer provider has found the configuration
+	makeAvailable()
+	tp, err := NewTracerProviderWithOptions(withConfigRetrier(retrier), WithLenientConfiguration())
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+	require.Eventually(t, func() bool {
+		return !p.w3cOnly()
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestLenientTracerProviderShutdownStopsRetrying(t *testing.T) {
+	retrier, _ := newFailingConfigRetrier(testConfig)
+	tp, err := NewTracerProviderWithOptions(withConfigRetrier(retrier), WithLenientConfiguration())
+	require.NoError(t, err)
+
+	retrier.lock.Lock()
+	require.NotNil(t, retrier.stop)
+	retrier.lock.Unlock()
+
+	require.NoError(t, tp.Shutdown(context.Background()))
+	retrier.lock.Lock()
+	defer retrier.lock.Unlock()
+	require.Nil(t, retrier.stop, "the retrying goroutine must be stopped")
+	require.Empty(t, retrier.callbacks)
+}
@@ -0,0 +1,270 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/logger"
+)
+
+// interval in which the config file and the auth token file are checked for changes
+const cConfigWatchInterval = 10 * time.Second
+
+var errEmptyAuthTokenFile = errors.New("auth token file is empty")
+
+// reloadableConfigFields are the fields of the configuration which are applied when the config file changes or which
+// are not settings of the config file. AgentDisabled is compared separately, since its key is AgentActive, and so are
+// BaseUrls, since a single base URL is the BaseUrl.
+var reloadableConfigFields = map[string]bool{
+	"BaseUrl":            true,
+	"AuthToken":          true,
+	"LoggingDestination": true,
+	"LoggingFlags":       true,
+	"AgentDisabled":      true,
+	"AgentId":            true,
+	"ConfigFilePath":     true,
+	"Platform":           true,
+	"Warnings":           true,
+	"BaseUrls":           true,
+}
+
+// watchedFileState is used to detect changes of a watched file.
+type watchedFileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+func statWatchedFile(path string) watchedFileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return watchedFileState{}
+	}
+
+	return watchedFileState{exists: true, modTime: info.ModTime(), size: info.Size()}
+}
+
+// dtConfigWatcher polls the config file the configuration has been read from and the auth token file. Changed
+// connection settings are passed to the apply function, changed logging settings reconfigure the internal logger.
+// Other settings require a restart of the application.
+type dtConfigWatcher struct {
+	logger   *logger.ComponentLogger
+	config   *configuration.DtConfiguration
+	interval time.Duration
+	apply    func(change func(settings *dtConnectionSettings))
+
+	configFileState    watchedFileState
+	authTokenFileState watchedFileState
+	// the values which have been read from the files most recently, only changed values are applied, so that
+	// e.g. a token set by SetAuthToken is not overwritten by a change of the base URL
+	baseUrl            string
+	authToken          string
+	loggingDestination configuration.LoggingDestination
+	loggingFlags       string
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+}
+
+// startDtConfigWatcher starts watching the config file and the auth token file of the given configuration.
+// The auth token file is read before it returns. Returns nil if there is nothing to watch.
+func startDtConfigWatcher(
+	config *configuration.DtConfiguration,
+	interval time.Duration,
+	apply func(change func(settings *dtConnectionSettings)),
+) *dtConfigWatcher {
+	if config.ConfigFilePath == "" && config.AuthTokenFile == "" {
+		return nil
+	}
+
+	w := &dtConfigWatcher{
+		logger:             logger.NewComponentLogger("ConfigWatcher"),
+		config:             config,
+		interval:           interval,
+		apply:              apply,
+		baseUrl:            config.BaseUrl,
+		authToken:          config.AuthToken,
+		loggingDestination: config.LoggingDestination,
+		loggingFlags:       config.LoggingFlags,
+		stopCh:             make(chan struct{}),
+		done:               make(chan struct{}),
+	}
+	if config.ConfigFilePath != "" {
+		// the configuration has just been read from the config file
+		w.configFileState = statWatchedFile(config.ConfigFilePath)
+	}
+
+	w.poll()
+	go w.run()
+	return w
+}
+
+func (w *dtConfigWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+// stop stops watching and waits until a reload in progress is finished.
+func (w *dtConfigWatcher) stop() {
+	if w == nil {
+		return
+	}
+
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+	<-w.done
+}
+
+// poll reloads the config file and the auth token file if they have changed since the previous poll.
+func (w *dtConfigWatcher) poll() {
+	baseUrl, authToken := w.baseUrl, w.authToken
+
+	if w.config.ConfigFilePath != "" && w.fileChanged(w.config.ConfigFilePath, &w.configFileState) {
+		w.reloadConfigFile()
+	}
+
+	if w.config.AuthTokenFile != "" && w.fileChanged(w.config.AuthTokenFile, &w.authTokenFileState) {
+		w.reloadAuthTokenFile()
+	}
+
+	if w.baseUrl == baseUrl && w.authToken == authToken {
+		return
+	}
+
+	w.apply(func(settings *dtConnectionSettings) {
+		if w.baseUrl != baseUrl {
+			settings.baseUrl = w.baseUrl
+		}
+		if w.authToken != authToken {
+			settings.authToken = w.authToken
+		}
+	})
+}
+
+// fileChanged reports whether the file has changed since its state has been recorded and records the current state.
+func (w *dtConfigWatcher) fileChanged(path string, state *watchedFileState) bool {
+	current := statWatchedFile(path)
+	if current == *state {
+		return false
+	}
+
+	*state = current
+	return current.exists
+}
+
+func (w *dtConfigWatcher) reloadConfigFile() {
+	path := w.config.ConfigFilePath
+	config, err := configuration.LoadConfigurationFromFile(path)
+	if err != nil {
+		// the file may be incomplete while it is written, so it is read again with the next poll
+		w.configFileState = watchedFileState{}
+		w.logger.Warnf("Can not reload config file %s, current settings are kept: %s", path, err)
+		return
+	}
+
+	w.logger.Infof("Config file %s has changed, connection and logging settings are reloaded", path)
+	if changed := restartRequiredSettings(w.config, config); len(changed) > 0 {
+		w.logger.Warnf("Changing %s requires a restart of the application", strings.Join(changed, ", "))
+	}
+
+	if config.BaseUrl != "" {
+		w.baseUrl = config.BaseUrl
+	}
+	// the auth token file takes precedence over the auth token in the config file
+	if w.config.AuthTokenFile == "" && config.AuthToken != "" {
+		w.authToken = config.AuthToken
+	}
+
+	if config.LoggingDestination != w.loggingDestination || config.LoggingFlags != w.loggingFlags {
+		w.loggingDestination = config.LoggingDestination
+		w.loggingFlags = config.LoggingFlags
+
+		loggingConfig := *w.config
+		loggingConfig.LoggingDestination = config.LoggingDestination
+		loggingConfig.LoggingFlags = config.LoggingFlags
+		logger.Reconfigure(&loggingConfig)
+	}
+}
+
+// restartRequiredSettings returns the names of the settings which differ between the configuration in use and the
+// reloaded one and which are not applied at runtime.
+func restartRequiredSettings(current, reloaded *configuration.DtConfiguration) []string {
+	var changed []string
+	if reloaded.AgentDisabled != current.AgentDisabled {
+		changed = append(changed, "AgentActive")
+	}
+
+	currentValue, reloadedValue := reflect.ValueOf(*current), reflect.ValueOf(*reloaded)
+	for i := 0; i < currentValue.NumField(); i++ {
+		field := currentValue.Type().Field(i)
+		if field.PkgPath != "" || reloadableConfigFields[field.Name] {
+			continue
+		}
+
+		if !reflect.DeepEqual(currentValue.Field(i).Interface(), reloadedValue.Field(i).Interface()) {
+			changed = append(changed, field.Name)
+		}
+	}
+
+	// the endpoints of several base URLs are created on startup, see newDtEndpoints
+	severalBaseUrls := len(current.BaseUrls) > 1 || len(reloaded.BaseUrls) > 1
+	if severalBaseUrls && !reflect.DeepEqual(current.BaseUrls, reloaded.BaseUrls) {
+		changed = append(changed, "BaseUrls")
+	}
+
+	return changed
+}
+
+func (w *dtConfigWatcher) reloadAuthTokenFile() {
+	path := w.config.AuthTokenFile
+	data, err := ioutil.ReadFile(path)
+	token := strings.TrimSpace(string(data))
+	if err == nil && token == "" {
+		err = errEmptyAuthTokenFile
+	}
+
+	if err != nil {
+		// the file may be incomplete while it is written, so it is read again with the next poll
+		w.authTokenFileState = watchedFileState{}
+		w.logger.Warnf("Can not read auth token file %s, current auth token is kept: %s", path, err)
+		return
+	}
+
+	if token != w.authToken {
+		w.logger.Infof("Auth token has been read from %s", path)
+		w.authToken = token
+	}
+}
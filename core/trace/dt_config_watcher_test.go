@@ -0,0 +1,218 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+// unsetEnvForTest removes the given environment variables for the duration of the test.
+func unsetEnvForTest(t *testing.T, keys ...string) {
+	for _, key := range keys {
+		key := key
+		if value, found := os.LookupEnv(key); found {
+			require.NoError(t, os.Unsetenv(key))
+			t.Cleanup(func() {
+				os.Setenv(key, value)
+			})
+		}
+	}
+}
+
+// writeWatchedFile writes a watched file and moves its modification time forward, so that the change is detected
+// regardless of the resolution of file timestamps.
+func writeWatchedFile(t *testing.T, path string, content string) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime().Add(time.Second)
+	} else {
+		modTime = time.Now()
+	}
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func writeConfigFile(t *testing.T, path string, baseUrl string, authToken string) {
+	writeWatchedFile(t, path, fmt.Sprintf(
+		`{"ClusterID": 123, "Tenant": "testTenant", "Connection": {"BaseUrl": %q, "AuthToken": %q}}`, baseUrl, authToken))
+}
+
+// startConfigWatcherForTest starts a watcher which never polls on its own and applies changes to the returned connection.
+func startConfigWatcherForTest(t *testing.T, config *configuration.DtConfiguration) (*dtConfigWatcher, *dtConnection) {
+	connection := newDtConnection(config)
+	watcher := startDtConfigWatcher(config, time.Hour, func(change func(settings *dtConnectionSettings)) {
+		connection.update(change)
+	})
+	require.NotNil(t, watcher)
+	t.Cleanup(watcher.stop)
+
+	return watcher, connection
+}
+
+func TestConfigWatcherNothingToWatch(t *testing.T) {
+	require.Nil(t, startDtConfigWatcher(testConfig, time.Hour, nil))
+
+	// stopping a watcher which has not been started does nothing
+	var watcher *dtConfigWatcher
+	watcher.stop()
+}
+
+func TestConfigWatcherReloadsConfigFile(t *testing.T) {
+	unsetEnvForTest(t, "DT_CONNECTION_BASE_URL", "DT_CONNECTION_AUTH_TOKEN")
+
+	path := filepath.Join(t.TempDir(), "dtconfig.json")
+	writeConfigFile(t, path, "https://example.com", "token1")
+	config, err := configuration.LoadConfigurationFromFile(path)
+	require.NoError(t, err)
+	require.Equal(t, path, config.ConfigFilePath)
+
+	watcher, connection := startConfigWatcherForTest(t, config)
+
+	// the configuration has just been read from the file, so it is not reloaded
+	watcher.poll()
+	require.Equal(t, dtConnectionSettings{baseUrl: "https://example.com", authToken: "token1"}, connection.settings())
+
+	writeConfigFile(t, path, "https://example.org/", "token2")
+	watcher.poll()
+	require.Equal(t, dtConnectionSettings{baseUrl: "https://example.org", authToken: "token2"}, connection.settings())
+}
+
+func TestConfigWatcherKeepsSettingsOfInvalidConfigFile(t *testing.T) {
+	unsetEnvForTest(t, "DT_CONNECTION_BASE_URL", "DT_CONNECTION_AUTH_TOKEN")
+
+	path := filepath.Join(t.TempDir(), "dtconfig.json")
+	writeConfigFile(t, path, "https://example.com", "token1")
+	config, err := configuration.LoadConfigurationFromFile(path)
+	require.NoError(t, err)
+
+	watcher, connection := startConfigWatcherForTest(t, config)
+
+	// e.g. the file is being written
+	writeWatchedFile(t, path, `{"ClusterID": 123, "Tenant": `)
+	watcher.poll()
+	require.Equal(t, dtConnectionSettings{baseUrl: "https://example.com", authToken: "token1"}, connection.settings())
+
+	require.NoError(t, os.Remove(path))
+	watcher.poll()
+	require.Equal(t, dtConnectionSettings{baseUrl: "https://example.com", authToken: "token1"}, connection.settings())
+
+	// the file is read again once it is valid
+	writeConfigFile(t, path, "https://example.com", "token2")
+	watcher.poll()
+	require.Equal(t, "token2", connection.settings().authToken)
+}
+
+func TestConfigWatcherReportsSettingsWhichRequireRestart(t *testing.T) {
+	unsetEnvForTest(t, "DT_CONNECTION_BASE_URL", "DT_CONNECTION_BASE_URLS", "DT_CONNECTION_AUTH_TOKEN",
+		"DT_SPAN_WATCHLIST_SIZE", "DT_LOGGING_DESTINATION")
+
+	path := filepath.Join(t.TempDir(), "dtconfig.json")
+	writeConfigFile(t, path, "https://example.com", "token1")
+	config, err := configuration.LoadConfigurationFromFile(path)
+	require.NoError(t, err)
+
+	writeConfigFile(t, path, "https://example.org", "token2")
+	reloaded, err := configuration.LoadConfigurationFromFile(path)
+	require.NoError(t, err)
+	require.Empty(t, restartRequiredSettings(config, reloaded))
+
+	writeWatchedFile(t, path, `{"ClusterID": 123, "Tenant": "testTenant", "AgentActive": false,
+		"Connection": {"BaseUrls": ["https://a.example.com", "https://b.example.com"], "AuthToken": "token1"},
+		"SpanWatchlist": {"Size": 100}, "Logging": {"Destination": "stdout"}}`)
+	reloaded, err = configuration.LoadConfigurationFromFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"AgentActive", "SpanWatchlistSize", "BaseUrls"}, restartRequiredSettings(config, reloaded))
+}
+
+func TestConfigWatcherReadsAuthTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	writeWatchedFile(t, path, "token1\n")
+
+	config := *testConfig
+	config.AuthToken = ""
+	config.AuthTokenFile = path
+
+	// the auth token file is read before the watcher is started
+	watcher, connection := startConfigWatcherForTest(t, &config)
+	require.Equal(t, dtConnectionSettings{baseUrl: testConfig.BaseUrl, authToken: "token1"}, connection.settings())
+
+	writeWatchedFile(t, path, "token2")
+	watcher.poll()
+	require.Equal(t, "token2", connection.settings().authToken)
+
+	// an empty file is most likely being written, the current token is kept
+	writeWatchedFile(t, path, "")
+	watcher.poll()
+	require.Equal(t, "token2", connection.settings().authToken)
+
+	writeWatchedFile(t, path, "token3")
+	watcher.poll()
+	require.Equal(t, "token3", connection.settings().authToken)
+}
+
+func TestConfigWatcherAuthTokenFileTakesPrecedence(t *testing.T) {
+	unsetEnvForTest(t, "DT_CONNECTION_BASE_URL", "DT_CONNECTION_AUTH_TOKEN")
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "dtconfig.json")
+	tokenPath := filepath.Join(dir, "token")
+	writeConfigFile(t, configPath, "https://example.com", "configFileToken")
+	writeWatchedFile(t, tokenPath, "tokenFileToken")
+	config, err := configuration.LoadConfigurationFromFile(configPath)
+	require.NoError(t, err)
+	config.AuthTokenFile = tokenPath
+
+	watcher, connection := startConfigWatcherForTest(t, config)
+	require.Equal(t, "tokenFileToken", connection.settings().authToken)
+
+	writeConfigFile(t, configPath, "https://example.org", "otherConfigFileToken")
+	watcher.poll()
+	require.Equal(t, dtConnectionSettings{baseUrl: "https://example.org", authToken: "tokenFileToken"}, connection.settings())
+}
+
+func TestConfigWatcherPollsPeriodically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	writeWatchedFile(t, path, "token1")
+
+	config := *testConfig
+	config.AuthTokenFile = path
+
+	changed := make(chan string, 1)
+	watcher := startDtConfigWatcher(&config, 10*time.Millisecond, func(change func(settings *dtConnectionSettings)) {
+		settings := dtConnectionSettings{}
+		change(&settings)
+		changed <- settings.authToken
+	})
+	defer watcher.stop()
+	require.Equal(t, "token1", <-changed)
+
+	writeWatchedFile(t, path, "token2")
+	select {
+	case token := <-changed:
+		require.Equal(t, "token2", token)
+	case <-time.After(5 * time.Second):
+		t.Fatal("changed auth token file has not been detected")
+	}
+}
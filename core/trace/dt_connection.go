@@ -0,0 +1,89 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+// dtConnectionSettings are the settings of the connection to Dynatrace Cluster which may change at runtime,
+// e.g. if the auth token is rotated.
+type dtConnectionSettings struct {
+	baseUrl   string
+	authToken string
+}
+
+// dtConnection holds the current connection settings. They are swapped as a whole, so that a request never uses
+// the base URL of one configuration together with the auth token of another.
+type dtConnection struct {
+	lock     sync.Mutex
+	current  dtConnectionSettings
+	rejected bool
+}
+
+func newDtConnection(config *configuration.DtConfiguration) *dtConnection {
+	return &dtConnection{
+		current: dtConnectionSettings{
+			baseUrl:   config.BaseUrl,
+			authToken: config.AuthToken,
+		},
+	}
+}
+
+// settings returns the current connection settings.
+func (c *dtConnection) settings() dtConnectionSettings {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.current
+}
+
+// update applies the given change to the connection settings. Returns false if they have not changed. Changed settings
+// are no longer considered to be rejected, since the new auth token may be accepted.
+func (c *dtConnection) update(change func(settings *dtConnectionSettings)) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	settings := c.current
+	change(&settings)
+	if settings == c.current {
+		return false
+	}
+
+	c.current = settings
+	c.rejected = false
+	return true
+}
+
+// reject marks the given settings as rejected by Dynatrace Cluster. Settings which have been replaced in the meantime
+// are ignored, since a request which has been sent before the update must not disable the new settings.
+func (c *dtConnection) reject(settings dtConnectionSettings) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if settings == c.current {
+		c.rejected = true
+	}
+}
+
+// isRejected reports whether the current settings have been rejected, i.e. requests are not authorized.
+func (c *dtConnection) isRejected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.rejected
+}
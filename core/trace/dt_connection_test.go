@@ -0,0 +1,52 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionUpdate(t *testing.T) {
+	connection := newDtConnection(testConfig)
+	require.Equal(t, dtConnectionSettings{baseUrl: testConfig.BaseUrl, authToken: testConfig.AuthToken}, connection.settings())
+
+	require.False(t, connection.update(func(settings *dtConnectionSettings) {
+		settings.authToken = testConfig.AuthToken
+	}), "unchanged settings are not an update")
+
+	connection.reject(connection.settings())
+	require.True(t, connection.isRejected())
+
+	require.True(t, connection.update(func(settings *dtConnectionSettings) {
+		settings.authToken = "newToken"
+	}))
+	require.False(t, connection.isRejected(), "a changed auth token may be accepted")
+	require.Equal(t, dtConnectionSettings{baseUrl: testConfig.BaseUrl, authToken: "newToken"}, connection.settings())
+}
+
+func TestConnectionIgnoresRejectionOfReplacedSettings(t *testing.T) {
+	connection := newDtConnection(testConfig)
+	settings := connection.settings()
+
+	// the auth token is changed while a request with the previous one is in progress
+	connection.update(func(settings *dtConnectionSettings) {
+		settings.authToken = "newToken"
+	})
+	connection.reject(settings)
+
+	require.False(t, connection.isRejected())
+}
@@ -0,0 +1,346 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/logger"
+)
+
+// timeout of a single probe request to an endpoint which has failed
+const cEndpointProbeTimeout = 5 * time.Second
+
+// dtEndpoint is one of several redundant endpoints spans are sent to, e.g. an ActiveGate.
+type dtEndpoint struct {
+	baseUrl string
+	// name is the base URL without credentials, it is used for logging
+	name    string
+	healthy bool
+}
+
+// dtEndpoints selects the endpoint of each request if several redundant endpoints are configured. Requests are sent
+// to the endpoint which has been healthy most recently. An endpoint fails on connection errors and 5xx responses, the
+// requests fail over to another healthy endpoint then. Failed endpoints are probed in the background and receive
+// requests again once they are reachable. A nil *dtEndpoints is valid, it always selects the configured base URL.
+type dtEndpoints struct {
+	logger        *logger.ComponentLogger
+	stats         *dtStats
+	selection     configuration.EndpointSelection
+	probeInterval time.Duration
+	// probe checks whether an endpoint is reachable, the probe of a healthy endpoint returns nil
+	probe func(ctx context.Context, baseUrl string) error
+
+	lock      sync.Mutex
+	endpoints []dtEndpoint
+	// preferred is the index of the endpoint which has been healthy most recently
+	preferred int
+	// next is the index at which round-robin selection continues
+	next    int
+	probing bool
+	stopped bool
+
+	probeCtx    context.Context
+	stopProbing context.CancelFunc
+	probeDone   sync.WaitGroup
+}
+
+// newDtEndpoints returns nil if less than two base URLs are configured, there is nothing to fail over to then.
+func newDtEndpoints(
+	config *configuration.DtConfiguration,
+	stats *dtStats,
+	probe func(ctx context.Context, baseUrl string) error,
+) *dtEndpoints {
+	if len(config.BaseUrls) < 2 {
+		return nil
+	}
+
+	endpoints := make([]dtEndpoint, len(config.BaseUrls))
+	for i, baseUrl := range config.BaseUrls {
+		endpoints[i] = dtEndpoint{baseUrl: baseUrl, name: baseUrl, healthy: true}
+		if u, err := url.Parse(baseUrl); err == nil {
+			endpoints[i].name = u.Redacted()
+		}
+	}
+
+	probeCtx, stopProbing := context.WithCancel(context.Background())
+	return &dtEndpoints{
+		logger:    logger.NewComponentLogger("Endpoints"),
+		stats:     stats,
+		selection: config.EndpointSelection,
+		probeInterval: durationMsOrDefault(config.EndpointProbeIntervalMs,
+			configuration.DefaultEndpointProbeIntervalMs),
+		probe:       probe,
+		endpoints:   endpoints,
+		probeCtx:    probeCtx,
+		stopProbing: stopProbing,
+	}
+}
+
+// pick returns the base URL the next request is sent to. If all endpoints have failed, requests are still sent to
+// the one which has been healthy most recently.
+func (e *dtEndpoints) pick(baseUrl string) string {
+	if e == nil {
+		return baseUrl
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.selection == configuration.EndpointSelection_RoundRobin {
+		for i := 0; i < len(e.endpoints); i++ {
+			index := (e.next + i) % len(e.endpoints)
+			if e.endpoints[index].healthy {
+				e.next = index + 1
+				return e.endpoints[index].baseUrl
+			}
+		}
+	}
+
+	return e.endpoints[e.preferred].baseUrl
+}
+
+// recordResult updates the health of the endpoint a request has been sent to. A failed endpoint is probed in the
+// background until it is reachable again. Returns true if the endpoint has just failed and another healthy endpoint
+// is picked for the next request.
+func (e *dtEndpoints) recordResult(baseUrl string, failed bool) bool {
+	if e == nil {
+		return false
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	index := e.indexOf(baseUrl)
+	if index < 0 {
+		return false
+	}
+
+	endpoint := &e.endpoints[index]
+	if !failed {
+		if !endpoint.healthy {
+			endpoint.healthy = true
+			e.logger.Infof("Endpoint %s is reachable again", endpoint.name)
+		}
+		e.preferred = index
+		return false
+	}
+
+	if !endpoint.healthy {
+		return false
+	}
+	endpoint.healthy = false
+
+	next, found := e.nextHealthy(index)
+	if index == e.preferred {
+		if found {
+			e.preferred = next
+			e.stats.recordEndpointFailover()
+			e.logger.Warnf("Endpoint %s has failed, requests are sent to %s", endpoint.name, e.endpoints[next].name)
+		} else {
+			e.logger.Warnf("Endpoint %s has failed, no other endpoint is healthy", endpoint.name)
+		}
+	} else {
+		e.logger.Warnf("Endpoint %s has failed", endpoint.name)
+	}
+
+	if !e.probing && !e.stopped {
+		e.probing = true
+		e.probeDone.Add(1)
+		go e.runProbes()
+	}
+	return found
+}
+
+// nextHealthy returns the endpoint requests fail over to if the given one fails, must be called with lock held.
+// With priority selection, it is the first healthy endpoint, otherwise the next healthy one after the failed one.
+func (e *dtEndpoints) nextHealthy(failed int) (int, bool) {
+	start := failed + 1
+	if e.selection != configuration.EndpointSelection_RoundRobin {
+		start = 0
+	}
+
+	for i := 0; i < len(e.endpoints); i++ {
+		index := (start + i) % len(e.endpoints)
+		if e.endpoints[index].healthy {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// indexOf returns the index of the endpoint with the given base URL or -1, must be called with lock held.
+func (e *dtEndpoints) indexOf(baseUrl string) int {
+	for i, endpoint := range e.endpoints {
+		if endpoint.baseUrl == baseUrl {
+			return i
+		}
+	}
+	return -1
+}
+
+// runProbes probes the failed endpoints every probe interval until all of them are healthy or probing is stopped.
+func (e *dtEndpoints) runProbes() {
+	defer e.probeDone.Done()
+
+	ticker := time.NewTicker(e.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.probeCtx.Done():
+			return
+		case <-ticker.C:
+			if !e.probeFailed() {
+				return
+			}
+		}
+	}
+}
+
+// probeFailed probes each failed endpoint once. Returns false if all endpoints are healthy, probing ends then.
+func (e *dtEndpoints) probeFailed() bool {
+	e.lock.Lock()
+	var failed []dtEndpoint
+	for _, endpoint := range e.endpoints {
+		if !endpoint.healthy {
+			failed = append(failed, endpoint)
+		}
+	}
+	e.lock.Unlock()
+
+	for _, endpoint := range failed {
+		ctx, cancel := context.WithTimeout(e.probeCtx, cEndpointProbeTimeout)
+		err := e.probe(ctx, endpoint.baseUrl)
+		cancel()
+
+		if err != nil {
+			e.logger.Debugf("Endpoint %s is still unreachable: %s", endpoint.name, err)
+			continue
+		}
+		e.recover(endpoint.baseUrl)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, endpoint := range e.endpoints {
+		if !endpoint.healthy {
+			return true
+		}
+	}
+	e.probing = false
+	return false
+}
+
+// recover marks an endpoint which has been probed successfully as healthy. With priority selection, requests are
+// sent to it again if it precedes the endpoint they have failed over to.
+func (e *dtEndpoints) recover(baseUrl string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	index := e.indexOf(baseUrl)
+	if index < 0 || e.endpoints[index].healthy {
+		return
+	}
+
+	e.endpoints[index].healthy = true
+	e.logger.Infof("Endpoint %s is reachable again", e.endpoints[index].name)
+
+	if !e.endpoints[e.preferred].healthy ||
+		(e.selection != configuration.EndpointSelection_RoundRobin && index < e.preferred) {
+		e.preferred = index
+	}
+}
+
+// newDtEndpointProbe returns a probe which queries the cluster time of an endpoint, since this request neither creates
+// data nor requires a request body. Any response except a 5xx one means that the endpoint is reachable.
+func newDtEndpointProbe(
+	config *configuration.DtConfiguration,
+	connection *dtConnection,
+	client *http.Client,
+) func(ctx context.Context, baseUrl string) error {
+	return func(ctx context.Context, baseUrl string) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", baseUrl+cClusterTimePath, nil)
+		if err != nil {
+			return err
+		}
+		setDtRequestHeaders(req, config, connection.settings().authToken)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+		if isEndpointFailureStatusCode(resp.StatusCode) {
+			return errors.New("unexpected response code: " + strconv.Itoa(resp.StatusCode))
+		}
+		return nil
+	}
+}
+
+// endpointFailedError wraps an export error which is not retryable but has made requests fail over to another
+// endpoint. The request is sent once more to that endpoint, since it may well accept it.
+type endpointFailedError struct {
+	err error
+}
+
+func (e *endpointFailedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *endpointFailedError) Unwrap() error {
+	return e.err
+}
+
+// isEndpointFailureStatusCode reports whether a response status code means that the endpoint has failed and
+// requests should be sent to another one.
+func isEndpointFailureStatusCode(statusCode int) bool {
+	return statusCode >= 500
+}
+
+// isHealthy reports whether the endpoint with the given base URL has not failed or is reachable again.
+func (e *dtEndpoints) isHealthy(baseUrl string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	index := e.indexOf(baseUrl)
+	return index >= 0 && e.endpoints[index].healthy
+}
+
+// stop stops probing and waits until a probe in progress is finished.
+func (e *dtEndpoints) stop() {
+	if e == nil {
+		return
+	}
+
+	e.lock.Lock()
+	e.stopped = true
+	e.lock.Unlock()
+
+	e.stopProbing()
+	e.probeDone.Wait()
+}
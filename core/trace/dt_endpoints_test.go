@@ -0,0 +1,281 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+// endpointTestServer counts the span export requests it receives, it answers all requests with 503 while it is down.
+type endpointTestServer struct {
+	*httptest.Server
+	down        int32
+	numRequests int32
+}
+
+func newEndpointTestServer(t *testing.T) *endpointTestServer {
+	server := &endpointTestServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&server.down) != 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if req.URL.Path == cClusterTimePath {
+			writeClusterTimeResponse(rw, time.Now())
+			return
+		}
+		atomic.AddInt32(&server.numRequests, 1)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *endpointTestServer) setDown(down bool) {
+	var value int32
+	if down {
+		value = 1
+	}
+	atomic.StoreInt32(&s.down, value)
+}
+
+func (s *endpointTestServer) requests() int32 {
+	return atomic.LoadInt32(&s.numRequests)
+}
+
+// newEndpointsTestExporter creates a span exporter which sends spans to the given servers.
+func newEndpointsTestExporter(
+	selection configuration.EndpointSelection,
+	probeIntervalMs int,
+	servers ...*endpointTestServer,
+) *dtSpanExporterImpl {
+	unused, config := createTestServerAndConfig(nil)
+	unused.Close()
+	config.BaseUrl = servers[0].URL
+	for _, server := range servers {
+		config.BaseUrls = append(config.BaseUrls, server.URL)
+	}
+	config.EndpointSelection = selection
+	config.EndpointProbeIntervalMs = probeIntervalMs
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+	return exporter
+}
+
+func TestEndpointsNotCreatedForSingleBaseUrl(t *testing.T) {
+	server := newEndpointTestServer(t)
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 0, server)
+
+	require.Nil(t, exporter.endpoints)
+	require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3}))
+	require.EqualValues(t, 1, server.requests())
+}
+
+func TestEndpointsFailOverOnServerError(t *testing.T) {
+	primary, secondary := newEndpointTestServer(t), newEndpointTestServer(t)
+	primary.setDown(true)
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 3600000, primary, secondary)
+	defer exporter.endpoints.stop()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.NoError(t, err, "the retry must be sent to the healthy endpoint")
+	require.EqualValues(t, 1, secondary.requests())
+	require.False(t, exporter.endpoints.isHealthy(primary.URL))
+	require.EqualValues(t, 1, exporter.stats.snapshot().EndpointFailovers)
+
+	// requests stay with the endpoint which has been healthy most recently
+	primary.setDown(false)
+	err = exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, secondary.requests())
+	require.Zero(t, primary.requests())
+}
+
+func TestEndpointsFailOverOnConnectionError(t *testing.T) {
+	closed, healthy := newEndpointTestServer(t), newEndpointTestServer(t)
+	closed.Close()
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 3600000, closed, healthy)
+	defer exporter.endpoints.stop()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, healthy.requests())
+	require.False(t, exporter.endpoints.isHealthy(closed.URL))
+}
+
+func TestEndpointsResendChunkAfterFailover(t *testing.T) {
+	var numRequests int32
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&numRequests, 1)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	healthy := newEndpointTestServer(t)
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 3600000,
+		&endpointTestServer{Server: failing}, healthy)
+	defer exporter.endpoints.stop()
+
+	// 500 is not retryable, but the chunk is sent once more to the endpoint requests have failed over to
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, atomic.LoadInt32(&numRequests))
+	require.EqualValues(t, 1, healthy.requests())
+	require.False(t, exporter.endpoints.isHealthy(failing.URL))
+}
+
+func TestEndpointsResendChunkOnlyOnceAfterFailover(t *testing.T) {
+	var numRequests int32
+	newFailingServer := func() *endpointTestServer {
+		failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&numRequests, 1)
+			rw.WriteHeader(http.StatusInternalServerError)
+		}))
+		t.Cleanup(failing.Close)
+		return &endpointTestServer{Server: failing}
+	}
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 3600000,
+		newFailingServer(), newFailingServer(), newFailingServer())
+	defer exporter.endpoints.stop()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.EqualError(t, err, "unexpected response code: 500")
+	require.EqualValues(t, 2, atomic.LoadInt32(&numRequests))
+}
+
+func TestEndpointsDoNotFailOverOnClientError(t *testing.T) {
+	var numRequests int32
+	rejecting := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&numRequests, 1)
+		rw.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	healthy := newEndpointTestServer(t)
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 3600000,
+		&endpointTestServer{Server: rejecting}, healthy)
+	defer exporter.endpoints.stop()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.EqualError(t, err, "unexpected response code: 400")
+	require.EqualValues(t, 1, atomic.LoadInt32(&numRequests))
+	require.Zero(t, healthy.requests())
+	require.True(t, exporter.endpoints.isHealthy(rejecting.URL))
+}
+
+func TestEndpointsProbeFailedEndpointBeforeSendingRequests(t *testing.T) {
+	primary, secondary := newEndpointTestServer(t), newEndpointTestServer(t)
+	primary.setDown(true)
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 10, primary, secondary)
+	defer exporter.endpoints.stop()
+
+	require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3}))
+	require.EqualValues(t, 1, secondary.requests())
+
+	// the probes keep failing while the endpoint is down
+	time.Sleep(50 * time.Millisecond)
+	require.False(t, exporter.endpoints.isHealthy(primary.URL))
+
+	primary.setDown(false)
+	require.Eventually(t, func() bool {
+		return exporter.endpoints.isHealthy(primary.URL)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Zero(t, primary.requests(), "probes must not send spans")
+
+	// with priority selection, requests return to the endpoint which precedes the one they have failed over to
+	require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3}))
+	require.EqualValues(t, 1, primary.requests())
+	require.EqualValues(t, 1, secondary.requests())
+}
+
+func TestEndpointsRoundRobin(t *testing.T) {
+	servers := []*endpointTestServer{newEndpointTestServer(t), newEndpointTestServer(t), newEndpointTestServer(t)}
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_RoundRobin, 3600000, servers...)
+	defer exporter.endpoints.stop()
+
+	for i := 0; i < 6; i++ {
+		require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3}))
+	}
+	for _, server := range servers {
+		require.EqualValues(t, 2, server.requests())
+	}
+
+	// a failed endpoint is skipped until it is reachable again
+	servers[1].setDown(true)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3}))
+	}
+	require.EqualValues(t, 2, servers[1].requests())
+	require.EqualValues(t, 10, servers[0].requests()+servers[2].requests())
+}
+
+func TestEndpointsAllFailed(t *testing.T) {
+	primary, secondary := newEndpointTestServer(t), newEndpointTestServer(t)
+	primary.setDown(true)
+	secondary.setDown(true)
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 3600000, primary, secondary)
+	defer exporter.endpoints.stop()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.EqualError(t, err, "unexpected response code: 503")
+
+	// requests are still sent, the first endpoint which responds is used again
+	secondary.setDown(false)
+	require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3}))
+	require.EqualValues(t, 1, secondary.requests())
+	require.True(t, exporter.endpoints.isHealthy(secondary.URL))
+}
+
+func TestEndpointsStopProbing(t *testing.T) {
+	primary, secondary := newEndpointTestServer(t), newEndpointTestServer(t)
+	primary.setDown(true)
+	numGoroutines := runtime.NumGoroutine()
+	exporter := newEndpointsTestExporter(configuration.EndpointSelection_Priority, 10, primary, secondary)
+
+	require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3}))
+	exporter.endpoints.stop()
+
+	// a failure after stopping must not start probing again
+	exporter.endpoints.recordResult(secondary.URL, true)
+	exporter.client.CloseIdleConnections()
+	require.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= numGoroutines
+	}, 5*time.Second, 10*time.Millisecond, "the probe goroutine must have stopped")
+}
+
+func TestEndpointsKeepBaseUrlWhenConnectionIsUpdated(t *testing.T) {
+	config := *testConfig
+	config.BaseUrls = configuration.UrlList{"https://primary.example.com", "https://secondary.example.com"}
+	config.BaseUrl = config.BaseUrls[0]
+	p := newDtSpanProcessor(&config, nil)
+	defer p.shutdown(context.Background()) //nolint:errcheck
+
+	p.updateConnection(func(settings *dtConnectionSettings) {
+		settings.baseUrl = "https://other.example.com"
+		settings.authToken = "newToken"
+	})
+
+	settings := p.connection().settings()
+	require.Equal(t, "https://primary.example.com", settings.baseUrl, "the endpoints can not be changed at runtime")
+	require.Equal(t, "newToken", settings.authToken)
+}
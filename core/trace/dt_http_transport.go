@@ -0,0 +1,132 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+var tlsVersions = map[configuration.TlsVersion]uint16{
+	configuration.TlsVersion_1_0: tls.VersionTLS10,
+	configuration.TlsVersion_1_1: tls.VersionTLS11,
+	configuration.TlsVersion_1_2: tls.VersionTLS12,
+	configuration.TlsVersion_1_3: tls.VersionTLS13,
+}
+
+// newDtHttpClient creates the client which sends requests to Dynatrace Cluster. A custom transport is used as is,
+// otherwise the transport is created from the proxy, TLS and connection pool settings of the configuration.
+// The client is usable even if an error is returned, see newDtHttpTransport.
+func newDtHttpClient(config *configuration.DtConfiguration, transport http.RoundTripper) (*http.Client, error) {
+	var err error
+	if transport == nil {
+		transport, err = newDtHttpTransport(config)
+	}
+
+	return &http.Client{
+		Transport: transport,
+	}, err
+}
+
+// newDtHttpTransport creates a transport which establishes connections within the connection timeout of the
+// request. If a certificate can not be loaded, the transport is created without it and an error is returned.
+func newDtHttpTransport(config *configuration.DtConfiguration) (*http.Transport, error) {
+	proxy, proxyErr := newProxyFunc(config)
+	tlsConfig, tlsErr := newTlsConfig(config)
+
+	transport := &http.Transport{
+		Proxy:               proxy,
+		DialContext:         dialWithConnTimeout,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        config.MaxIdleConns,
+		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Millisecond * time.Duration(config.IdleConnTimeoutMs),
+	}
+
+	if proxyErr != nil {
+		return transport, proxyErr
+	}
+	return transport, tlsErr
+}
+
+// newProxyFunc returns the proxy of the configuration with its credentials, the proxy given by HTTPS_PROXY and
+// NO_PROXY if none is configured.
+func newProxyFunc(config *configuration.DtConfiguration) (func(*http.Request) (*url.URL, error), error) {
+	if config.ProxyUrl == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyUrl, err := url.Parse(config.ProxyUrl)
+	if err != nil {
+		return http.ProxyFromEnvironment, fmt.Errorf("invalid ProxyUrl: %w", err)
+	}
+
+	if config.ProxyUsername != "" || config.ProxyPassword != "" {
+		proxyUrl.User = url.UserPassword(config.ProxyUsername, config.ProxyPassword)
+	}
+	return http.ProxyURL(proxyUrl), nil
+}
+
+// newTlsConfig creates the TLS configuration with the minimum TLS version, the CA bundle which is trusted in addition
+// to the system certificates and the client certificate. A certificate which can not be loaded is left out.
+func newTlsConfig(config *configuration.DtConfiguration) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tlsVersions[config.TlsMinVersion],
+	}
+
+	var err error
+	if config.CaBundleFile != "" {
+		var rootCAs *x509.CertPool
+		if rootCAs, err = loadCaBundle(config.CaBundleFile); err == nil {
+			tlsConfig.RootCAs = rootCAs
+		}
+	}
+
+	if config.ClientCertFile != "" {
+		cert, certErr := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if certErr != nil {
+			err = fmt.Errorf("can not load client certificate: %w", certErr)
+		} else {
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	return tlsConfig, err
+}
+
+// loadCaBundle returns the system certificate pool with the certificates of the given PEM file added.
+func loadCaBundle(filePath string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("can not read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA bundle %s does not contain any PEM encoded certificate", filePath)
+	}
+	return pool, nil
+}
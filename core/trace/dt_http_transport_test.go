@@ -0,0 +1,270 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+// newTlsTestServerAndConfig starts a TLS server with the given handler and returns a configuration which sends
+// spans to it.
+func newTlsTestServerAndConfig(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *configuration.DtConfiguration) {
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	return server, newTlsTestConfig(server)
+}
+
+func newTlsTestConfig(server *httptest.Server) *configuration.DtConfiguration {
+	return &configuration.DtConfiguration{
+		ClusterId:                -1234,
+		Tenant:                   "testDtTenant",
+		AgentId:                  10,
+		BaseUrl:                  server.URL,
+		AuthToken:                "testDtToken",
+		SpanProcessingIntervalMs: configuration.DefaultSpanProcessingIntervalMs,
+		LoggingDestination:       configuration.LoggingDestination_Stdout,
+		LoggingFlags:             "SpanExporter=true,SpanProcessor=true,TracerProvider=true",
+	}
+}
+
+// writePemFileForTest writes a PEM file with the given block to the temp dir of the test.
+func writePemFileForTest(t *testing.T, name string, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+// writeServerCaBundleForTest writes the certificate of a TLS test server to a CA bundle file.
+func writeServerCaBundleForTest(t *testing.T, server *httptest.Server) string {
+	return writePemFileForTest(t, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+// newClientCertificateForTest creates a self-signed client certificate and writes it and its key to PEM files.
+func newClientCertificateForTest(t *testing.T, commonName string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return cert, writePemFileForTest(t, "client.pem", "CERTIFICATE", der),
+		writePemFileForTest(t, "client-key.pem", "EC PRIVATE KEY", keyDer)
+}
+
+func sendTestExportRequest(config *configuration.DtConfiguration, transport http.RoundTripper) error {
+	exporter := newDtSpanExporter(config, newDtStats(), transport).(*dtSpanExporterImpl)
+	return exporter.sendExportRequest(context.Background(), exportTypePeriodic, exportData("spans"))
+}
+
+func TestHttpTransportTrustsCaBundle(t *testing.T) {
+	var requests int32
+	server, config := newTlsTestServerAndConfig(t, func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	})
+
+	require.Error(t, sendTestExportRequest(config, nil), "the certificate of the server is not trusted by default")
+
+	config.CaBundleFile = writeServerCaBundleForTest(t, server)
+	require.NoError(t, sendTestExportRequest(config, nil))
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestHttpTransportInvalidCaBundle(t *testing.T) {
+	config := &configuration.DtConfiguration{CaBundleFile: filepath.Join(t.TempDir(), "missing.pem")}
+	_, err := newDtHttpTransport(config)
+	require.Error(t, err)
+
+	config.CaBundleFile = writePemFileForTest(t, "ca.pem", "PRIVATE KEY", []byte("no certificate"))
+	transport, err := newDtHttpTransport(config)
+	require.Error(t, err)
+	require.NotNil(t, transport, "the transport is usable without the CA bundle")
+	require.Nil(t, transport.TLSClientConfig.RootCAs)
+}
+
+func TestHttpTransportPresentsClientCertificate(t *testing.T) {
+	clientCert, certFile, keyFile := newClientCertificateForTest(t, "test-client")
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	var commonName atomic.Value
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		commonName.Store(req.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	config := newTlsTestConfig(server)
+	config.CaBundleFile = writeServerCaBundleForTest(t, server)
+
+	require.Error(t, sendTestExportRequest(config, nil), "the server requires a client certificate")
+
+	config.ClientCertFile = certFile
+	config.ClientKeyFile = keyFile
+	require.NoError(t, sendTestExportRequest(config, nil))
+	require.Equal(t, "test-client", commonName.Load())
+
+	config.ClientKeyFile = config.CaBundleFile
+	_, err := newDtHttpTransport(config)
+	require.Error(t, err, "the key does not match the certificate")
+}
+
+func TestHttpTransportTlsMinVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	config := newTlsTestConfig(server)
+	config.CaBundleFile = writeServerCaBundleForTest(t, server)
+
+	config.TlsMinVersion = configuration.TlsVersion_1_2
+	require.NoError(t, sendTestExportRequest(config, nil))
+
+	config.TlsMinVersion = configuration.TlsVersion_1_3
+	require.Error(t, sendTestExportRequest(config, nil), "the server does not support TLS 1.3")
+}
+
+func TestHttpTransportSendsThroughProxy(t *testing.T) {
+	var requests int32
+	server, config := newTlsTestServerAndConfig(t, func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	})
+
+	// the proxy tunnels the TLS connection to the server if the credentials are valid
+	var proxyAuthorization atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		proxyAuthorization.Store(req.Header.Get("Proxy-Authorization"))
+		if req.Method != http.MethodConnect || req.Host != server.Listener.Addr().String() {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		rw.WriteHeader(http.StatusOK)
+		conn, buf, err := rw.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		go func() {
+			_, _ = io.Copy(upstream, bufio.NewReader(buf))
+		}()
+		_, _ = io.Copy(conn, upstream)
+	}))
+	defer proxy.Close()
+
+	config.CaBundleFile = writeServerCaBundleForTest(t, server)
+	config.ProxyUrl = proxy.URL
+	config.ProxyUsername = "proxyUser"
+	config.ProxyPassword = "proxySecret"
+	require.NoError(t, sendTestExportRequest(config, nil))
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	require.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("proxyUser:proxySecret")), proxyAuthorization.Load())
+}
+
+func TestHttpTransportConnectionPool(t *testing.T) {
+	config := &configuration.DtConfiguration{
+		MaxIdleConns:        20,
+		MaxIdleConnsPerHost: 5,
+		IdleConnTimeoutMs:   30000,
+	}
+	transport, err := newDtHttpTransport(config)
+	require.NoError(t, err)
+	require.Equal(t, 20, transport.MaxIdleConns)
+	require.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	require.Equal(t, 30*time.Second, transport.IdleConnTimeout)
+}
+
+type countingRoundTripper struct {
+	transport http.RoundTripper
+	requests  int32
+}
+
+func (rt *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&rt.requests, 1)
+	return rt.transport.RoundTrip(req)
+}
+
+func TestHttpTransportCustomRoundTripper(t *testing.T) {
+	server, config := newTlsTestServerAndConfig(t, func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == cClusterTimePath {
+			writeClusterTimeResponse(rw, time.Now())
+		}
+	})
+
+	// the custom transport trusts the server although no CA bundle is configured
+	roundTripper := &countingRoundTripper{transport: server.Client().Transport}
+	require.NoError(t, sendTestExportRequest(config, roundTripper))
+	require.Equal(t, int32(1), atomic.LoadInt32(&roundTripper.requests))
+
+	roundTripper = &countingRoundTripper{transport: server.Client().Transport}
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(config), WithHttpRoundTripper(roundTripper))
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	span.End()
+	require.NoError(t, tp.ForceFlush(context.Background()))
+	require.NotZero(t, atomic.LoadInt32(&roundTripper.requests))
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"net/http"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/logger"
+)
+
+// Option configures a DtTracerProvider or a DtTextMapPropagator.
+type Option interface {
+	apply(*options)
+}
+
+type optionFunc func(*options)
+
+func (f optionFunc) apply(o *options) {
+	f(o)
+}
+
+type options struct {
+	config        *configuration.DtConfiguration
+	sdkOptions    []sdktrace.TracerProviderOption
+	lenient       bool
+	configRetrier *dtConfigRetrier
+	transport     http.RoundTripper
+}
+
+// WithDtConfiguration sets the configuration to use instead of the one provided by
+// configuration.GlobalConfigurationProvider. The configuration should be created with
+// configuration.BuildConfiguration, otherwise default values are applied to a copy of it like
+// configuration.BuildConfiguration does. It is validated when the component is created.
+func WithDtConfiguration(config *configuration.DtConfiguration) Option {
+	return optionFunc(func(o *options) {
+		o.config = config
+	})
+}
+
+// WithTracerProviderOptions sets the options of the wrapped SDK TracerProvider.
+// Ignored by NewTextMapPropagator.
+func WithTracerProviderOptions(opts ...sdktrace.TracerProviderOption) Option {
+	return optionFunc(func(o *options) {
+		o.sdkOptions = append(o.sdkOptions, opts...)
+	})
+}
+
+// WithLenientConfiguration makes a missing or invalid configuration non-fatal. Instead of returning an error, a
+// DtTracerProvider is created which records spans with the wrapped SDK TracerProvider without exporting them, and a
+// DtTextMapPropagator is created which only propagates W3C trace context. A warning is printed once. Loading the
+// global configuration is retried periodically by a DtTracerProvider until it is shut down, exporting and Dynatrace
+// propagation start once it is valid, e.g. when the config file is mounted late. A DtTextMapPropagator does not retry
+// on its own, it starts propagating Dynatrace trace context once a DtTracerProvider or DtSpanProcessor has found a
+// valid configuration. Ignored by NewSpanProcessor.
+func WithLenientConfiguration() Option {
+	return optionFunc(func(o *options) {
+		o.lenient = true
+	})
+}
+
+// WithHttpRoundTripper sets the HTTP transport which sends spans to Dynatrace Cluster, e.g. to route requests through
+// a service mesh or to instrument them. The proxy, TLS and connection pool settings of the configuration are ignored
+// then and the connection timeouts are not applied, the data timeouts are.
+// Ignored by NewTextMapPropagator.
+func WithHttpRoundTripper(transport http.RoundTripper) Option {
+	return optionFunc(func(o *options) {
+		o.transport = transport
+	})
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+	return o
+}
+
+// retrier returns the retrier which loads the configuration if none is provided explicitly, by default the global
+// configuration. It loads the configuration again in lenient mode.
+func (o *options) retrier() *dtConfigRetrier {
+	if o.configRetrier != nil {
+		return o.configRetrier
+	}
+	return globalConfigRetrier
+}
+
+// resolveConfiguration returns the explicitly provided configuration if any, otherwise the global one.
+// The internal logger is configured with the resolved configuration if it has not been configured yet.
+func (o *options) resolveConfiguration() (*configuration.DtConfiguration, error) {
+	config := o.config
+	if config == nil {
+		var err error
+		config, err = o.retrier().load()
+		if err != nil {
+			return nil, err
+		}
+		// components in lenient mode which are still waiting for the configuration start using it as well
+		o.retrier().configLoaded(config)
+	} else {
+		var err error
+		config, err = config.Complete()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Configure(config)
+	return config, nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/logger"
+)
+
+const (
+	cChunkFileSuffix    = ".chunk"
+	cChunkTmpFileSuffix = ".tmp"
+	cChunkFileVersion   = 1
+	// magic (4) + version (1) + oldest span end time (8) + payload length (4) + payload checksum (4)
+	cChunkHeaderSize = 21
+
+	// Dynatrace Cluster rejects spans which have ended more than 60 minutes ago, this is the maximum span age given in
+	// the limits of the Dynatrace trace ingest. Persisted chunks containing such spans are discarded instead of sent.
+	cSpanAcceptanceWindow = time.Hour
+)
+
+var cChunkFileMagic = []byte("DTSQ")
+
+var crc32Table = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptChunk = errors.New("persisted chunk is corrupt")
+
+// persistedChunk is a serialized SpanExport chunk stored in the persistent queue. The age of a chunk is the age of
+// the span which has ended first, since this is what Dynatrace Cluster checks.
+type persistedChunk struct {
+	name          string
+	oldestSpanEnd time.Time
+	data          exportData
+}
+
+type persistedChunkEntry struct {
+	name string
+	size int64
+}
+
+// dtPersistentQueue is a disk-backed FIFO queue of serialized SpanExport chunks that could not be sent.
+// Every chunk is stored in its own file which is written to a temporary file first and renamed afterwards, so that
+// a crash while writing never leaves a partially written chunk behind. Each file contains a header with a checksum of
+// the payload, corrupt files are detected and discarded when read.
+// The total size of all chunk files is bounded, the oldest chunks are evicted first when the limit is reached.
+type dtPersistentQueue struct {
+	dir          string
+	maxSizeBytes int64
+	sizeBytes    int64
+	entries      []persistedChunkEntry
+	nextSeq      uint64
+	lock         sync.Mutex
+	logger       *logger.ComponentLogger
+}
+
+// newDtPersistentQueue opens the persistent queue in the given directory. Chunks stored by a previous process are kept
+// and will be returned by the queue.
+func newDtPersistentQueue(dir string, maxSizeBytes int64) (*dtPersistentQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	q := &dtPersistentQueue{
+		dir:          dir,
+		maxSizeBytes: maxSizeBytes,
+		logger:       logger.NewComponentLogger("PersistentQueue"),
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() {
+			continue
+		}
+
+		if strings.HasSuffix(name, cChunkTmpFileSuffix) {
+			// left over from an interrupted write
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+
+		seq, ok := parseChunkFileName(name)
+		if !ok {
+			continue
+		}
+
+		q.entries = append(q.entries, persistedChunkEntry{name: name, size: file.Size()})
+		q.sizeBytes += file.Size()
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+
+	sort.Slice(q.entries, func(i, j int) bool {
+		return q.entries[i].name < q.entries[j].name
+	})
+
+	q.logger.Debugf("Opened persistent queue in %s with %d chunks (%d bytes)", dir, len(q.entries), q.sizeBytes)
+	q.evict(0)
+	return q, nil
+}
+
+// enqueue stores a chunk at the end of the queue together with the end time of its oldest span. The oldest chunks
+// are evicted if the size limit would be exceeded.
+func (q *dtPersistentQueue) enqueue(data exportData, oldestSpanEnd time.Time) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	size := int64(cChunkHeaderSize + len(data))
+	if size > q.maxSizeBytes {
+		return fmt.Errorf("chunk size (%d) exceeds the persistent queue size limit (%d)", size, q.maxSizeBytes)
+	}
+
+	q.evict(size)
+
+	name := fmt.Sprintf("%020d%s", q.nextSeq, cChunkFileSuffix)
+	q.nextSeq++
+
+	if err := writeChunkFile(filepath.Join(q.dir, name), data, oldestSpanEnd); err != nil {
+		return err
+	}
+
+	q.entries = append(q.entries, persistedChunkEntry{name: name, size: size})
+	q.sizeBytes += size
+	return nil
+}
+
+// names returns the names of all chunks currently in the queue, oldest first.
+func (q *dtPersistentQueue) names() []string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	names := make([]string, 0, len(q.entries))
+	for _, entry := range q.entries {
+		names = append(names, entry.name)
+	}
+	return names
+}
+
+func (q *dtPersistentQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.entries)
+}
+
+// load reads the chunk with the given name. Corrupt chunks are removed from the queue and errCorruptChunk is returned.
+func (q *dtPersistentQueue) load(name string) (*persistedChunk, error) {
+	fileData, err := ioutil.ReadFile(filepath.Join(q.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			q.remove(name)
+		}
+		return nil, err
+	}
+
+	data, oldestSpanEnd, err := decodeChunkFile(fileData)
+	if err != nil {
+		q.logger.Warnf("Discard persisted chunk %s: %s", name, err)
+		q.remove(name)
+		return nil, err
+	}
+
+	return &persistedChunk{name: name, oldestSpanEnd: oldestSpanEnd, data: data}, nil
+}
+
+// remove deletes the chunk with the given name from the queue.
+func (q *dtPersistentQueue) remove(name string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, entry := range q.entries {
+		if entry.name == name {
+			q.deleteEntry(i)
+			return
+		}
+	}
+}
+
+// evict removes the oldest chunks until the given number of bytes fits into the queue. Must be called with lock held.
+func (q *dtPersistentQueue) evict(requiredBytes int64) {
+	for len(q.entries) > 0 && q.sizeBytes+requiredBytes > q.maxSizeBytes {
+		q.logger.Infof("Persistent queue size limit reached, evicting oldest chunk %s", q.entries[0].name)
+		q.deleteEntry(0)
+	}
+}
+
+// deleteEntry removes the file of the entry at the given index. Must be called with lock held.
+func (q *dtPersistentQueue) deleteEntry(i int) {
+	entry := q.entries[i]
+	if err := os.Remove(filepath.Join(q.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+		q.logger.Warnf("Can not remove persisted chunk %s: %s", entry.name, err)
+	}
+
+	q.entries = append(q.entries[:i], q.entries[i+1:]...)
+	q.sizeBytes -= entry.size
+}
+
+func parseChunkFileName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, cChunkFileSuffix) {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimSuffix(name, cChunkFileSuffix), 10, 64)
+	return seq, err == nil
+}
+
+func writeChunkFile(path string, data exportData, oldestSpanEnd time.Time) error {
+	tmpPath := path + cChunkTmpFileSuffix
+	if err := ioutil.WriteFile(tmpPath, encodeChunkFile(data, oldestSpanEnd), 0600); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func encodeChunkFile(data exportData, oldestSpanEnd time.Time) []byte {
+	buf := make([]byte, cChunkHeaderSize, cChunkHeaderSize+len(data))
+	copy(buf, cChunkFileMagic)
+	buf[4] = cChunkFileVersion
+	binary.BigEndian.PutUint64(buf[5:], uint64(oldestSpanEnd.UnixNano()/int64(time.Millisecond)))
+	binary.BigEndian.PutUint32(buf[13:], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[17:], crc32.Checksum(data, crc32Table))
+	return append(buf, data...)
+}
+
+func decodeChunkFile(fileData []byte) (exportData, time.Time, error) {
+	if len(fileData) < cChunkHeaderSize ||
+		!bytes.Equal(fileData[:4], cChunkFileMagic) ||
+		fileData[4] != cChunkFileVersion {
+		return nil, time.Time{}, errCorruptChunk
+	}
+
+	oldestSpanEndMs := int64(binary.BigEndian.Uint64(fileData[5:]))
+	length := binary.BigEndian.Uint32(fileData[13:])
+	checksum := binary.BigEndian.Uint32(fileData[17:])
+
+	data := fileData[cChunkHeaderSize:]
+	if uint32(len(data)) != length || crc32.Checksum(data, crc32Table) != checksum {
+		return nil, time.Time{}, errCorruptChunk
+	}
+
+	return data, time.Unix(0, oldestSpanEndMs*int64(time.Millisecond)), nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPersistentQueueEnqueueAndLoad(t *testing.T) {
+	q, err := newDtPersistentQueue(t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	oldestSpanEnd := time.Now().Truncate(time.Millisecond)
+	require.NoError(t, q.enqueue(exportData{1, 2, 3}, oldestSpanEnd))
+	require.NoError(t, q.enqueue(exportData{4, 5}, oldestSpanEnd))
+
+	names := q.names()
+	require.Len(t, names, 2)
+
+	chunk, err := q.load(names[0])
+	require.NoError(t, err)
+	require.Equal(t, exportData{1, 2, 3}, chunk.data)
+	require.True(t, oldestSpanEnd.Equal(chunk.oldestSpanEnd))
+
+	q.remove(names[0])
+	require.Equal(t, []string{names[1]}, q.names())
+	require.EqualValues(t, cChunkHeaderSize+2, q.sizeBytes)
+}
+
+func TestPersistentQueueKeepsChunksAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newDtPersistentQueue(dir, 1024)
+	require.NoError(t, err)
+	require.NoError(t, q.enqueue(exportData{1}, time.Now()))
+	require.NoError(t, q.enqueue(exportData{2}, time.Now()))
+
+	// simulate a write interrupted by a crash
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "00000000000000000005.chunk.tmp"), []byte{1}, 0600))
+
+	reopened, err := newDtPersistentQueue(dir, 1024)
+	require.NoError(t, err)
+	require.Equal(t, q.names(), reopened.names())
+	require.NoFileExists(t, filepath.Join(dir, "00000000000000000005.chunk.tmp"))
+
+	require.NoError(t, reopened.enqueue(exportData{3}, time.Now()))
+	names := reopened.names()
+	require.Len(t, names, 3)
+
+	chunk, err := reopened.load(names[2])
+	require.NoError(t, err)
+	require.Equal(t, exportData{3}, chunk.data, "new chunks must be appended after existing ones")
+}
+
+func TestPersistentQueueEvictsOldestChunks(t *testing.T) {
+	chunkSize := int64(cChunkHeaderSize + 10)
+	q, err := newDtPersistentQueue(t.TempDir(), 3*chunkSize)
+	require.NoError(t, err)
+
+	for i := byte(0); i < 5; i++ {
+		require.NoError(t, q.enqueue(exportData{i, 0, 0, 0, 0, 0, 0, 0, 0, 0}, time.Now()))
+	}
+
+	names := q.names()
+	require.Len(t, names, 3)
+	require.Equal(t, 3*chunkSize, q.sizeBytes)
+
+	chunk, err := q.load(names[0])
+	require.NoError(t, err)
+	require.EqualValues(t, 2, chunk.data[0], "the oldest chunks must be evicted first")
+
+	err = q.enqueue(make(exportData, 3*chunkSize), time.Now())
+	require.Error(t, err, "a chunk larger than the size limit can not be stored")
+	require.Len(t, q.names(), 3)
+}
+
+func TestPersistentQueueDiscardsCorruptChunks(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newDtPersistentQueue(dir, 1024)
+	require.NoError(t, err)
+	require.NoError(t, q.enqueue(exportData{1, 2, 3}, time.Now()))
+
+	name := q.names()[0]
+	path := filepath.Join(dir, name)
+	fileData, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	fileData[len(fileData)-1] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(path, fileData, 0600))
+
+	_, err = q.load(name)
+	require.ErrorIs(t, err, errCorruptChunk)
+	require.Zero(t, q.len())
+	require.NoFileExists(t, path)
+}
+
+func TestDecodeChunkFile(t *testing.T) {
+	fileData := encodeChunkFile(exportData{1, 2, 3}, time.Now())
+
+	_, _, err := decodeChunkFile(fileData)
+	require.NoError(t, err)
+
+	_, _, err = decodeChunkFile(fileData[:cChunkHeaderSize-1])
+	require.ErrorIs(t, err, errCorruptChunk)
+
+	_, _, err = decodeChunkFile(fileData[:len(fileData)-1])
+	require.ErrorIs(t, err, errCorruptChunk, "truncated payload must be detected")
+
+	_, _, err = decodeChunkFile(append([]byte("XXXX"), fileData[4:]...))
+	require.ErrorIs(t, err, errCorruptChunk)
+}
+
+func TestSpanExportStoresFailedChunksAndReplaysThem(t *testing.T) {
+	var lock sync.Mutex
+	statusCode := http.StatusServiceUnavailable
+	var received [][]byte
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+		if statusCode == http.StatusOK {
+			received = append(received, body)
+		}
+		rw.WriteHeader(statusCode)
+	})
+	defer testServer.Close()
+
+	config.PersistentQueueDirectory = t.TempDir()
+	config.PersistentQueueMaxSizeMb = 1
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+	require.NotNil(t, exporter.queue)
+
+	tracer := createTracer()
+	_, span := tracer.Start(context.Background(), "span")
+	span.End()
+
+	err := exporter.export(context.Background(), exportTypeForceFlush, makeSpanSet(span))
+	require.EqualError(t, err, "unexpected response code: 503")
+	require.Equal(t, 1, exporter.queue.len(), "the chunk that could not be sent must be persisted")
+
+	lock.Lock()
+	statusCode = http.StatusOK
+	lock.Unlock()
+
+	// a new exporter picks up chunks stored by a previous process
+	exporter = newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	require.Equal(t, 1, exporter.queue.len())
+
+	err = exporter.export(context.Background(), exportTypePeriodic, dtSpanSet{})
+	require.NoError(t, err)
+	require.Zero(t, exporter.queue.len())
+	require.Len(t, received, 1)
+}
+
+func TestSpanExportDiscardsOutdatedPersistedChunks(t *testing.T) {
+	numRequests := 0
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		numRequests++
+	})
+	defer testServer.Close()
+
+	config.PersistentQueueDirectory = t.TempDir()
+	config.PersistentQueueMaxSizeMb = 1
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	require.NoError(t, exporter.queue.enqueue(exportData{1}, time.Now().Add(-cSpanAcceptanceWindow-time.Minute)))
+	require.NoError(t, exporter.queue.enqueue(exportData{2}, time.Now()))
+
+	err := exporter.export(context.Background(), exportTypePeriodic, dtSpanSet{})
+	require.NoError(t, err)
+	require.Zero(t, exporter.queue.len())
+	require.Equal(t, 1, numRequests, "outdated chunks must not be sent")
+}
+
+func TestSpanExportAgesPersistedChunksBySpanEndTime(t *testing.T) {
+	var lock sync.Mutex
+	statusCode := http.StatusServiceUnavailable
+	numRequests := 0
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		numRequests++
+		rw.WriteHeader(statusCode)
+	})
+	defer testServer.Close()
+
+	config.PersistentQueueDirectory = t.TempDir()
+	config.PersistentQueueMaxSizeMb = 1
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+
+	// the span has ended long before the chunk is stored
+	endTime := time.Now().Add(-cSpanAcceptanceWindow - time.Minute)
+	tracer := createTracer()
+	_, span := tracer.Start(context.Background(), "span", trace.WithTimestamp(endTime.Add(-time.Second)))
+	span.End(trace.WithTimestamp(endTime))
+	// the span watchlist marks ended spans like this before they are exported
+	span.(*dtSpan).metadata.sendState = sendStateSpanEnded
+
+	err := exporter.export(context.Background(), exportTypeForceFlush, makeSpanSet(span))
+	require.EqualError(t, err, "unexpected response code: 503")
+	require.Equal(t, 1, exporter.queue.len())
+
+	chunk, err := exporter.queue.load(exporter.queue.names()[0])
+	require.NoError(t, err)
+	require.WithinDuration(t, endTime, chunk.oldestSpanEnd, time.Millisecond)
+
+	lock.Lock()
+	statusCode = http.StatusOK
+	numRequests = 0
+	lock.Unlock()
+
+	err = exporter.export(context.Background(), exportTypePeriodic, dtSpanSet{})
+	require.NoError(t, err)
+	require.Zero(t, exporter.queue.len())
+	require.Zero(t, numRequests, "the chunk contains a span which is too old to be accepted")
+}
+
+func TestSpanExportDoesNotPersistChunksOnPermanentErrors(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadRequest)
+	})
+	defer testServer.Close()
+
+	config.PersistentQueueDirectory = t.TempDir()
+	config.PersistentQueueMaxSizeMb = 1
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	tracer := createTracer()
+	_, span := tracer.Start(context.Background(), "span")
+	span.End()
+
+	err := exporter.export(context.Background(), exportTypePeriodic, makeSpanSet(span))
+	require.Error(t, err)
+	require.Zero(t, exporter.queue.len())
+
+	files, err := ioutil.ReadDir(config.PersistentQueueDirectory)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
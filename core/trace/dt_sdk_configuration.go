@@ -0,0 +1,98 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/semconv"
+)
+
+// sdkOptionsFromConfiguration returns the options of the wrapped SDK TracerProvider given by the configuration.
+// They are applied before the options given by the caller, which take precedence.
+func sdkOptionsFromConfiguration(config *configuration.DtConfiguration) []sdktrace.TracerProviderOption {
+	var opts []sdktrace.TracerProviderOption
+	if sampler := newSamplerFromConfiguration(config); sampler != nil {
+		opts = append(opts, sdktrace.WithSampler(sampler))
+	}
+
+	// limits which are not configured keep the SDK defaults
+	limits := sdktrace.NewSpanLimits()
+	configuredLimits := []struct {
+		value int
+		limit *int
+	}{
+		{config.SpanAttributeValueLengthLimit, &limits.AttributeValueLengthLimit},
+		{config.SpanAttributeCountLimit, &limits.AttributeCountLimit},
+		{config.SpanEventCountLimit, &limits.EventCountLimit},
+		{config.SpanAttributePerEventCountLimit, &limits.AttributePerEventCountLimit},
+		{config.SpanLinkCountLimit, &limits.LinkCountLimit},
+		{config.SpanAttributePerLinkCountLimit, &limits.AttributePerLinkCountLimit},
+	}
+	limitsConfigured := false
+	for _, configured := range configuredLimits {
+		if configured.value != 0 {
+			*configured.limit = configured.value
+			limitsConfigured = true
+		}
+	}
+	if limitsConfigured {
+		opts = append(opts, sdktrace.WithRawSpanLimits(limits))
+	}
+
+	return opts
+}
+
+// newSamplerFromConfiguration returns the sampler given by TracesSampler, nil if the SDK default is used.
+func newSamplerFromConfiguration(config *configuration.DtConfiguration) sdktrace.Sampler {
+	switch config.TracesSampler {
+	case configuration.TracesSampler_AlwaysOn:
+		return sdktrace.AlwaysSample()
+	case configuration.TracesSampler_AlwaysOff:
+		return sdktrace.NeverSample()
+	case configuration.TracesSampler_TraceIdRatio:
+		return sdktrace.TraceIDRatioBased(config.TraceIdRatio())
+	case configuration.TracesSampler_ParentBasedAlwaysOn:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	case configuration.TracesSampler_ParentBasedAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample())
+	case configuration.TracesSampler_ParentBasedTraceIdRatio:
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.TraceIdRatio()))
+	default:
+		return nil
+	}
+}
+
+// newResourceFromConfiguration returns the resource given by ServiceName and ResourceAttributes, nil if neither is
+// configured.
+func newResourceFromConfiguration(config *configuration.DtConfiguration) *resource.Resource {
+	var attrs []attribute.KeyValue
+	for key, value := range config.ResourceAttributeValues() {
+		if key != semconv.ServiceName || config.ServiceName == "" {
+			attrs = append(attrs, attribute.String(key, value))
+		}
+	}
+	if config.ServiceName != "" {
+		attrs = append(attrs, attribute.String(semconv.ServiceName, config.ServiceName))
+	}
+
+	if len(attrs) == 0 {
+		return nil
+	}
+	return resource.NewSchemaless(attrs...)
+}
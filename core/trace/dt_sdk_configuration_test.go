@@ -0,0 +1,160 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+)
+
+// buildTestConfig returns a copy of the test configuration changed by the given function.
+func buildTestConfig(t *testing.T, change func(values *configuration.DtConfiguration)) *configuration.DtConfiguration {
+	values := *testConfig
+	change(&values)
+	config, err := configuration.BuildConfiguration(values)
+	require.NoError(t, err)
+	return config
+}
+
+func TestTracerProviderUsesConfiguredSampler(t *testing.T) {
+	config := buildTestConfig(t, func(values *configuration.DtConfiguration) {
+		values.TracesSampler = configuration.TracesSampler_AlwaysOff
+	})
+
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(config))
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	require.False(t, span.SpanContext().IsSampled())
+	span.End()
+
+	tp2, err := NewTracerProviderWithOptions(WithDtConfiguration(config),
+		WithTracerProviderOptions(sdktrace.WithSampler(sdktrace.AlwaysSample())))
+	require.NoError(t, err)
+	defer tp2.Shutdown(context.Background())
+
+	_, span = tp2.Tracer("test").Start(context.Background(), "span")
+	require.True(t, span.SpanContext().IsSampled(), "options of the caller take precedence")
+	span.End()
+}
+
+func TestNewSamplerFromConfiguration(t *testing.T) {
+	tests := []struct {
+		sampler     configuration.TracesSampler
+		description string
+	}{
+		{configuration.TracesSampler_AlwaysOn, "AlwaysOnSampler"},
+		{configuration.TracesSampler_AlwaysOff, "AlwaysOffSampler"},
+		{configuration.TracesSampler_TraceIdRatio, "TraceIDRatioBased{0.5}"},
+		{configuration.TracesSampler_ParentBasedAlwaysOn, "ParentBased{root:AlwaysOnSampler"},
+		{configuration.TracesSampler_ParentBasedAlwaysOff, "ParentBased{root:AlwaysOffSampler"},
+		{configuration.TracesSampler_ParentBasedTraceIdRatio, "ParentBased{root:TraceIDRatioBased{0.5}"},
+	}
+
+	for _, test := range tests {
+		config := &configuration.DtConfiguration{TracesSampler: test.sampler, TracesSamplerArg: "0.5"}
+		require.Contains(t, newSamplerFromConfiguration(config).Description(), test.description)
+	}
+
+	require.Nil(t, newSamplerFromConfiguration(&configuration.DtConfiguration{}), "the SDK default is kept")
+}
+
+func TestSdkOptionsFromConfiguration_SpanLimits(t *testing.T) {
+	require.Empty(t, sdkOptionsFromConfiguration(&configuration.DtConfiguration{}))
+
+	recorder := tracetest.NewSpanRecorder()
+	config := &configuration.DtConfiguration{SpanAttributeCountLimit: 1, SpanEventCountLimit: -1}
+	tp := sdktrace.NewTracerProvider(append(sdkOptionsFromConfiguration(config), sdktrace.WithSpanProcessor(recorder))...)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	span.SetAttributes(attribute.String("a", "1"), attribute.String("b", "2"))
+	for i := 0; i < sdktrace.DefaultEventCountLimit+1; i++ {
+		span.AddEvent("event")
+	}
+	span.End()
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 1)
+	require.Len(t, ended[0].Attributes(), 1)
+	require.Equal(t, 1, ended[0].DroppedAttributes())
+	require.Len(t, ended[0].Events(), sdktrace.DefaultEventCountLimit+1, "a negative limit means unlimited")
+}
+
+func TestNewResourceFromConfiguration(t *testing.T) {
+	require.Nil(t, newResourceFromConfiguration(&configuration.DtConfiguration{}))
+
+	res := newResourceFromConfiguration(&configuration.DtConfiguration{
+		ServiceName:        "service",
+		ResourceAttributes: "service.name=other,team=tracing",
+	})
+	require.Equal(t, []attribute.KeyValue{
+		attribute.String("service.name", "service"),
+		attribute.String("team", "tracing"),
+	}, res.Attributes(), "the service name takes precedence")
+}
+
+func TestGetResourceForSpanExport_ConfigServiceNameTakesPrecedence(t *testing.T) {
+	spanResource := sdkresource.NewSchemaless(
+		attribute.String("service.name", "unknown_service:test"),
+		attribute.String("key", "value"),
+	)
+	configResource := newResourceFromConfiguration(&configuration.DtConfiguration{
+		ServiceName:        "service",
+		ResourceAttributes: "team=tracing,owner=a%20b",
+	})
+
+	exportResource, err := getResourceForSpanExport(spanResource, configResource)
+	require.NoError(t, err)
+
+	values := map[attribute.Key]string{}
+	for _, attr := range exportResource.Attributes() {
+		values[attr.Key] = attr.Value.AsString()
+	}
+	require.Equal(t, "service", values["service.name"])
+	require.Equal(t, "tracing", values["team"])
+	require.Equal(t, "a b", values["owner"], "resource attribute values are percent-decoded")
+	require.Equal(t, "value", values["key"])
+}
+
+func TestGetResourceForSpanExport_SpanResourceTakesPrecedenceOverResourceAttributes(t *testing.T) {
+	// e.g. a resource which has been set by the application with sdktrace.WithResource
+	spanResource := sdkresource.NewSchemaless(
+		attribute.String("service.name", "app"),
+		attribute.String("team", "explicit"),
+	)
+	configResource := newResourceFromConfiguration(&configuration.DtConfiguration{
+		ResourceAttributes: "team=tracing,region=eu",
+	})
+
+	exportResource, err := getResourceForSpanExport(spanResource, configResource)
+	require.NoError(t, err)
+
+	values := map[attribute.Key]string{}
+	for _, attr := range exportResource.Attributes() {
+		values[attr.Key] = attr.Value.AsString()
+	}
+	require.Equal(t, "app", values["service.name"])
+	require.Equal(t, "explicit", values["team"])
+	require.Equal(t, "eu", values["region"])
+}
@@ -0,0 +1,141 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/logger"
+)
+
+// DtSpanProcessor is an sdktrace.SpanProcessor which exports spans to Dynatrace Cluster. It allows adding Dynatrace
+// export to an SDK TracerProvider which is built by the application, e.g. together with other span processors:
+//
+//	processor, err := dtTrace.NewSpanProcessor()
+//	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+//
+// The DtTextMapPropagator has to be used to propagate the Dynatrace trace context of the spans.
+type DtSpanProcessor struct {
+	processor *dtSpanProcessor
+	logger    *logger.ComponentLogger
+	config    *configuration.DtConfiguration
+}
+
+var _ sdktrace.SpanProcessor = (*DtSpanProcessor)(nil)
+
+// NewSpanProcessor creates a DtSpanProcessor. Unless WithDtConfiguration is given, the configuration provided
+// by configuration.GlobalConfigurationProvider is used. WithTracerProviderOptions is ignored.
+// If the agent is not active, the returned processor does nothing.
+func NewSpanProcessor(opts ...Option) (*DtSpanProcessor, error) {
+	o := newOptions(opts)
+	config, err := o.resolveConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &DtSpanProcessor{
+		logger: logger.NewComponentLogger("SpanProcessor"),
+		config: config,
+	}
+	if config.AgentDisabled {
+		sp.logger.Info("Agent is not active, spans are not exported")
+		return sp, nil
+	}
+
+	sp.processor = newDtSpanProcessor(config, o.transport)
+	spanRegistries.register(sp.processor.spanRegistry)
+
+	sp.logger.Debug("DtSpanProcessor created")
+	return sp, nil
+}
+
+// OnStart creates the Dynatrace metadata of the span and starts tracking it for export.
+func (sp *DtSpanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if sp.processor == nil {
+		return
+	}
+
+	span := &dtSpan{
+		Span: s,
+		metadata: createSpanMetadata(parent, s, sp.config, sp.processor.spanRegistry),
+	}
+
+	// child spans and the propagator look up the metadata by the span context, the span is only registered if the
+	// processor tracks it, since nothing removes it from the registry otherwise
+	if sp.processor.onStart(parent, span) {
+		sp.processor.spanRegistry.add(span)
+	}
+}
+
+// OnEnd marks the span as ended, it is exported with the next send operation.
+func (sp *DtSpanProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if sp.processor == nil {
+		return
+	}
+
+	span := sp.processor.spanRegistry.get(s.SpanContext())
+	if span == nil {
+		sp.logger.Debugf("Span %s has not been started by DtSpanProcessor", s.Name())
+		return
+	}
+
+	sp.processor.spanRegistry.remove(s.SpanContext())
+	sp.processor.onEnd(span)
+}
+
+// ForceFlush exports spans that have not been exported yet to Dynatrace Cluster.
+func (sp *DtSpanProcessor) ForceFlush(ctx context.Context) error {
+	if sp.processor == nil {
+		return nil
+	}
+
+	return measureExecutionTime(ctx, sp.processor.forceFlush, "ForceFlush", sp.config, sp.logger)
+}
+
+// Shutdown stops exporting goroutine and exports all remaining spans to Dynatrace Cluster.
+// It executes only once, subsequent call does nothing.
+func (sp *DtSpanProcessor) Shutdown(ctx context.Context) error {
+	if sp.processor == nil {
+		return nil
+	}
+
+	err := measureExecutionTime(ctx, sp.processor.shutdown, "Shutdown", sp.config, sp.logger)
+	// spans which have not been ended are no longer tracked
+	spanRegistries.unregister(sp.processor.spanRegistry)
+	sp.processor.spanRegistry.clear()
+	return err
+}
+
+// SetAuthToken replaces the auth token which is used to send spans to Dynatrace Cluster, see
+// DtTracerProvider.SetAuthToken.
+func (sp *DtSpanProcessor) SetAuthToken(authToken string) {
+	if sp.processor == nil {
+		return
+	}
+
+	sp.processor.setAuthToken(authToken)
+}
+
+// Stats returns a snapshot of the self-monitoring statistics, see DtTracerProvider.Stats.
+func (sp *DtSpanProcessor) Stats() Stats {
+	if sp.processor == nil {
+		return Stats{}
+	}
+
+	return sp.processor.stats.snapshot()
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+
+	protoCollectorTraces "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/collector/traces/v1"
+)
+
+func TestSpanProcessorWithSdkTracerProvider(t *testing.T) {
+	var lock sync.Mutex
+	var exports []*protoCollectorTraces.SpanExport
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		export := &protoCollectorTraces.SpanExport{}
+		require.NoError(t, proto.Unmarshal(body, export))
+
+		lock.Lock()
+		defer lock.Unlock()
+		exports = append(exports, export)
+	})
+	defer testServer.Close()
+
+	processor, err := NewSpanProcessor(WithDtConfiguration(config))
+	require.NoError(t, err)
+
+	// Dynatrace export is used together with other span processors
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor), sdktrace.WithSpanProcessor(recorder))
+	defer tp.Shutdown(context.Background())
+
+	tracer := tp.Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+
+	parentSpan := processor.processor.spanRegistry.get(parent.SpanContext())
+	childSpan := processor.processor.spanRegistry.get(child.SpanContext())
+	require.NotNil(t, parentSpan)
+	require.NotNil(t, childSpan)
+	require.Same(t, parentSpan.metadata.getFw4Tag(), childSpan.metadata.getFw4Tag(),
+		"the child span must inherit the FW4 tag of its parent")
+	require.Equal(t, parent.SpanContext().SpanID(), childSpan.metadata.tenantParentSpanId)
+
+	child.End()
+	parent.End()
+	require.Nil(t, processor.processor.spanRegistry.get(parent.SpanContext()), "ended spans must be removed from the registry")
+	require.Nil(t, processor.processor.spanRegistry.get(child.SpanContext()))
+
+	require.NoError(t, tp.ForceFlush(context.Background()))
+	require.Len(t, recorder.Ended(), 2)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, exports, 1)
+	require.Len(t, exports[0].Spans, 2)
+	require.EqualValues(t, 2, processor.Stats().SpansEnded)
+}
+
+func TestTwoSpanProcessorsWithSdkTracerProvider(t *testing.T) {
+	newProcessor := func(tenant string) (*DtSpanProcessor, *int32) {
+		numSpans := new(int32)
+		testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+
+			export := &protoCollectorTraces.SpanExport{}
+			require.NoError(t, proto.Unmarshal(body, export))
+			atomic.AddInt32(numSpans, int32(len(export.Spans)))
+		})
+		t.Cleanup(testServer.Close)
+		config.Tenant = tenant
+
+		processor, err := NewSpanProcessor(WithDtConfiguration(config))
+		require.NoError(t, err)
+		return processor, numSpans
+	}
+
+	// e.g. spans are exported to two tenants
+	processor1, numSpans1 := newProcessor("tenant1")
+	processor2, numSpans2 := newProcessor("tenant2")
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor1), sdktrace.WithSpanProcessor(processor2))
+
+	tracer := tp.Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	_, open := tracer.Start(context.Background(), "open")
+
+	for _, processor := range []*DtSpanProcessor{processor1, processor2} {
+		parentSpan := processor.processor.spanRegistry.get(parent.SpanContext())
+		childSpan := processor.processor.spanRegistry.get(child.SpanContext())
+		require.NotNil(t, parentSpan)
+		require.NotNil(t, childSpan)
+		require.Same(t, parentSpan.metadata.getFw4Tag(), childSpan.metadata.getFw4Tag(),
+			"the child span must inherit the FW4 tag of its parent tracked by the same processor")
+	}
+	require.NotSame(t, processor1.processor.spanRegistry.get(parent.SpanContext()),
+		processor2.processor.spanRegistry.get(parent.SpanContext()))
+
+	child.End()
+	parent.End()
+	for _, processor := range []*DtSpanProcessor{processor1, processor2} {
+		require.Nil(t, processor.processor.spanRegistry.get(parent.SpanContext()))
+		require.Nil(t, processor.processor.spanRegistry.get(child.SpanContext()))
+		require.EqualValues(t, 2, processor.Stats().SpansEnded, "each processor must see its spans end")
+	}
+
+	require.NoError(t, tp.ForceFlush(context.Background()))
+	require.EqualValues(t, 2, atomic.LoadInt32(numSpans1))
+	require.EqualValues(t, 2, atomic.LoadInt32(numSpans2))
+
+	// spans which have not been ended are no longer registered once the processors are shut down
+	require.NotNil(t, spanRegistries.get(open.SpanContext()))
+	require.NoError(t, tp.Shutdown(context.Background()))
+	require.Zero(t, processor1.processor.spanRegistry.len())
+	require.Zero(t, processor2.processor.spanRegistry.len())
+	require.Nil(t, spanRegistries.get(open.SpanContext()))
+}
+
+func TestSpanProcessorIgnoresUnknownSpans(t *testing.T) {
+	processor, err := NewSpanProcessor()
+	require.NoError(t, err)
+	defer processor.Shutdown(context.Background())
+
+	// a span started by another provider is not tracked
+	_, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	span.End()
+	processor.OnEnd(span.(sdktrace.ReadOnlySpan))
+
+	require.Zero(t, processor.processor.spanWatchlist.len())
+	require.Zero(t, processor.Stats().SpansEnded)
+}
+
+func TestSpanProcessorDoesNotRegisterRejectedSpans(t *testing.T) {
+	processor, err := NewSpanProcessor()
+	require.NoError(t, err)
+	defer processor.Shutdown(context.Background())
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+
+	// spans are rejected while exporting is stopped, e.g. because the auth token has been rejected
+	atomic.StoreInt32(&processor.processor.exportingStopped, 1)
+	numRegistered := processor.processor.spanRegistry.len()
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+
+	require.Nil(t, processor.processor.spanRegistry.get(span.SpanContext()))
+	require.Equal(t, numRegistered, processor.processor.spanRegistry.len())
+	require.Zero(t, processor.processor.spanWatchlist.len())
+	span.End()
+}
+
+func TestPropagatorInjectSpanOfSpanProcessor(t *testing.T) {
+	processor, err := NewSpanProcessor()
+	require.NoError(t, err)
+	defer processor.Shutdown(context.Background())
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	ctx, span := tp.Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	p, err := NewTextMapPropagator()
+	require.NoError(t, err)
+
+	c := propagation.HeaderCarrier{}
+	p.Inject(ctx, c)
+
+	require.NotEmpty(t, c.Get(xDtHeader))
+	traceState, err := trace.ParseTraceState(c.Get(tracestateHeader))
+	require.NoError(t, err)
+	require.NotEmpty(t, traceState.Get(processor.processor.spanRegistry.get(span.SpanContext()).metadata.getFw4Tag().TraceStateKey()),
+		"tracestate must contain the FW4 tag")
+}
+
+func TestSpanRegistry(t *testing.T) {
+	r := newDtSpanRegistry()
+	_, sdkSpan := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	span := &dtSpan{Span: sdkSpan}
+
+	r.add(span)
+	require.Same(t, span, r.get(sdkSpan.SpanContext()))
+	require.Nil(t, r.get(sdkSpan.SpanContext().WithRemote(true)), "remote span contexts are never registered")
+
+	r.remove(sdkSpan.SpanContext())
+	require.Nil(t, r.get(sdkSpan.SpanContext()))
+	require.Zero(t, r.len())
+}
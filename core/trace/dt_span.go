@@ -58,7 +58,10 @@ func (s *dtSpan) SpanContext() trace.SpanContext {
 
 		ts, err := spanCtx.TraceState().Insert(tag.TraceStateKey(), tag.ToTracestateEntryValueWithoutTraceId())
 		if err != nil {
-			s.tracer.provider.logger.Infof("Can not add FW4 Tag to tracestate: %s", err)
+			// spans created by an SDK TracerProvider with a DtSpanProcessor have no tracer
+			if s.tracer != nil {
+				s.tracer.provider.logger.Infof("Can not add FW4 Tag to tracestate: %s", err)
+			}
 			return spanCtx
 		}
 
@@ -69,11 +72,15 @@ func (s *dtSpan) SpanContext() trace.SpanContext {
 }
 
 // dtSpanFromContext return Dynatrace span instance from given context, nil if Dynatrace span is not found.
+// The span in the context is either a Dynatrace span itself or an SDK span started with a DtSpanProcessor, the
+// latter is taken from the first DtSpanProcessor which tracks it.
 func dtSpanFromContext(ctx context.Context) *dtSpan {
 	if s := trace.SpanFromContext(ctx); s != nil {
 		if span, ok := s.(*dtSpan); ok {
 			return span
 		}
+
+		return spanRegistries.get(s.SpanContext())
 	}
 
 	return nil
@@ -86,7 +93,8 @@ func (s *dtSpan) prepareSend(sendTime int64) prepareResult {
 	readOnlySpan, _ := s.readOnlySpan()
 	sdkSpanEnded := readOnlySpan.EndTime().IsZero()
 	shouldSend := s.metadata.evaluateSendState(sendTime, !sdkSpanEnded)
-	if shouldSend == prepareResultSend {
+	// a dropped span is sent one last time, so that Dynatrace Cluster can close it instead of waiting for keep alives
+	if shouldSend == prepareResultSend || shouldSend == prepareResultDrop {
 		s.metadata.lastSentMs = sendTime
 		s.metadata.seqNumber++
 	}
@@ -94,6 +102,13 @@ func (s *dtSpan) prepareSend(sendTime int64) prepareResult {
 	return shouldSend
 }
 
+// prepareDrop updates the metadata of a span which is no longer tracked, so that it is sent as dropped one last time
+func (s *dtSpan) prepareDrop(sendTime int64) {
+	s.metadata.sendState = sendStateDrop
+	s.metadata.lastSentMs = sendTime
+	s.metadata.seqNumber++
+}
+
 func attributesFromSpan(span trace.Span) []attribute.KeyValue {
 	if dtSpan, ok := span.(*dtSpan); ok {
 		span = dtSpan.Span
@@ -20,31 +20,39 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/fw4"
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/semconv"
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/trace/internal/util"
 )
 
+// createSpanMetadata creates the metadata of a span. The metadata of a parent span which is not a dtSpan is looked up
+// in the given registry of the span's processor, or in the registries of all DtSpanProcessors if it is nil.
 func createSpanMetadata(
 	parentCtx context.Context,
 	span trace.Span,
-	clusterId,
-	tenantId int32,
-	spanProcessingIntervalMs int64,
+	config *configuration.DtConfiguration,
+	registry *dtSpanRegistry,
 ) *dtSpanMetadata {
-	if parentMetadata := dtSpanMetadataFromContext(parentCtx); parentMetadata != nil {
+	if parentMetadata := dtSpanMetadataFromContext(parentCtx, registry); parentMetadata != nil {
 		parentMetadata.markPropagatedNow()
 	}
 
-	metadata := newDtSpanMetadata(spanProcessingIntervalMs)
+	metadata := newDtSpanMetadata(int64(config.SpanProcessingIntervalMs))
+	if config.KeepAliveIntervalMs > 0 {
+		metadata.options.keepAliveIntervalMs = int64(config.KeepAliveIntervalMs)
+	}
+	if config.OpenSpanTimeoutMs > 0 {
+		metadata.options.openSpanTimeoutMs = int64(config.OpenSpanTimeoutMs)
+	}
 	metadata.tenantParentSpanId = tenantParentSpanIdFromContext(parentCtx)
-	metadata.propagatedResourceAttributes = getPropagatedResourceAttributes(parentCtx)
+	metadata.propagatedResourceAttributes = getPropagatedResourceAttributes(parentCtx, registry)
 
-	fw4Tag := fw4TagFromContextOrMetadata(parentCtx)
+	fw4Tag := fw4TagFromContextOrMetadata(parentCtx, registry)
 
 	// No FW4Tag was found for the parent span, so create one.
 	if fw4Tag == nil {
-		fw4Tag = fw4.NewFw4Tag(clusterId, tenantId, span.SpanContext())
+		fw4Tag = fw4.NewFw4Tag(config.ClusterId, config.TenantId(), span.SpanContext())
 		fw4Tag.ServerID = util.GetServerIdFromContext(parentCtx)
 	}
 
@@ -65,12 +73,12 @@ func tenantParentSpanIdFromContext(ctx context.Context) trace.SpanID {
 	return trace.SpanID{}
 }
 
-func fw4TagFromContextOrMetadata(ctx context.Context) *fw4.Fw4Tag {
+func fw4TagFromContextOrMetadata(ctx context.Context, registry *dtSpanRegistry) *fw4.Fw4Tag {
 	parentSpan := trace.SpanFromContext(ctx)
 	if parentSpan.SpanContext().IsRemote() {
 		// For remote parent spans, the FW4 tag is stored in the context, and no metadata will exist.
 		return fw4.Fw4TagFromContext(ctx)
-	} else if parentSpanMetaData := dtSpanMetadataFromSpan(parentSpan); parentSpanMetaData != nil {
+	} else if parentSpanMetaData := dtSpanMetadataFromSpan(parentSpan, registry); parentSpanMetaData != nil {
 		return parentSpanMetaData.getFw4Tag()
 	}
 	return nil
@@ -95,9 +103,9 @@ var attributeKeysToPropagate = map[attribute.Key]struct{}{
 	attribute.Key(semconv.GcpResourceType):             emptyMapValue,
 }
 
-func getPropagatedResourceAttributes(ctx context.Context) propagatedResourceAttributes {
+func getPropagatedResourceAttributes(ctx context.Context, registry *dtSpanRegistry) propagatedResourceAttributes {
 	parentSpan := trace.SpanFromContext(ctx)
-	if parentMetadata := dtSpanMetadataFromSpan(parentSpan); parentMetadata != nil {
+	if parentMetadata := dtSpanMetadataFromSpan(parentSpan, registry); parentMetadata != nil {
 		if parentMetadata.propagatedResourceAttributes != nil {
 			// when available just take it from the parent, to minimize span attribute access
 			// which deduplicates potential attributes.
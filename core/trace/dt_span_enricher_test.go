@@ -40,8 +40,8 @@ func TestSpanHasMetadata(t *testing.T) {
 	assert.NotNil(t, metadata)
 	assert.NotNil(t, metadata.fw4Tag)
 	assert.Nil(t, metadata.propagatedResourceAttributes)
-	assert.Equal(t, tracer.(*dtTracer).config.ClusterId, metadata.fw4Tag.ClusterID)
-	assert.Equal(t, tracer.(*dtTracer).config.TenantId(), metadata.fw4Tag.TenantID)
+	assert.Equal(t, tracer.(*dtTracer).provider.config.ClusterId, metadata.fw4Tag.ClusterID)
+	assert.Equal(t, tracer.(*dtTracer).provider.config.TenantId(), metadata.fw4Tag.TenantID)
 }
 
 func TestCreateSpanMetadata_WithParentSpan(t *testing.T) {
@@ -56,8 +56,8 @@ func TestCreateSpanMetadata_WithParentSpan(t *testing.T) {
 	childMetadata := childDtSpan.metadata
 
 	assert.Equal(t, parentSpan.SpanContext().SpanID(), childMetadata.tenantParentSpanId)
-	assert.Equal(t, childMetadata.fw4Tag.ClusterID, tracer.(*dtTracer).config.ClusterId)
-	assert.Equal(t, childMetadata.fw4Tag.TenantID, tracer.(*dtTracer).config.TenantId())
+	assert.Equal(t, childMetadata.fw4Tag.ClusterID, tracer.(*dtTracer).provider.config.ClusterId)
+	assert.Equal(t, childMetadata.fw4Tag.TenantID, tracer.(*dtTracer).provider.config.TenantId())
 	assert.Same(t, childMetadata.fw4Tag, parentMetadata.fw4Tag, "Pointer to FW4Tag of child should be equal to parent")
 	assert.True(t, childMetadata.lastPropagationTime.IsZero())
 	assert.False(t, parentMetadata.lastPropagationTime.IsZero())
@@ -19,10 +19,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
@@ -39,113 +42,447 @@ const (
 
 const cSpansPath = "/odin/v1/spans"
 
+// headers which must never be logged
+var secretHeaders = []string{"Authorization", "Proxy-Authorization"}
+
 var errNotAuthorizedRequest = errors.New("Span Exporter is not authorized to send spans")
 
+// errPayloadTooLarge is returned if the server rejects a chunk as too large, the chunk is split and sent again
+var errPayloadTooLarge = errors.New("chunk exceeds the request size limit of the server")
+
 type dtSpanExporter interface {
 	export(ctx context.Context, t exportType, spans dtSpanSet) error
 }
 
+// dtConnectedSpanExporter is implemented by span exporters which send spans to Dynatrace Cluster and whose
+// connection settings can be changed at runtime.
+type dtConnectedSpanExporter interface {
+	getConnection() *dtConnection
+	getCircuitBreaker() *dtCircuitBreaker
+	stop()
+}
+
 type dtSpanExporterImpl struct {
-	logger     *logger.ComponentLogger
-	config     *configuration.DtConfiguration
-	dialer     *net.Dialer
-	client     *http.Client
-	serializer *dtSpanSerializer
-	disabled   bool
+	logger      *logger.ComponentLogger
+	config      *configuration.DtConfiguration
+	client      *http.Client
+	serializer  *dtSpanSerializer
+	stats       *dtStats
+	retryPolicy *retryPolicy
+	queue       *dtPersistentQueue
+	timeSync    *dtTimeSync
+	connection  *dtConnection
+	breaker     *dtCircuitBreaker
+	endpoints   *dtEndpoints
+	// concurrency is the maximum number of export requests in flight
+	concurrency int
 }
 
-func newDtSpanExporter(config *configuration.DtConfiguration) dtSpanExporter {
-	d := &net.Dialer{}
+// newDtSpanExporter creates a span exporter which sends spans to Dynatrace Cluster. If the transport is nil, it is
+// created from the configuration.
+func newDtSpanExporter(config *configuration.DtConfiguration, stats *dtStats, transport http.RoundTripper) dtSpanExporter {
+	client, clientErr := newDtHttpClient(config, transport)
+	connection := newDtConnection(config)
+	chunkSizeKb := config.ExportChunkSizeKb
+	if chunkSizeKb <= 0 {
+		chunkSizeKb = configuration.DefaultExportChunkSizeKb
+	}
+	serializer := newSpanSerializer(config.Tenant, config.AgentId, config.QualifiedTenantId(),
+		newResourceFromConfiguration(config), stats, chunkSizeKb*1024)
+	concurrency := config.ExportConcurrency
+	if concurrency <= 0 {
+		concurrency = configuration.DefaultExportConcurrency
+	}
+	endpoints := newDtEndpoints(config, stats, newDtEndpointProbe(config, connection, client))
 	exporter := &dtSpanExporterImpl{
-		logger: logger.NewComponentLogger("SpanExporter"),
-		config: config,
-		dialer: d,
-		client: &http.Client{
-			Transport: &http.Transport{
-				DialContext: d.DialContext,
-			},
-		},
-		serializer: newSpanSerializer(config.Tenant, config.AgentId, config.QualifiedTenantId()),
-		disabled:   false,
+		logger:      logger.NewComponentLogger("SpanExporter"),
+		config:      config,
+		client:      client,
+		timeSync:    newDtTimeSync(config, connection, endpoints, client),
+		serializer:  serializer,
+		stats:       stats,
+		retryPolicy: newRetryPolicy(),
+		connection:  connection,
+		breaker:     newDtCircuitBreaker(config, stats),
+		endpoints:   endpoints,
+		concurrency: concurrency,
+	}
+
+	if clientErr != nil {
+		exporter.logger.Errorf("Can not configure HTTP transport, requests are likely to fail: %s", clientErr)
+	}
+
+	if config.PersistentQueueDirectory != "" {
+		queue, err := newDtPersistentQueue(config.PersistentQueueDirectory, int64(config.PersistentQueueMaxSizeMb)*1024*1024)
+		if err != nil {
+			exporter.logger.Warnf("Can not open persistent queue, chunks that could not be sent will be dropped: %s", err)
+		} else {
+			exporter.queue = queue
+		}
 	}
 
 	return exporter
 }
 
+func (e *dtSpanExporterImpl) getConnection() *dtConnection {
+	return e.connection
+}
+
+func (e *dtSpanExporterImpl) getCircuitBreaker() *dtCircuitBreaker {
+	return e.breaker
+}
+
+// stop ends the time synchronization and the probes of failed endpoints, which run in the background.
+func (e *dtSpanExporterImpl) stop() {
+	e.timeSync.stop()
+	e.endpoints.stop()
+}
+
 func (e *dtSpanExporterImpl) export(ctx context.Context, t exportType, spans dtSpanSet) error {
-	if e.disabled {
-		e.logger.Debug("Skip exporting, Span Exporter is disabled")
+	if e.connection.isRejected() {
+		e.logger.Debug("Skip exporting, Span Exporter is disabled until the auth token is changed")
 		return nil
 	}
 
+	// Chunks that could not be sent previously are sent before new ones. If this fails, the endpoint is most likely
+	// unreachable, so new chunks are stored in the persistent queue right away.
+	var exportErr error
+	if t == exportTypePeriodic && e.queue != nil {
+		exportErr = e.replayPersistedChunks(ctx, t)
+	}
+
 	if len(spans) == 0 {
 		e.logger.Debug("Skip exporting, no spans to export")
-		return nil
+		return exportErr
 	}
 
 	e.logger.Debugf("Serialize %d spans to export", len(spans))
 
-	// Asynchronously serialize spans and export each chunk (every SpanExport message) as soon as it is done.
-	// In most cases, only a single SpanExport should be received on the channel unless we are dealing with large spans
-	// or resources.
-	exportChannel := make(chan exportData)
-	errorChannel := make(chan error)
-	serializeCtx, cancel := context.WithCancel(ctx)
+	if _, hasDeadline := ctx.Deadline(); t == exportTypeForceFlush && !hasDeadline {
+		// retries of a flush operation must not exceed the flush operation timeout
+		var cancelFlush context.CancelFunc
+		ctx, cancelFlush = context.WithTimeout(ctx, flushOrShutdownTimeout(ctx, e.config))
+		defer cancelFlush()
+	}
+
+	// The time synchronization runs in the background, so that exports never wait for it, and the most recently
+	// measured offset is sent. It is started with the first spans, so that no requests are sent while there are none.
+	e.timeSync.start()
+	exportMetaInfo := e.timeSync.exportMetaInfo()
+
+	// Spans are serialized asynchronously and each chunk (every SpanExport message) is uploaded as soon as it is done.
+	// In most cases, there is only a single chunk unless we are dealing with large spans or resources. The buffer lets
+	// serialization run ahead of the uploads by as many chunks as may be in flight.
+	chunks := make(chan exportData, e.concurrency)
+	serializeErr := make(chan error, 1)
+	serializeCtx, stopSerializing := context.WithCancel(ctx)
+	defer stopSerializing()
 	go func() {
-		defer cancel()
-		e.serializer.serializeSpans(spans, exportChannel, errorChannel)
+		defer close(chunks)
+		serializeErr <- e.serializer.serializeSpans(serializeCtx, spans, exportMetaInfo, chunks)
 	}()
 
-	for {
-		select {
-		case export := <-exportChannel:
-			err := e.doExportRequest(ctx, t, export)
-			if err != nil {
-				return err
+	// the serializer has returned once all chunks have been consumed
+	if err := e.uploadChunks(ctx, t, chunks, exportErr, stopSerializing); err != nil {
+		return err
+	}
+	return <-serializeErr
+}
+
+// uploadChunks sends the chunks with up to e.concurrency requests in flight until the channel is closed. Once a chunk
+// could not be sent, the endpoint is most likely unreachable, so subsequent chunks are stored in the persistent queue
+// right away. If a chunk can neither be sent nor stored, the remaining chunks are discarded and stop is called.
+// The errors are ordered like the chunks, the first one is returned. prevErr precedes the errors of all chunks,
+// it is the error of the replayed chunks, which are sent before new ones.
+func (e *dtSpanExporterImpl) uploadChunks(
+	ctx context.Context,
+	t exportType,
+	chunks <-chan exportData,
+	prevErr error,
+	stop context.CancelFunc,
+) error {
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		errs    = []error{prevErr}
+		sendErr = prevErr
+		stopped bool
+	)
+	inFlight := make(chan struct{}, e.concurrency)
+
+	// discardRemaining is called if a chunk can neither be sent nor stored, the caller must hold the lock
+	discardRemaining := func() {
+		if !stopped {
+			stopped = true
+			stop()
+		}
+	}
+
+	for chunk := range chunks {
+		lock.Lock()
+		index := len(errs)
+		errs = append(errs, nil)
+		discard, failedBefore := stopped, sendErr
+		lock.Unlock()
+
+		if discard {
+			continue
+		}
+
+		if failedBefore != nil {
+			if !e.persistChunk(chunk, failedBefore) {
+				lock.Lock()
+				discardRemaining()
+				lock.Unlock()
+			}
+			continue
+		}
+
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func(index int, chunk exportData) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+
+			err := e.doExportRequest(ctx, t, chunk)
+			if err == nil {
+				return
 			}
-		case err := <-errorChannel:
+
+			persisted := e.persistChunk(chunk, err)
+			lock.Lock()
+			defer lock.Unlock()
+			errs[index] = err
+			if sendErr == nil {
+				sendErr = err
+			}
+			if !persisted {
+				discardRemaining()
+			}
+		}(index, chunk)
+	}
+
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
 			return err
-		case <-serializeCtx.Done():
-			return nil
 		}
 	}
+	return nil
 }
 
+// persistChunk stores a chunk that could not be sent in the persistent queue if the failure is temporary.
+// Returns false if the chunk was not stored.
+func (e *dtSpanExporterImpl) persistChunk(export exportData, exportErr error) bool {
+	if e.queue == nil || !isTemporaryExportError(exportErr) {
+		return false
+	}
+
+	if err := e.queue.enqueue(export, oldestSpanEndTime(export, time.Now())); err != nil {
+		e.logger.Warnf("Can not store chunk in persistent queue: %s", err)
+		return false
+	}
+
+	e.logger.Debugf("Stored chunk of %d bytes in persistent queue", len(export))
+	return true
+}
+
+// replayPersistedChunks sends the chunks stored in the persistent queue, oldest first. Chunks are removed from the
+// queue once they have been sent or if they can never be sent. Replaying stops at the first temporary failure.
+func (e *dtSpanExporterImpl) replayPersistedChunks(ctx context.Context, t exportType) error {
+	for _, name := range e.queue.names() {
+		chunk, err := e.queue.load(name)
+		if err != nil {
+			continue
+		}
+
+		if age := time.Since(chunk.oldestSpanEnd); age > cSpanAcceptanceWindow {
+			e.logger.Infof("Discard persisted chunk %s, it is too old to be accepted (%s)", name, age)
+			e.queue.remove(name)
+			continue
+		}
+
+		if err := e.doExportRequest(ctx, t, chunk.data); err == errNotAuthorizedRequest || isTemporaryExportError(err) {
+			e.logger.Infof("Can not send persisted chunk %s: %s", name, err)
+			return err
+		} else if err != nil {
+			e.logger.Warnf("Discard persisted chunk %s, it can not be sent: %s", name, err)
+		} else {
+			e.logger.Debugf("Persisted chunk %s has been sent", name)
+		}
+
+		e.queue.remove(name)
+	}
+
+	return nil
+}
+
+// doExportRequest sends a chunk to Dynatrace Cluster. A chunk which the server rejects as too large is split in half
+// and each half is sent the same way. The result of each chunk is recorded by the circuit breaker.
 func (e *dtSpanExporterImpl) doExportRequest(ctx context.Context, t exportType, spanExport exportData) error {
+	err := e.sendWithRetries(ctx, t, spanExport)
+	e.breaker.recordResult(err)
+	if err != errPayloadTooLarge {
+		return err
+	}
+
+	return e.splitAndResend(ctx, t, spanExport)
+}
+
+// splitAndResend splits a chunk which is too large for the server in half and sends both halves. The chunk size
+// target is lowered, so that later chunks fit. A span which can not be split any further is dropped.
+// The second half is not sent if sending the first one has failed temporarily.
+func (e *dtSpanExporterImpl) splitAndResend(ctx context.Context, t exportType, spanExport exportData) error {
+	first, second, numSpans, err := splitSpanExport(spanExport)
+	if err != nil {
+		return err
+	}
+
+	if numSpans < 2 {
+		e.logger.Warnf("Dropping %d span(s), the chunk of %d bytes exceeds the request size limit of the server",
+			numSpans, len(spanExport))
+		var dropped droppedSpanCounts
+		dropped[spanDropReasonTooBig] = numSpans
+		e.stats.recordDroppedSpans(&dropped)
+		return nil
+	}
+
+	e.stats.recordChunkSplit()
+	if chunkSizeTarget := len(spanExport) / 2; e.serializer.lowerChunkSizeTarget(chunkSizeTarget) {
+		e.logger.Infof("Chunk of %d bytes exceeds the request size limit of the server, chunk size lowered to %d bytes",
+			len(spanExport), chunkSizeTarget)
+	}
+	e.logger.Debugf("Splitting chunk of %d spans into two chunks", numSpans)
+
+	err = e.doExportRequest(ctx, t, first)
+	if err == errNotAuthorizedRequest || isTemporaryExportError(err) {
+		return err
+	}
+
+	if secondErr := e.doExportRequest(ctx, t, second); err == nil {
+		err = secondErr
+	}
+	return err
+}
+
+// sendWithRetries sends a chunk and retries temporary failures according to the retry policy. A chunk which has made
+// requests fail over to another endpoint is sent once more to that endpoint.
+func (e *dtSpanExporterImpl) sendWithRetries(ctx context.Context, t exportType, spanExport exportData) error {
+	if e.config.ExportCompression == configuration.ExportCompression_Gzip {
+		// compress only once, so that retries reuse the compressed body
+		compressed, err := compressGzip(spanExport)
+		if err != nil {
+			return err
+		}
+		e.logger.Debugf("Compressed chunk from %d to %d bytes", len(spanExport), len(compressed))
+		spanExport = compressed
+	}
+
+	resentAfterFailover := false
+	for attempts := 1; ; attempts++ {
+		err := e.sendExportRequest(ctx, t, spanExport)
+
+		var failedErr *endpointFailedError
+		if errors.As(err, &failedErr) {
+			if !resentAfterFailover {
+				resentAfterFailover = true
+				e.logger.Infof("Export request has failed: %s, sending it to another endpoint", failedErr.err)
+				continue
+			}
+			return failedErr.err
+		}
+
+		var retryErr *retryableError
+		if !errors.As(err, &retryErr) {
+			return err
+		}
+
+		delay, ok := e.retryPolicy.nextDelay(ctx, attempts, retryErr.retryAfter)
+		if !ok {
+			e.logger.Warnf("Export request has failed after %d attempt(s): %s", attempts, retryErr.err)
+			return retryErr
+		}
+
+		e.logger.Infof("Export request has failed: %s, retrying in %s", retryErr.err, delay)
+		if err := sleepWithContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// sendExportRequest performs a single export request attempt.
+// Errors that may be resolved by sending the request again are returned as *retryableError.
+func (e *dtSpanExporterImpl) sendExportRequest(ctx context.Context, t exportType, spanExport exportData) error {
 	reqBody := bytes.NewReader(spanExport)
-	req, err := e.newRequest(ctx, reqBody)
+	settings := e.connection.settings()
+	// the settings are only rejected if they have not changed, so the selected endpoint is applied to a copy
+	endpointSettings := settings
+	endpointSettings.baseUrl = e.endpoints.pick(settings.baseUrl)
+	req, err := e.newRequest(ctx, endpointSettings, reqBody)
 	if err != nil {
 		return err
 	}
+	start := time.Now()
 	resp, err := e.performHttpRequest(req, t)
+	latency := time.Since(start)
 	if err != nil {
+		e.stats.recordExportRequest(0, latency)
+		failedOver := ctx.Err() == nil && e.endpoints.recordResult(endpointSettings.baseUrl, true)
+		if ctx.Err() == nil && isTransientNetworkError(err) {
+			return &retryableError{err: err}
+		}
+		if failedOver {
+			return &endpointFailedError{err: err}
+		}
 		return err
 	}
 
 	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	e.stats.recordExportRequest(resp.StatusCode, latency)
+	failedOver := e.endpoints.recordResult(endpointSettings.baseUrl, isEndpointFailureStatusCode(resp.StatusCode))
 
 	if resp.StatusCode == 401 || resp.StatusCode == 403 {
-		// 401/403 is permanent, so avoid further exporting
-		e.disabled = true
+		// 401/403 is permanent until the auth token is changed, so avoid further exporting
+		e.connection.reject(settings)
 		return errNotAuthorizedRequest
+	} else if resp.StatusCode == http.StatusRequestEntityTooLarge {
+		return errPayloadTooLarge
 	} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return errors.New("unexpected response code: " + strconv.Itoa(resp.StatusCode))
+		err = errors.New("unexpected response code: " + strconv.Itoa(resp.StatusCode))
+		if isRetryableStatusCode(resp.StatusCode) {
+			return &retryableError{
+				err:        err,
+				retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			}
+		}
+		if failedOver {
+			return &endpointFailedError{err: err}
+		}
+		return err
 	}
+
+	e.stats.recordChunkExported(len(spanExport))
 	return nil
 }
 
-func (e *dtSpanExporterImpl) newRequest(ctx context.Context, body *bytes.Reader) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", e.config.BaseUrl+cSpansPath, body)
+func (e *dtSpanExporterImpl) newRequest(
+	ctx context.Context,
+	settings dtConnectionSettings,
+	body *bytes.Reader,
+) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", settings.baseUrl+cSpansPath, body)
 	if err != nil {
 		e.logger.Errorf("Can not create HTTP request: %s", err)
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/x-dt-span-export")
-	req.Header.Set("Authorization", "Dynatrace "+e.config.AuthToken)
-	req.Header.Set("User-Agent", fmt.Sprintf("odin-go/%s %#016x %s",
-		version.FullVersion, e.config.AgentId, e.config.Tenant))
-	req.Header.Set("Accept", "*/*; q=0")
+	if e.config.ExportCompression == configuration.ExportCompression_Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	setDtRequestHeaders(req, e.config, settings.authToken)
 	// Setting just the header Idempotency-Key with an empty value ensures that the request is
 	// treated as idempotent but the header is not sent over the wire. See net/http/transport.go
 	// req.GetBody must also be set. It is set automatically by http.NewRequestWithContext since the body is of type *bytes.Reader.
@@ -154,13 +491,17 @@ func (e *dtSpanExporterImpl) newRequest(ctx context.Context, body *bytes.Reader)
 	return req, nil
 }
 
+// setDtRequestHeaders sets the headers which are required by all requests to Dynatrace Cluster.
+func setDtRequestHeaders(req *http.Request, config *configuration.DtConfiguration, authToken string) {
+	req.Header.Set("Authorization", "Dynatrace "+authToken)
+	req.Header.Set("User-Agent", fmt.Sprintf("odin-go/%s %#016x %s",
+		version.FullVersion, config.AgentId, config.Tenant))
+	req.Header.Set("Accept", "*/*; q=0")
+}
+
 func (e *dtSpanExporterImpl) performHttpRequest(req *http.Request, t exportType) (*http.Response, error) {
 	if e.logger.DebugEnabled() {
-		// Authorization token must not be logged
-		reqCopy := req.Clone(context.Background())
-		reqCopy.Header.Del("Authorization")
-
-		dump, err := httputil.DumpRequest(reqCopy, false)
+		dump, err := dumpRequestWithoutSecrets(req)
 		if err != nil {
 			e.logger.Warnf("Can not dump HTTP request: %s", err)
 		} else {
@@ -168,10 +509,15 @@ func (e *dtSpanExporterImpl) performHttpRequest(req *http.Request, t exportType)
 		}
 	}
 
-	e.setTimeouts(t)
+	// the shared client is never modified, since requests of flush operations and periodic exports may be sent
+	// concurrently, so the timeouts are applied to a copy which shares the transport and its connection pool
+	connTimeout, dataTimeout := e.timeouts(t)
+	client := *e.client
+	client.Timeout = connTimeout + dataTimeout
+	req = req.WithContext(context.WithValue(req.Context(), connTimeoutKey{}, connTimeout))
 
 	start := time.Now()
-	resp, err := e.client.Do(req)
+	resp, err := client.Do(req)
 	e.logger.Debugf("HTTP request took %s", time.Since(start))
 
 	if err != nil {
@@ -190,21 +536,43 @@ func (e *dtSpanExporterImpl) performHttpRequest(req *http.Request, t exportType)
 	return resp, err
 }
 
-// setTimeouts updates connection and data timeouts for HTTP client
-func (e *dtSpanExporterImpl) setTimeouts(t exportType) {
-	var conn, data int64
+// dumpRequestWithoutSecrets dumps the headers of a request for debug logging. Headers which contain credentials
+// are removed from the dump.
+func dumpRequestWithoutSecrets(req *http.Request) ([]byte, error) {
+	reqCopy := req.Clone(context.Background())
+	for _, header := range secretHeaders {
+		reqCopy.Header.Del(header)
+	}
+
+	return httputil.DumpRequest(reqCopy, false)
+}
+
+// timeouts returns the connection and data timeouts of a request of the given export type
+func (e *dtSpanExporterImpl) timeouts(t exportType) (time.Duration, time.Duration) {
 	if t == exportTypeForceFlush {
-		conn = configuration.DefaultFlushExportConnTimeoutMs
-		data = configuration.DefaultFlushExportDataTimeoutMs
-	} else {
-		if t != exportTypePeriodic {
-			e.logger.Warnf("Unknown export type: %d", t)
-		}
+		return durationMsOrDefault(e.config.FlushExportConnTimeoutMs, configuration.DefaultFlushExportConnTimeoutMs),
+			durationMsOrDefault(e.config.FlushExportDataTimeoutMs, configuration.DefaultFlushExportDataTimeoutMs)
+	}
+
+	if t != exportTypePeriodic {
+		e.logger.Warnf("Unknown export type: %d", t)
+	}
 
-		conn = configuration.DefaultRegularExportConnTimeoutMs
-		data = configuration.DefaultRegularExportDataTimeoutMs
+	return durationMsOrDefault(e.config.RegularExportConnTimeoutMs, configuration.DefaultRegularExportConnTimeoutMs),
+		durationMsOrDefault(e.config.RegularExportDataTimeoutMs, configuration.DefaultRegularExportDataTimeoutMs)
+}
+
+// connTimeoutKey is the context key of the connection timeout of a request
+type connTimeoutKey struct{}
+
+// dialWithConnTimeout establishes a connection within the connection timeout stored in the request context.
+func dialWithConnTimeout(ctx context.Context, network, address string) (net.Conn, error) {
+	d := &net.Dialer{
+		Timeout: time.Millisecond * configuration.DefaultRegularExportConnTimeoutMs,
+	}
+	if timeout, ok := ctx.Value(connTimeoutKey{}).(time.Duration); ok {
+		d.Timeout = timeout
 	}
 
-	e.dialer.Timeout = time.Millisecond * time.Duration(conn)
-	e.client.Timeout = time.Millisecond * time.Duration(conn+data)
+	return d.DialContext(ctx, network, address)
 }
@@ -0,0 +1,193 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	cRetryMaxAttempts    = 4
+	cRetryInitialBackoff = 500 * time.Millisecond
+	cRetryMaxBackoff     = 5 * time.Second
+	cRetryMaxRetryAfter  = 10 * time.Second
+)
+
+// retryableError wraps an export error that may be resolved by sending the same request again.
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// retryPolicy determines whether and when a failed export request is sent again.
+// Delays grow exponentially with every attempt and are randomized to avoid synchronized retries of many exporters.
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxRetryAfter  time.Duration
+
+	rngLock sync.Mutex
+	rng     *rand.Rand
+}
+
+func newRetryPolicy() *retryPolicy {
+	return &retryPolicy{
+		maxAttempts:    cRetryMaxAttempts,
+		initialBackoff: cRetryInitialBackoff,
+		maxBackoff:     cRetryMaxBackoff,
+		maxRetryAfter:  cRetryMaxRetryAfter,
+		rng:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// nextDelay returns the delay before the next attempt, given the number of attempts made so far.
+// Returns false if no further attempt should be made, either because the maximum number of attempts is reached or
+// because the next attempt could not start before the deadline of the context.
+func (p *retryPolicy) nextDelay(ctx context.Context, attempts int, retryAfter time.Duration) (time.Duration, bool) {
+	if attempts >= p.maxAttempts {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if retryAfter > 0 {
+		if retryAfter > p.maxRetryAfter {
+			return 0, false
+		}
+		delay = retryAfter
+	} else {
+		delay = p.backoff(attempts)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return 0, false
+	}
+
+	return delay, true
+}
+
+// backoff returns an exponentially growing delay with "equal jitter": the delay is between half and
+// the full exponential backoff value.
+func (p *retryPolicy) backoff(attempts int) time.Duration {
+	backoff := p.maxBackoff
+	if attempts < 31 {
+		if exp := p.initialBackoff << uint(attempts-1); exp > 0 && exp < p.maxBackoff {
+			backoff = exp
+		}
+	}
+
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+
+	p.rngLock.Lock()
+	defer p.rngLock.Unlock()
+	return time.Duration(half + p.rng.Int63n(half+1))
+}
+
+// isRetryableStatusCode reports whether a response status code indicates a temporary condition on the server side.
+func isRetryableStatusCode(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTransientNetworkError reports whether a request error is caused by a network condition that may be temporary.
+func isTransientNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// e.g. a host which does not exist is permanent, a DNS server which can not be reached is not
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && (dnsErr.IsTemporary || dnsErr.IsTimeout)
+}
+
+// isTemporaryExportError reports whether an export error is caused by a condition that may be resolved later,
+// i.e. sending the same chunk at a later point in time may succeed.
+func isTemporaryExportError(err error) bool {
+	var retryErr *retryableError
+	return errors.As(err, &retryErr) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		isTransientNetworkError(err)
+}
+
+// parseRetryAfter parses the value of a Retry-After header which is either a number of seconds or an HTTP date.
+// Returns 0 if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
+
+// sleepWithContext waits for the given duration or until the context is done.
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRetryPolicy() *retryPolicy {
+	return &retryPolicy{
+		maxAttempts:    cRetryMaxAttempts,
+		initialBackoff: time.Millisecond,
+		maxBackoff:     5 * time.Millisecond,
+		maxRetryAfter:  cRetryMaxRetryAfter,
+		rng:            rand.New(rand.NewSource(1)),
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := newRetryPolicy()
+
+	for attempts := 1; attempts < 40; attempts++ {
+		expected := cRetryMaxBackoff
+		if attempts < 5 {
+			expected = cRetryInitialBackoff << uint(attempts-1)
+		}
+
+		backoff := p.backoff(attempts)
+		require.GreaterOrEqual(t, int64(backoff), int64(expected/2))
+		require.LessOrEqual(t, int64(backoff), int64(expected))
+	}
+}
+
+func TestRetryPolicyNextDelay(t *testing.T) {
+	p := newRetryPolicy()
+
+	delay, ok := p.nextDelay(context.Background(), 1, 0)
+	require.True(t, ok)
+	require.LessOrEqual(t, int64(delay), int64(cRetryInitialBackoff))
+
+	delay, ok = p.nextDelay(context.Background(), 1, 2*time.Second)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Second, delay, "Retry-After must be honored")
+
+	_, ok = p.nextDelay(context.Background(), 1, cRetryMaxRetryAfter+time.Second)
+	require.False(t, ok, "Retry-After exceeding the maximum must not be retried")
+
+	_, ok = p.nextDelay(context.Background(), cRetryMaxAttempts, 0)
+	require.False(t, ok, "maximum number of attempts is reached")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, ok = p.nextDelay(ctx, 1, 2*time.Second)
+	require.False(t, ok, "next attempt must not start after the context deadline")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 10, 11, 12, 0, 0, 0, time.UTC)
+
+	require.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	require.Equal(t, 30*time.Second, parseRetryAfter("Tue, 11 Oct 2022 12:00:30 GMT", now))
+	require.Zero(t, parseRetryAfter("", now))
+	require.Zero(t, parseRetryAfter("-1", now))
+	require.Zero(t, parseRetryAfter("soon", now))
+	require.Zero(t, parseRetryAfter("Tue, 11 Oct 2022 11:00:00 GMT", now))
+}
+
+func TestIsTransientNetworkError(t *testing.T) {
+	require.True(t, isTransientNetworkError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}))
+	require.True(t, isTransientNetworkError(syscall.ECONNRESET))
+	require.False(t, isTransientNetworkError(context.Canceled))
+	require.False(t, isTransientNetworkError(context.DeadlineExceeded))
+	require.False(t, isTransientNetworkError(errors.New("some error")))
+	require.False(t, isTransientNetworkError(&net.OpError{Op: "dial", Err: syscall.ENETUNREACH}))
+	require.False(t, isTransientNetworkError(&net.OpError{Op: "dial", Err: &net.DNSError{Name: "nohost", IsNotFound: true}}))
+	require.True(t, isTransientNetworkError(&net.OpError{Op: "dial", Err: &net.DNSError{Name: "host", IsTemporary: true}}))
+	require.True(t, isTransientNetworkError(&net.OpError{Op: "dial", Err: &net.DNSError{Name: "host", IsTimeout: true}}))
+}
+
+func TestSpanExportRetriesRetryableStatusCodes(t *testing.T) {
+	var lock sync.Mutex
+	var bodies [][]byte
+	statusCodes := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}
+
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+		rw.WriteHeader(statusCodes[len(bodies)])
+		bodies = append(bodies, body)
+	})
+	defer testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.NoError(t, err)
+	require.Len(t, bodies, 3)
+	for _, body := range bodies {
+		require.Equal(t, []byte{1, 2, 3}, body, "the same chunk must be sent on retry")
+	}
+}
+
+func TestSpanExportDoesNotRetryNonRetryableStatusCodes(t *testing.T) {
+	numRequests := 0
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		numRequests++
+		rw.WriteHeader(http.StatusBadRequest)
+	})
+	defer testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.EqualError(t, err, "unexpected response code: 400")
+	require.Equal(t, 1, numRequests)
+}
+
+func TestSpanExportGivesUpAfterMaxAttempts(t *testing.T) {
+	numRequests := 0
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		numRequests++
+		rw.WriteHeader(http.StatusBadGateway)
+	})
+	defer testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.EqualError(t, err, "unexpected response code: 502")
+	require.Equal(t, cRetryMaxAttempts, numRequests)
+}
+
+func TestSpanExportRetryAfterExceedsFlushDeadline(t *testing.T) {
+	numRequests := 0
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		numRequests++
+		rw.Header().Set("Retry-After", "5")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err := exporter.doExportRequest(ctx, exportTypeForceFlush, exportData{1, 2, 3})
+	require.EqualError(t, err, "unexpected response code: 503")
+	require.Equal(t, 1, numRequests)
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestSpanExportRetriesConnectionErrors(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {})
+	// nothing listens on the address of a closed server
+	testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+
+	numAttempts := 0
+	exporter.client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		numAttempts++
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.Error(t, err)
+	require.Equal(t, cRetryMaxAttempts, numAttempts)
+}
+
+func TestSpanExportDoesNotRetryUnresolvableHost(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {})
+	defer testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+
+	numAttempts := 0
+	exporter.client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		numAttempts++
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{
+			Err:        "no such host",
+			Name:       "nohost",
+			IsNotFound: true,
+		}}
+	})
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.Error(t, err)
+	require.False(t, isTemporaryExportError(err))
+	require.Equal(t, 1, numAttempts)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
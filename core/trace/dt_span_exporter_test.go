@@ -16,12 +16,16 @@ package trace
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -30,8 +34,10 @@ import (
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	protoCollectorTraces "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/collector/traces/v1"
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/version"
 )
 
@@ -49,8 +55,8 @@ func TestDtSpanExporterVerifyNewRequest(t *testing.T) {
 		DebugAddStackOnStart:     false,
 	}
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
-	req, err := exporter.newRequest(context.Background(), bytes.NewReader([]byte{1, 2, 3, 4, 5}))
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	req, err := exporter.newRequest(context.Background(), exporter.connection.settings(), bytes.NewReader([]byte{1, 2, 3, 4, 5}))
 
 	require.NoError(t, err)
 	require.Equal(t, req.Method, "POST")
@@ -98,8 +104,8 @@ func TestDtSpanExporterPerformHTTPRequest(t *testing.T) {
 		DebugAddStackOnStart:     false,
 	}
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
-	req, _ := exporter.newRequest(context.Background(), bytes.NewReader([]byte{10, 20, 30}))
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	req, _ := exporter.newRequest(context.Background(), exporter.connection.settings(), bytes.NewReader([]byte{10, 20, 30}))
 	resp, err := exporter.performHttpRequest(req, exportTypePeriodic)
 	require.Equal(t, resp.StatusCode, http.StatusOK)
 	require.NoError(t, err)
@@ -127,8 +133,8 @@ func TestDtSpanExporterPerformHTTPRequestWithReachedFlushOperationTimeout(t *tes
 		DebugAddStackOnStart:     false,
 	}
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
-	req, _ := exporter.newRequest(context.Background(), bytes.NewReader([]byte{10, 20, 30}))
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	req, _ := exporter.newRequest(context.Background(), exporter.connection.settings(), bytes.NewReader([]byte{10, 20, 30}))
 	resp, err := exporter.performHttpRequest(req, exportTypeForceFlush)
 	require.Nil(t, resp)
 	require.ErrorContains(t, err, "context deadline exceeded (Client.Timeout exceeded while awaiting headers")
@@ -136,16 +142,53 @@ func TestDtSpanExporterPerformHTTPRequestWithReachedFlushOperationTimeout(t *tes
 	defer testServer.Close()
 }
 
-func TestDtSpanExporterUpdateHttpClientTimeouts(t *testing.T) {
-	exporter := newDtSpanExporter(testConfig).(*dtSpanExporterImpl)
+func TestDtSpanExporterHttpClientTimeouts(t *testing.T) {
+	exporter := newDtSpanExporter(testConfig, newDtStats(), nil).(*dtSpanExporterImpl)
 
-	exporter.setTimeouts(exportTypeForceFlush)
-	require.Equal(t, exporter.dialer.Timeout, time.Millisecond*time.Duration(configuration.DefaultFlushExportConnTimeoutMs))
-	require.Equal(t, exporter.client.Timeout, time.Millisecond*time.Duration(configuration.DefaultFlushExportConnTimeoutMs+configuration.DefaultFlushExportDataTimeoutMs))
+	conn, data := exporter.timeouts(exportTypeForceFlush)
+	require.Equal(t, time.Millisecond*time.Duration(configuration.DefaultFlushExportConnTimeoutMs), conn)
+	require.Equal(t, time.Millisecond*time.Duration(configuration.DefaultFlushExportDataTimeoutMs), data)
 
-	exporter.setTimeouts(exportTypePeriodic)
-	require.Equal(t, exporter.dialer.Timeout, time.Millisecond*time.Duration(configuration.DefaultRegularExportConnTimeoutMs))
-	require.Equal(t, exporter.client.Timeout, time.Millisecond*time.Duration(configuration.DefaultRegularExportConnTimeoutMs+configuration.DefaultRegularExportDataTimeoutMs))
+	conn, data = exporter.timeouts(exportTypePeriodic)
+	require.Equal(t, time.Millisecond*time.Duration(configuration.DefaultRegularExportConnTimeoutMs), conn)
+	require.Equal(t, time.Millisecond*time.Duration(configuration.DefaultRegularExportDataTimeoutMs), data)
+}
+
+func TestDtSpanExporterConfiguredHttpClientTimeouts(t *testing.T) {
+	config := *testConfig
+	config.FlushExportConnTimeoutMs = 100
+	config.FlushExportDataTimeoutMs = 200
+	config.RegularExportConnTimeoutMs = 300
+	config.RegularExportDataTimeoutMs = 400
+	exporter := newDtSpanExporter(&config, newDtStats(), nil).(*dtSpanExporterImpl)
+
+	conn, data := exporter.timeouts(exportTypeForceFlush)
+	require.Equal(t, 100*time.Millisecond, conn)
+	require.Equal(t, 200*time.Millisecond, data)
+
+	conn, data = exporter.timeouts(exportTypePeriodic)
+	require.Equal(t, 300*time.Millisecond, conn)
+	require.Equal(t, 400*time.Millisecond, data)
+}
+
+func TestDtSpanExporterDoesNotModifySharedHttpClient(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	})
+	defer testServer.Close()
+	config.FlushExportConnTimeoutMs = 100
+	config.FlushExportDataTimeoutMs = 100
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.client.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		require.Equal(t, 100*time.Millisecond, req.Context().Value(connTimeoutKey{}))
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	req, _ := exporter.newRequest(context.Background(), exporter.connection.settings(), bytes.NewReader([]byte{1, 2, 3}))
+	_, err := exporter.performHttpRequest(req, exportTypeForceFlush)
+	require.ErrorContains(t, err, "Client.Timeout exceeded")
+	require.Zero(t, exporter.client.Timeout)
 }
 
 func TestSpanExportWithoutErrors(t *testing.T) {
@@ -156,7 +199,7 @@ func TestSpanExportWithoutErrors(t *testing.T) {
 	}))
 	defer testServer.Close()
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
 	tracer := createTracer()
 
 	_, span1 := tracer.Start(context.Background(), "span1")
@@ -180,7 +223,7 @@ func TestSpanExportWithoutErrors_MultipleExports(t *testing.T) {
 	}))
 	defer testServer.Close()
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
 	tracer := createTracer()
 
 	_, span1 := tracer.Start(context.Background(), "span1",
@@ -207,7 +250,7 @@ func TestSpanExportWithoutErrors_DroppedSpans(t *testing.T) {
 	})
 	defer testServer.Close()
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
 	tracer := createTracer()
 
 	_, span1 := tracer.Start(context.Background(), "span1",
@@ -232,7 +275,7 @@ func TestSpanExportWithError_ResourceTooBig(t *testing.T) {
 	})
 	defer testServer.Close()
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
 	largeString := strings.Repeat("r", 64*1024*1024) // 64 MB
 	tracer := createTracer(sdktrace.WithResource(resource.NewSchemaless(attribute.String("large-string", largeString))))
 
@@ -255,7 +298,7 @@ func TestSpanExportWithoutError_LargeResource(t *testing.T) {
 	})
 	defer testServer.Close()
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
 	largeString := strings.Repeat("r", 1024*1024) // 1 MB
 	tracer := createTracer(sdktrace.WithResource(resource.NewSchemaless(attribute.String("large-string", largeString))))
 
@@ -278,7 +321,7 @@ func TestSpanExportWithoutError_MidSizedSpans(t *testing.T) {
 	})
 	defer testServer.Close()
 
-	exporter := newDtSpanExporter(config).(*dtSpanExporterImpl)
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
 	tracer := createTracer()
 
 	largeString := strings.Repeat("r", 1024*512) // 500 KB
@@ -297,6 +340,267 @@ func TestSpanExportWithoutError_MidSizedSpans(t *testing.T) {
 	require.Equal(t, 2, numRequests, "the spans fit individually into 1 export under warning size but 2 exceeds the warning size -> 2 exports")
 }
 
+// createPayloadLimitTestServerAndConfig creates a test server which rejects span exports larger than the given limit
+// with 413 and counts the spans it accepts.
+func createPayloadLimitTestServerAndConfig(t *testing.T, limit int, numSpans *int32) (*httptest.Server, *configuration.DtConfiguration) {
+	return createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		if len(body) > limit {
+			rw.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		spanExport := &protoCollectorTraces.SpanExport{}
+		require.NoError(t, proto.Unmarshal(body, spanExport))
+		atomic.AddInt32(numSpans, int32(len(spanExport.Spans)))
+	})
+}
+
+func startSpansWithAttributeForTest(tracer trace.Tracer, n int, value string) []trace.Span {
+	spans := make([]trace.Span, 0, n)
+	for i := 0; i < n; i++ {
+		_, span := tracer.Start(context.Background(), fmt.Sprintf("span%d", i),
+			trace.WithAttributes(attribute.String("attr-key", value)))
+		// attributes are only serialized for ended spans
+		span.(*dtSpan).metadata.sendState = sendStateSpanEnded
+		spans = append(spans, span)
+	}
+	return spans
+}
+
+func TestSpanExportSplitsChunkOnPayloadTooLarge(t *testing.T) {
+	var numSpans int32
+	testServer, config := createPayloadLimitTestServerAndConfig(t, 2500, &numSpans)
+	defer testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	tracer := createTracer()
+
+	spans := startSpansWithAttributeForTest(tracer, 10, strings.Repeat("r", 500))
+	err := exporter.export(context.Background(), exportTypeForceFlush, makeSpanSet(spans...))
+	require.NoError(t, err)
+	require.EqualValues(t, 10, atomic.LoadInt32(&numSpans), "all spans must be sent in smaller chunks")
+
+	stats := exporter.stats.snapshot()
+	require.NotZero(t, stats.ChunksSplit)
+	require.Zero(t, stats.SpansDroppedTooBig)
+	require.Less(t, exporter.serializer.getChunkSizeTarget(), 2500, "the chunk size target must be lowered")
+
+	// later exports are chunked by the lowered target and not rejected anymore
+	rejected := stats.HttpStatusCodes[http.StatusRequestEntityTooLarge]
+	spans = startSpansWithAttributeForTest(tracer, 10, strings.Repeat("r", 500))
+	err = exporter.export(context.Background(), exportTypeForceFlush, makeSpanSet(spans...))
+	require.NoError(t, err)
+	require.EqualValues(t, 20, atomic.LoadInt32(&numSpans))
+	require.Equal(t, rejected, exporter.stats.snapshot().HttpStatusCodes[http.StatusRequestEntityTooLarge])
+}
+
+func TestSpanExportDropsSpanWhichExceedsPayloadLimit(t *testing.T) {
+	var numSpans int32
+	testServer, config := createPayloadLimitTestServerAndConfig(t, 2000, &numSpans)
+	defer testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	tracer := createTracer()
+
+	spans := startSpansWithAttributeForTest(tracer, 1, strings.Repeat("r", 5000))
+	_, span2 := tracer.Start(context.Background(), "span2")
+	_, span3 := tracer.Start(context.Background(), "span3")
+
+	err := exporter.export(context.Background(), exportTypeForceFlush, makeSpanSet(spans[0], span2, span3))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, atomic.LoadInt32(&numSpans))
+	require.EqualValues(t, 1, exporter.stats.snapshot().SpansDroppedTooBig)
+}
+
+func TestSpanExportWithConfiguredChunkSize(t *testing.T) {
+	numRequests := 0
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		numRequests++
+	})
+	defer testServer.Close()
+
+	config.ExportChunkSizeKb = 2
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	tracer := createTracer()
+
+	spans := startSpansWithAttributeForTest(tracer, 4, strings.Repeat("r", 1500))
+	err := exporter.export(context.Background(), exportTypeForceFlush, makeSpanSet(spans...))
+	require.NoError(t, err)
+	require.Equal(t, 4, numRequests, "each span exceeds half of the chunk size -> 1 export per span")
+}
+
+func TestSpanExportUploadsChunksConcurrently(t *testing.T) {
+	const concurrency = 3
+
+	var inFlight, maxInFlight, numSpans int32
+	allInFlight := make(chan struct{})
+	var allInFlightOnce sync.Once
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+
+		// hold the requests until the maximum number is in flight
+		if current == concurrency {
+			allInFlightOnce.Do(func() { close(allInFlight) })
+		}
+		select {
+		case <-allInFlight:
+		case <-time.After(time.Second):
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		spanExport := &protoCollectorTraces.SpanExport{}
+		require.NoError(t, proto.Unmarshal(body, spanExport))
+		atomic.AddInt32(&numSpans, int32(len(spanExport.Spans)))
+	})
+	defer testServer.Close()
+
+	config.ExportChunkSizeKb = 1
+	config.ExportConcurrency = concurrency
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+
+	spans := startSpansWithAttributeForTest(createTracer(), 8, strings.Repeat("r", 1500))
+	err := exporter.export(context.Background(), exportTypeForceFlush, makeSpanSet(spans...))
+	require.NoError(t, err)
+	require.EqualValues(t, 8, atomic.LoadInt32(&numSpans), "each span is sent in its own chunk")
+	require.EqualValues(t, concurrency, atomic.LoadInt32(&maxInFlight))
+}
+
+func TestUploadChunksReturnsErrorsInChunkOrder(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		switch string(body) {
+		case "chunk1":
+			// the first failing chunk responds last
+			time.Sleep(100 * time.Millisecond)
+			rw.WriteHeader(http.StatusBadRequest)
+		case "chunk3":
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer testServer.Close()
+
+	config.ExportConcurrency = 4
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+
+	chunks := make(chan exportData, 4)
+	for i := 0; i < 4; i++ {
+		chunks <- exportData(fmt.Sprintf("chunk%d", i))
+	}
+	close(chunks)
+
+	err := exporter.uploadChunks(context.Background(), exportTypeForceFlush, chunks, nil, func() {})
+	require.EqualError(t, err, "unexpected response code: 400")
+}
+
+func TestSpanExportCancellationDoesNotLeakGoroutines(t *testing.T) {
+	requestReceived := make(chan struct{}, 16)
+	release := make(chan struct{})
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		requestReceived <- struct{}{}
+		select {
+		case <-req.Context().Done():
+		case <-release:
+		}
+	})
+	defer testServer.Close()
+	defer close(release)
+
+	config.ExportChunkSizeKb = 1
+	config.ExportConcurrency = 2
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+	spans := startSpansWithAttributeForTest(createTracer(), 10, strings.Repeat("r", 1500))
+	// the time synchronization keeps running in the background until the exporter is stopped
+	exporter.timeSync.start()
+	defer exporter.stop()
+	require.Eventually(t, func() bool {
+		return !exporter.timeSync.isSyncDue()
+	}, 5*time.Second, 10*time.Millisecond)
+	exporter.client.CloseIdleConnections()
+
+	numGoroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	exportDone := make(chan error, 1)
+	go func() {
+		exportDone <- exporter.export(ctx, exportTypePeriodic, makeSpanSet(spans...))
+	}()
+
+	<-requestReceived
+	cancel()
+
+	select {
+	case err := <-exportDone:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "export has not returned after the context has been canceled")
+	}
+
+	exporter.client.CloseIdleConnections()
+	require.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= numGoroutines
+	}, 5*time.Second, 10*time.Millisecond, "the serializer and upload goroutines must have stopped")
+}
+
+func TestSpanExportSkippedWhileCircuitBreakerIsOpen(t *testing.T) {
+	var numRequests int32
+	var available int32
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&numRequests, 1)
+		if atomic.LoadInt32(&available) == 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	defer testServer.Close()
+	config.CircuitBreakerFailureThreshold = 1
+
+	processorConfig := *testConfig
+	p := newDtSpanProcessor(&processorConfig, nil)
+	defer p.shutdown(context.Background()) //nolint:errcheck
+	exporter := newDtSpanExporter(config, p.stats, nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	exporter.breaker.now = clock.Now
+	p.exporter = exporter
+	tracer := createTracer()
+	endSpan := func() {
+		_, span := tracer.Start(context.Background(), "span")
+		span.End()
+		p.onEnd(span.(*dtSpan))
+	}
+
+	endSpan()
+	err := p.sendSpansToExport(context.Background(), false, exportTypeForceFlush)
+	require.EqualError(t, err, "unexpected response code: 503")
+	require.EqualValues(t, cRetryMaxAttempts, atomic.LoadInt32(&numRequests))
+
+	endSpan()
+	err = p.sendSpansToExport(context.Background(), false, exportTypeForceFlush)
+	require.Equal(t, errCircuitBreakerOpen, err)
+	require.EqualValues(t, cRetryMaxAttempts, atomic.LoadInt32(&numRequests), "no request is sent while it is open")
+
+	atomic.StoreInt32(&available, 1)
+	clock.Advance(time.Duration(configuration.DefaultCircuitBreakerOpenDurationMs) * time.Millisecond)
+	err = p.sendSpansToExport(context.Background(), false, exportTypeForceFlush)
+	require.NoError(t, err)
+	require.EqualValues(t, cRetryMaxAttempts+1, atomic.LoadInt32(&numRequests))
+
+	snapshot := p.stats.snapshot()
+	require.Equal(t, CircuitBreakerState_Closed, snapshot.CircuitBreakerState)
+	require.EqualValues(t, 1, snapshot.CircuitBreakerOpened)
+	require.EqualValues(t, 1, snapshot.ExportsSkippedCircuitOpen)
+}
+
 func makeSpanSet(spans ...trace.Span) dtSpanSet {
 	spanSet := make(dtSpanSet)
 	for _, span := range spans {
@@ -305,8 +609,16 @@ func makeSpanSet(spans ...trace.Span) dtSpanSet {
 	return spanSet
 }
 
+// createTestServerAndConfig creates a test server which passes all span export requests to the given handler.
+// Cluster time requests are answered with the current time.
 func createTestServerAndConfig(handler http.HandlerFunc) (*httptest.Server, *configuration.DtConfiguration) {
-	testServer := httptest.NewServer(handler)
+	testServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == cClusterTimePath {
+			writeClusterTimeResponse(rw, time.Now())
+			return
+		}
+		handler(rw, req)
+	}))
 	config := &configuration.DtConfiguration{
 		ClusterId:                -1234,
 		Tenant:                   "testDtTenant",
@@ -321,3 +633,61 @@ func createTestServerAndConfig(handler http.HandlerFunc) (*httptest.Server, *con
 	}
 	return testServer, config
 }
+
+func TestSpanExportWithGzipCompression(t *testing.T) {
+	data := exportData(bytes.Repeat([]byte{1, 2, 3, 4}, 1024))
+
+	numRequests := 0
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		numRequests++
+		require.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+		require.Less(t, req.ContentLength, int64(len(data)))
+
+		reader, err := gzip.NewReader(req.Body)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		require.EqualValues(t, data, body)
+
+		if numRequests == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	defer testServer.Close()
+	config.ExportCompression = configuration.ExportCompression_Gzip
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, data)
+	require.NoError(t, err)
+	require.Equal(t, 2, numRequests, "the compressed body must be sent again on retry")
+}
+
+func TestSpanExportWithoutCompression(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		require.Empty(t, req.Header.Get("Content-Encoding"))
+		require.EqualValues(t, req.ContentLength, 3)
+	})
+	defer testServer.Close()
+
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	err := exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3})
+	require.NoError(t, err)
+}
+
+func TestDumpRequestWithoutSecrets(t *testing.T) {
+	exporter := newDtSpanExporter(testConfig, newDtStats(), nil).(*dtSpanExporterImpl)
+	req, err := exporter.newRequest(context.Background(), exporter.connection.settings(), bytes.NewReader([]byte{1, 2, 3}))
+	require.NoError(t, err)
+	req.Header.Set("Proxy-Authorization", "Basic cHJveHk6c2VjcmV0")
+
+	dump, err := dumpRequestWithoutSecrets(req)
+	require.NoError(t, err)
+	require.Contains(t, string(dump), "User-Agent")
+	require.NotContains(t, string(dump), testConfig.AuthToken)
+	require.NotContains(t, string(dump), "cHJveHk6c2VjcmV0")
+
+	// the request which is sent still contains the credentials
+	require.Equal(t, "Dynatrace "+testConfig.AuthToken, req.Header.Get("Authorization"))
+}
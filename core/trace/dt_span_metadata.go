@@ -52,6 +52,8 @@ type dtSpanMetadata struct {
 	lastSentMs  int64
 	seqNumber   int32
 	options     *transmitOptions
+	// evicted is set if the span has been evicted from the full span watchlist, guarded by the watchlist lock
+	evicted bool
 
 	fw4Tag              *fw4.Fw4Tag
 	lastPropagationTime time.Time
@@ -123,16 +125,28 @@ func (p *dtSpanMetadata) markPropagatedNow() {
 	p.lastPropagationTime = time.Now()
 }
 
-func dtSpanMetadataFromSpan(parentSpan trace.Span) *dtSpanMetadata {
+// dtSpanMetadataFromSpan returns the metadata of the span. A span which is not a dtSpan is looked up in the given
+// registry, or in the registries of all DtSpanProcessors if it is nil.
+func dtSpanMetadataFromSpan(parentSpan trace.Span, registry *dtSpanRegistry) *dtSpanMetadata {
 	if parentDtSpan, ok := parentSpan.(*dtSpan); ok {
 		return parentDtSpan.metadata
 	}
+
+	var parentDtSpan *dtSpan
+	if registry != nil {
+		parentDtSpan = registry.get(parentSpan.SpanContext())
+	} else {
+		parentDtSpan = spanRegistries.get(parentSpan.SpanContext())
+	}
+	if parentDtSpan != nil {
+		return parentDtSpan.metadata
+	}
 	return nil
 }
 
-func dtSpanMetadataFromContext(ctx context.Context) *dtSpanMetadata {
+func dtSpanMetadataFromContext(ctx context.Context, registry *dtSpanRegistry) *dtSpanMetadata {
 	parentSpan := trace.SpanFromContext(ctx)
-	return dtSpanMetadataFromSpan(parentSpan)
+	return dtSpanMetadataFromSpan(parentSpan, registry)
 }
 
 func (p *dtSpanMetadata) getFw4Tag() *fw4.Fw4Tag {
@@ -77,11 +77,46 @@ func TestDtSpanMetadataDropNonEndedOutdatedSpan(t *testing.T) {
 	// non-ended span older than openSpanTimeout interval must be dropped
 	sendTime := (time.Now().UnixNano() / int64(time.Millisecond)) + s.metadata.options.openSpanTimeoutMs
 	require.Equal(t, s.prepareSend(sendTime), prepareResultDrop)
-	require.EqualValues(t, s.metadata.lastSentMs, 0)
-	require.EqualValues(t, s.metadata.seqNumber, -1)
+	// the drop is sent as a final update of the span
+	require.EqualValues(t, s.metadata.lastSentMs, sendTime)
+	require.EqualValues(t, s.metadata.seqNumber, 0)
 	require.Equal(t, s.metadata.sendState, sendStateDrop)
 }
 
+func TestDtSpanMetadataConfiguredOpenSpanTimeout(t *testing.T) {
+	config := *testConfig
+	config.OpenSpanTimeoutMs = 60000
+
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(&config))
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+
+	_, span := tp.Tracer("Dynatrace Tracer").Start(context.Background(), "Span A")
+	s := span.(*dtSpan)
+	require.EqualValues(t, 60000, s.metadata.options.openSpanTimeoutMs)
+
+	sendTime := s.metadata.firstSeenMs + 60000
+	require.Equal(t, s.prepareSend(sendTime), prepareResultDrop)
+}
+
+func TestDtSpanMetadataConfiguredKeepAliveInterval(t *testing.T) {
+	config := *testConfig
+	config.KeepAliveIntervalMs = 10000
+
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(&config))
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+
+	_, span := tp.Tracer("Dynatrace Tracer").Start(context.Background(), "Span A")
+	s := span.(*dtSpan)
+	require.EqualValues(t, 10000, s.metadata.options.keepAliveIntervalMs)
+
+	sendTime := s.metadata.firstSeenMs + s.metadata.options.updateIntervalMs
+	require.Equal(t, prepareResultSend, s.prepareSend(sendTime))
+	require.Equal(t, prepareResultSkip, s.prepareSend(sendTime+9999))
+	require.Equal(t, prepareResultSend, s.prepareSend(sendTime+10000))
+}
+
 func TestDtSpanMetadataSendSpanAfterKeepAliveInterval(t *testing.T) {
 	tp, _ := newDtTracerProviderWithTestExporter()
 	tr := tp.Tracer("Dynatrace Tracer")
@@ -17,6 +17,7 @@ package trace
 import (
 	"context"
 	"errors"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -43,52 +44,157 @@ type dtSpanProcessor struct {
 	exportingStopped        int32
 	shutdownOnce            sync.Once
 	flushRequestCh          chan *flushContext
+	overflowExportCh        chan struct{}
 	flushRequestLock        sync.Mutex
 	lastFlushRequestContext *flushContext
 	periodicSendOpTimer     *time.Timer
 	logger                  *logger.ComponentLogger
 	config                  *configuration.DtConfiguration
+	stats                   *dtStats
+	// spanRegistry holds the spans of an SDK TracerProvider which are tracked by a DtSpanProcessor, it remains empty
+	// for DtTracerProvider
+	spanRegistry *dtSpanRegistry
+
+	// lifecycleLock guards stopping the exporting loop after a rejected export request and restarting it
+	// once the auth token is changed, shuttingDown prevents a restart after shutdown has started
+	lifecycleLock sync.Mutex
+	shuttingDown  bool
+	configWatcher *dtConfigWatcher
 }
 
-// newDtSpanProcessor creates a Dynatrace span processor that will send spans to Dynatrace Cluster.
-func newDtSpanProcessor(config *configuration.DtConfiguration) *dtSpanProcessor {
+// newDtSpanProcessor creates a Dynatrace span processor that will send spans to Dynatrace Cluster. The HTTP transport
+// is created from the configuration unless a custom one is given.
+func newDtSpanProcessor(config *configuration.DtConfiguration, transport http.RoundTripper) *dtSpanProcessor {
+	watchlistSize := config.SpanWatchlistSize
+	if watchlistSize <= 0 {
+		watchlistSize = configuration.DefaultMaxSpansWatchlistSize
+	}
+
+	stats := newDtStats()
 	p := &dtSpanProcessor{
-		exporter:            newDtSpanExporter(config),
-		spanWatchlist:       newDtSpanWatchlist(configuration.DefaultMaxSpansWatchlistSize),
+		exporter:            newDtSpanExporter(config, stats, transport),
+		spanWatchlist:       newDtSpanWatchlist(watchlistSize, config.SpanWatchlistOverflowPolicy, stats),
+		spanRegistry:        newDtSpanRegistry(),
 		stopExportingCh:     make(chan struct{}, 1),
 		exportingStopped:    0,
 		flushRequestCh:      make(chan *flushContext, 1),
+		overflowExportCh:    make(chan struct{}, 1),
 		periodicSendOpTimer: time.NewTimer(time.Millisecond * time.Duration(config.SpanProcessingIntervalMs)),
 		logger:              logger.NewComponentLogger("SpanProcessor"),
 		config:              config,
+		stats:               stats,
 	}
 
+	p.spanWatchlist.spanRegistry = p.spanRegistry
+
+	p.startSpanExportingLoop()
+	p.configWatcher = startDtConfigWatcher(config, cConfigWatchInterval, p.updateConnection)
+
+	return p
+}
+
+func (p *dtSpanProcessor) startSpanExportingLoop() {
 	p.stopExportingWait.Add(1)
 	go func() {
 		defer p.stopExportingWait.Done()
 		p.runSpanExportingLoop()
 	}()
+}
 
-	return p
+// connection returns the connection of the span exporter, nil if the exporter does not send spans to
+// Dynatrace Cluster.
+func (p *dtSpanProcessor) connection() *dtConnection {
+	if exporter, ok := p.exporter.(dtConnectedSpanExporter); ok {
+		return exporter.getConnection()
+	}
+
+	return nil
+}
+
+// circuitBreaker returns the circuit breaker of the span exporter, nil if the exporter does not send spans to
+// Dynatrace Cluster.
+func (p *dtSpanProcessor) circuitBreaker() *dtCircuitBreaker {
+	if exporter, ok := p.exporter.(dtConnectedSpanExporter); ok {
+		return exporter.getCircuitBreaker()
+	}
+
+	return nil
+}
+
+// setAuthToken replaces the auth token which is used by the span exporter.
+func (p *dtSpanProcessor) setAuthToken(authToken string) {
+	p.updateConnection(func(settings *dtConnectionSettings) {
+		settings.authToken = authToken
+	})
+}
+
+// updateConnection applies changed connection settings to the span exporter. If the exporting loop has been stopped
+// because the previous auth token has been rejected, exporting is resumed. The base URL is kept if several BaseUrls
+// are configured, since the endpoints requests fail over between can not be changed at runtime.
+func (p *dtSpanProcessor) updateConnection(change func(settings *dtConnectionSettings)) {
+	p.lifecycleLock.Lock()
+	defer p.lifecycleLock.Unlock()
+
+	connection := p.connection()
+	if connection == nil {
+		return
+	}
+
+	baseUrlRejected := false
+	updated := connection.update(func(settings *dtConnectionSettings) {
+		baseUrl := settings.baseUrl
+		change(settings)
+		if settings.baseUrl != baseUrl && len(p.config.BaseUrls) > 1 {
+			settings.baseUrl = baseUrl
+			baseUrlRejected = true
+		}
+	})
+	if baseUrlRejected {
+		p.logger.Warn("BaseUrl can not be changed at runtime if several BaseUrls are configured, " +
+			"changing the endpoints requires a restart of the application")
+	}
+	if !updated {
+		return
+	}
+	p.logger.Info("Connection settings have been updated")
+
+	if p.shuttingDown || !p.isExportingStopped() {
+		return
+	}
+
+	// the loop sets exportingStopped right before it returns, so waiting for it does not block for long
+	p.stopExportingWait.Wait()
+	p.periodicSendOpTimer = time.NewTimer(time.Millisecond * time.Duration(p.config.SpanProcessingIntervalMs))
+	atomic.StoreInt32(&p.exportingStopped, 0)
+	p.startSpanExportingLoop()
+	p.logger.Info("Exporting is resumed")
 }
 
 // onStart adds a newly created span with a corresponding metadata struct to the span watchlist for later processing.
-func (p *dtSpanProcessor) onStart(ctx context.Context, s *dtSpan) {
+// Returns false if the span is not tracked. A span which does not fit into the full span watchlist is tracked, it is
+// added again when it ends.
+func (p *dtSpanProcessor) onStart(ctx context.Context, s *dtSpan) bool {
 	if p.isExportingStopped() {
-		return
+		return false
 	}
 
 	// only recording span has to be sent to Dynatrace Cluster
 	span, ok := s.Span.(sdktrace.ReadWriteSpan)
 	if !ok {
-		return
+		return false
 	}
 
 	p.logger.Debugf("Start span %s", span.Name())
+	p.stats.recordSpanStarted()
 
 	if !p.spanWatchlist.add(s) {
 		p.logger.Infof("Span watchlist map is full, can not add metadata for started span: %s", span.Name())
+		p.requestOverflowExport()
+	} else if p.spanWatchlist.isFull() {
+		// free capacity before the next span is started
+		p.requestOverflowExport()
 	}
+	return true
 }
 
 // onEnd adds ended span in a processor map for later processing if it wasn't added upon span start call due to a
@@ -105,10 +211,13 @@ func (p *dtSpanProcessor) onEnd(s *dtSpan) {
 	}
 
 	p.logger.Debugf("End span %s", span.Name())
-	if !p.spanWatchlist.contains(s) {
+	p.stats.recordSpanEnded()
+	if !p.spanWatchlist.contains(s) && !p.spanWatchlist.isEvicted(s) {
 		// most likely the span watchlist map was full on span start, so try to re-add span
 		if !p.spanWatchlist.add(s) {
 			p.logger.Infof("Span watchlist map is full, can not add metadata for ended span: %s", span.Name())
+			p.stats.recordSpanRejected()
+			p.requestOverflowExport()
 		}
 	}
 }
@@ -120,6 +229,11 @@ func (p *dtSpanProcessor) shutdown(ctx context.Context) error {
 	p.shutdownOnce.Do(func() {
 		p.logger.Debugf("Shutting down is called")
 
+		p.lifecycleLock.Lock()
+		p.shuttingDown = true
+		p.lifecycleLock.Unlock()
+		p.configWatcher.stop()
+
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
@@ -132,6 +246,10 @@ func (p *dtSpanProcessor) shutdown(ctx context.Context) error {
 			if err != nil {
 				p.logger.Warnf("Shutdown operation has failed: %s", err)
 			}
+			// the background tasks of the exporter are no longer needed once the remaining spans have been sent
+			if exporter, ok := p.exporter.(dtConnectedSpanExporter); ok {
+				exporter.stop()
+			}
 
 			p.lastFlushRequestContext = nil
 			close(waitShutdown)
@@ -141,7 +259,7 @@ func (p *dtSpanProcessor) shutdown(ctx context.Context) error {
 		select {
 		case <-waitShutdown:
 			p.logger.Debug("Shutdown operation is finished")
-		case <-time.After(time.Millisecond * configuration.DefaultFlushOrShutdownTimeoutMs):
+		case <-flushOrShutdownTimer(ctx, p.config):
 			p.logger.Warn("Shutdown operation timeout is reached")
 			cancel()
 			err = ctx.Err()
@@ -187,7 +305,7 @@ func (p *dtSpanProcessor) forceFlush(ctx context.Context) error {
 	}
 
 	select {
-	case <-time.After(time.Millisecond * configuration.DefaultFlushOrShutdownTimeoutMs):
+	case <-flushOrShutdownTimer(ctx, p.config):
 		// the flush operation SHOULD abort any in-progress send operation,
 		// thus cancel flush context to inform exporting goroutine
 		cancel()
@@ -203,6 +321,36 @@ func (p *dtSpanProcessor) forceFlush(ctx context.Context) error {
 	return flushCtx.err
 }
 
+// flushOrShutdownTimeout returns the maximum duration of a flush or shutdown operation. The deadline of the caller's
+// context takes precedence over the configured timeout, so that callers may allow more time, e.g. on shutdown.
+func flushOrShutdownTimeout(ctx context.Context, config *configuration.DtConfiguration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+
+	return durationMsOrDefault(config.FlushOrShutdownTimeoutMs, configuration.DefaultFlushOrShutdownTimeoutMs)
+}
+
+// flushOrShutdownTimer returns a channel which fires once the configured flush or shutdown timeout is reached.
+// If the context has a deadline, the returned channel never fires, the operation is aborted by the context instead.
+func flushOrShutdownTimer(ctx context.Context, config *configuration.DtConfiguration) <-chan time.Time {
+	if _, ok := ctx.Deadline(); ok {
+		return nil
+	}
+
+	return time.After(flushOrShutdownTimeout(ctx, config))
+}
+
+// durationMsOrDefault converts a configured number of milliseconds to a duration, the default value is used
+// if the configured value is not set.
+func durationMsOrDefault(ms, defaultMs int) time.Duration {
+	if ms <= 0 {
+		ms = defaultMs
+	}
+
+	return time.Millisecond * time.Duration(ms)
+}
+
 // runSpanExportingLoop starts exporting loop to process flush and periodic send operations
 func (p *dtSpanProcessor) runSpanExportingLoop() {
 	defer p.periodicSendOpTimer.Stop()
@@ -218,6 +366,17 @@ func (p *dtSpanProcessor) runSpanExportingLoop() {
 			if err != nil {
 				p.logger.Warnf("Periodic send operation has failed: %s", err)
 			}
+		case <-p.overflowExportCh:
+			p.logger.Debug("Execute overflow send operation...")
+			// stop the periodic send operation, the timer will be reset once exporting will be completed
+			if !p.periodicSendOpTimer.Stop() {
+				<-p.periodicSendOpTimer.C
+			}
+
+			err := p.sendSpansToExport(context.Background(), true, exportTypePeriodic)
+			if err != nil {
+				p.logger.Warnf("Overflow send operation has failed: %s", err)
+			}
 		case flushCtx := <-p.flushRequestCh:
 			p.logger.Debug("Execute flush operation...")
 			// stop the periodic send operation, the timer will be reset once exporting will be completed
@@ -232,6 +391,11 @@ func (p *dtSpanProcessor) runSpanExportingLoop() {
 
 			close(flushCtx.flushRequestFinished)
 		}
+
+		if p.isExportingStopped() {
+			p.logger.Debug("Finish exporting loop, span export requests are not authorized")
+			return
+		}
 	}
 }
 
@@ -239,6 +403,23 @@ func (p *dtSpanProcessor) isExportingStopped() bool {
 	return atomic.LoadInt32(&p.exportingStopped) == 1
 }
 
+// requestOverflowExport requests an immediate export of ended spans to free capacity in the span watchlist
+// if the overflow policy asks for it, or of the evicted spans once no more spans can be evicted until they have been
+// exported. Does nothing if such an export has already been requested.
+func (p *dtSpanProcessor) requestOverflowExport() {
+	if p.config.SpanWatchlistOverflowPolicy != configuration.SpanWatchlistOverflowPolicy_Export &&
+		!p.spanWatchlist.isEvictionFull() {
+		return
+	}
+
+	select {
+	case p.overflowExportCh <- struct{}{}:
+		p.logger.Debug("Overflow export is requested")
+		p.stats.recordOverflowExport()
+	default:
+	}
+}
+
 // requestStopSpanExportingLoop send request to stop span exporting loop
 func (p *dtSpanProcessor) requestStopSpanExportingLoop() {
 	select {
@@ -264,15 +445,54 @@ func (p *dtSpanProcessor) sendSpansToExport(ctx context.Context, resetPeriodicSe
 		return errInvalidSpanExporter
 	}
 
+	// the circuit breaker is checked before the spans leave the span watchlist, so that they are only lost if the
+	// retention policy says so
+	if breaker := p.circuitBreaker(); breaker != nil {
+		if !breaker.allowExport() {
+			return p.skipExportCircuitOpen(t)
+		}
+		defer breaker.exportFinished()
+	}
+
 	start := time.Now()
 	err := p.exporter.export(ctx, t, p.spanWatchlist.getSpansToExport())
 	p.logger.Debugf("Export operation took %s", time.Since(start))
 
+	if err != nil {
+		p.stats.recordError(err)
+	}
+
 	if err == errNotAuthorizedRequest {
 		// not authorized request can be fixed only if the auth token is changed, thus stop exporting loop
-		p.logger.Debugf("Stop exporting loop because span export request is not authorized")
-		p.requestStopSpanExportingLoop()
+		// unless the auth token has been changed while the request was in progress
+		p.lifecycleLock.Lock()
+		if connection := p.connection(); connection == nil || connection.isRejected() {
+			p.logger.Info("Stop exporting until the auth token is changed, span export request is not authorized")
+			atomic.StoreInt32(&p.exportingStopped, 1)
+		}
+		p.lifecycleLock.Unlock()
 	}
 
 	return err
 }
+
+// skipExportCircuitOpen skips an export operation while the circuit breaker is open. Depending on the retention
+// policy, the spans are either kept in the span watchlist until the circuit breaker closes or dropped, so that the
+// span watchlist does not fill up. Only flush operations report the skipped export as error.
+func (p *dtSpanProcessor) skipExportCircuitOpen(t exportType) error {
+	p.stats.recordExportSkippedCircuitOpen()
+
+	if p.config.CircuitBreakerRetentionPolicy == configuration.CircuitBreakerRetentionPolicy_Drop {
+		if spans := p.spanWatchlist.getSpansToExport(); len(spans) > 0 {
+			p.logger.Debugf("Skip exporting, circuit breaker is open, dropping %d spans", len(spans))
+			p.stats.recordSpansDroppedCircuitOpen(len(spans))
+		}
+	} else {
+		p.logger.Debug("Skip exporting, circuit breaker is open, spans are kept until it closes")
+	}
+
+	if t == exportTypeForceFlush {
+		return errCircuitBreakerOpen
+	}
+	return nil
+}
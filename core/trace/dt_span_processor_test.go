@@ -16,9 +16,12 @@ package trace
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -142,7 +145,7 @@ func TestDtSpanProcessorStartSpans(t *testing.T) {
 }
 
 func TestDtSpanProcessorShutdown(t *testing.T) {
-	p := newDtSpanProcessor(testConfig)
+	p := newDtSpanProcessor(testConfig, nil)
 	require.Zero(t, p.exportingStopped)
 	require.Zero(t, p.spanWatchlist.len())
 
@@ -175,7 +178,7 @@ func TestDtSpanProcessorShutdownCancelContext(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	p := newDtSpanProcessor(testConfig)
+	p := newDtSpanProcessor(testConfig, nil)
 	require.Zero(t, p.exportingStopped)
 
 	err := p.shutdown(ctx)
@@ -183,7 +186,7 @@ func TestDtSpanProcessorShutdownCancelContext(t *testing.T) {
 }
 
 func TestDtSpanProcessorShutdownTimeoutReached(t *testing.T) {
-	p := newDtSpanProcessor(testConfig)
+	p := newDtSpanProcessor(testConfig, nil)
 	p.exporter = newTestExporter(testExporterOptions{
 		iterationIntervalMs: 500,
 		numIterations:       20,
@@ -221,7 +224,7 @@ func TestDtSpanProcessorForceFlushCancelContext(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	p := newDtSpanProcessor(testConfig)
+	p := newDtSpanProcessor(testConfig, nil)
 	p.exporter = newTestExporter(testExporterOptions{
 		iterationIntervalMs: 500,
 		numIterations:       20,
@@ -234,7 +237,7 @@ func TestDtSpanProcessorForceFlushCancelContext(t *testing.T) {
 }
 
 func TestDtSpanProcessorForceFlushTimeoutReached(t *testing.T) {
-	p := newDtSpanProcessor(testConfig)
+	p := newDtSpanProcessor(testConfig, nil)
 	p.exporter = newTestExporter(testExporterOptions{
 		iterationIntervalMs: 500,
 		numIterations:       20,
@@ -311,3 +314,242 @@ func TestDtSpanProcessorWaitForScheduledFlushOperation(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestDtSpanProcessorForceFlushConfiguredTimeout(t *testing.T) {
+	config := *testConfig
+	config.FlushOrShutdownTimeoutMs = 200
+	p := newDtSpanProcessor(&config, nil)
+	defer p.shutdown(context.Background())
+	p.exporter = newTestExporter(testExporterOptions{
+		iterationIntervalMs: 100,
+		numIterations:       5,
+	})
+
+	err := p.forceFlush(context.Background())
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestDtSpanProcessorForceFlushHonorsLongerContextDeadline(t *testing.T) {
+	config := *testConfig
+	config.FlushOrShutdownTimeoutMs = 200
+	p := newDtSpanProcessor(&config, nil)
+	p.exporter = newTestExporter(testExporterOptions{
+		iterationIntervalMs: 100,
+		numIterations:       5,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, p.forceFlush(ctx), "the deadline of the context takes precedence over the configured timeout")
+	require.NoError(t, p.shutdown(ctx))
+}
+
+func TestFlushOrShutdownTimeout(t *testing.T) {
+	config := *testConfig
+	config.FlushOrShutdownTimeoutMs = 200
+	require.Equal(t, 200*time.Millisecond, flushOrShutdownTimeout(context.Background(), &config))
+
+	config.FlushOrShutdownTimeoutMs = 0
+	require.Equal(t, time.Millisecond*configuration.DefaultFlushOrShutdownTimeoutMs,
+		flushOrShutdownTimeout(context.Background(), &config))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	require.InDelta(t, time.Minute, flushOrShutdownTimeout(ctx, &config), float64(time.Second))
+}
+
+func TestDtSpanProcessorResumesExportingAfterAuthTokenChange(t *testing.T) {
+	var numExported int32
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Dynatrace newToken" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&numExported, 1)
+	})
+	defer testServer.Close()
+
+	tp, _ := newDtTracerProviderWithTestExporter()
+	tp.processor.exporter = newDtSpanExporter(config, tp.processor.stats, nil)
+	defer tp.Shutdown(context.Background())
+	tracer := tp.Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "rejected")
+	span.End()
+	require.ErrorIs(t, tp.ForceFlush(context.Background()), errNotAuthorizedRequest)
+	require.True(t, tp.processor.isExportingStopped(), "exporting must be stopped until the auth token is changed")
+
+	_, span = tracer.Start(context.Background(), "ignored")
+	span.End()
+	require.Zero(t, tp.processor.spanWatchlist.len(), "spans are not tracked while exporting is stopped")
+
+	tp.SetAuthToken("newToken")
+	require.False(t, tp.processor.isExportingStopped())
+
+	_, span = tracer.Start(context.Background(), "exported")
+	span.End()
+	require.NoError(t, tp.ForceFlush(context.Background()))
+	require.EqualValues(t, 1, atomic.LoadInt32(&numExported))
+}
+
+func TestDtSpanProcessorSetSameAuthTokenDoesNotResumeExporting(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	})
+	defer testServer.Close()
+
+	tp, _ := newDtTracerProviderWithTestExporter()
+	tp.processor.exporter = newDtSpanExporter(config, tp.processor.stats, nil)
+	defer tp.Shutdown(context.Background())
+
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	span.End()
+	require.ErrorIs(t, tp.ForceFlush(context.Background()), errNotAuthorizedRequest)
+
+	tp.SetAuthToken(config.AuthToken)
+	require.True(t, tp.processor.isExportingStopped(), "the rejected auth token must not be used again")
+}
+
+func TestDtSpanProcessorSetAuthTokenAfterShutdown(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	})
+	defer testServer.Close()
+
+	tp, _ := newDtTracerProviderWithTestExporter()
+	tp.processor.exporter = newDtSpanExporter(config, tp.processor.stats, nil)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	span.End()
+	require.ErrorIs(t, tp.ForceFlush(context.Background()), errNotAuthorizedRequest)
+	require.NoError(t, tp.Shutdown(context.Background()))
+
+	// the exporting loop is not restarted once the provider is shut down
+	tp.SetAuthToken("newToken")
+	require.True(t, tp.processor.isExportingStopped())
+}
+
+// newDtSpanProcessorWithOpenCircuitBreaker creates a span processor whose circuit breaker has been opened by a
+// failed export request.
+func newDtSpanProcessorWithOpenCircuitBreaker(
+	t *testing.T,
+	retentionPolicy configuration.CircuitBreakerRetentionPolicy,
+) (*dtSpanProcessor, *int32) {
+	var numRequests int32
+	testServer, serverConfig := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&numRequests, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	})
+	t.Cleanup(testServer.Close)
+
+	config := *testConfig
+	config.CircuitBreakerRetentionPolicy = retentionPolicy
+	p := newDtSpanProcessor(&config, nil)
+	t.Cleanup(func() { p.shutdown(context.Background()) }) //nolint:errcheck
+
+	serverConfig.CircuitBreakerFailureThreshold = 1
+	exporter := newDtSpanExporter(serverConfig, p.stats, nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+	exporter.breaker.recordResult(&retryableError{err: errors.New("unexpected response code: 503")})
+	p.exporter = exporter
+
+	return p, &numRequests
+}
+
+func TestDtSpanProcessorRetainsSpansWhileCircuitBreakerIsOpen(t *testing.T) {
+	p, numRequests := newDtSpanProcessorWithOpenCircuitBreaker(t, configuration.CircuitBreakerRetentionPolicy_Retain)
+	tracer := createTracer()
+
+	_, span := tracer.Start(context.Background(), "span")
+	span.End()
+	p.onEnd(span.(*dtSpan))
+	require.Equal(t, 1, p.spanWatchlist.len())
+
+	require.Equal(t, errCircuitBreakerOpen, p.forceFlush(context.Background()))
+	require.Zero(t, atomic.LoadInt32(numRequests))
+	require.Equal(t, 1, p.spanWatchlist.len(), "the span must be kept until the circuit breaker closes")
+
+	stats := p.stats.snapshot()
+	require.Equal(t, CircuitBreakerState_Open, stats.CircuitBreakerState)
+	require.EqualValues(t, 1, stats.ExportsSkippedCircuitOpen)
+	require.Zero(t, stats.SpansDroppedCircuitOpen)
+}
+
+func TestDtSpanProcessorDropsSpansWhileCircuitBreakerIsOpen(t *testing.T) {
+	p, numRequests := newDtSpanProcessorWithOpenCircuitBreaker(t, configuration.CircuitBreakerRetentionPolicy_Drop)
+	tracer := createTracer()
+
+	_, span := tracer.Start(context.Background(), "span")
+	span.End()
+	p.onEnd(span.(*dtSpan))
+
+	require.Equal(t, errCircuitBreakerOpen, p.forceFlush(context.Background()))
+	require.Zero(t, atomic.LoadInt32(numRequests))
+	require.Zero(t, p.spanWatchlist.len(), "the span must be dropped")
+	require.EqualValues(t, 1, p.stats.snapshot().SpansDroppedCircuitOpen)
+}
+
+func TestDtSpanProcessorKeepsSpansWhileCircuitBreakerProbes(t *testing.T) {
+	var numRequests int32
+	release := make(chan struct{})
+	testServer, serverConfig := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&numRequests, 1)
+		<-release
+	})
+	defer testServer.Close()
+
+	config := *testConfig
+	config.CircuitBreakerRetentionPolicy = configuration.CircuitBreakerRetentionPolicy_Retain
+	p := newDtSpanProcessor(&config, nil)
+	defer p.shutdown(context.Background()) //nolint:errcheck
+
+	serverConfig.CircuitBreakerFailureThreshold = 1
+	exporter := newDtSpanExporter(serverConfig, p.stats, nil).(*dtSpanExporterImpl)
+	exporter.retryPolicy = newTestRetryPolicy()
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	exporter.breaker.now = clock.Now
+	exporter.breaker.recordResult(&retryableError{err: errors.New("unexpected response code: 503")})
+	clock.Advance(time.Duration(configuration.DefaultCircuitBreakerOpenDurationMs) * time.Millisecond)
+	p.exporter = exporter
+
+	tracer := createTracer()
+	endSpan := func() {
+		_, span := tracer.Start(context.Background(), "span")
+		span.End()
+		p.onEnd(span.(*dtSpan))
+	}
+
+	// the first export probes the endpoint and is held by the server
+	endSpan()
+	probeDone := make(chan error, 1)
+	go func() {
+		probeDone <- p.sendSpansToExport(context.Background(), false, exportTypeForceFlush)
+	}()
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&numRequests) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, CircuitBreakerState_HalfOpen, exporter.breaker.getState())
+
+	// exports are skipped while the probe is in progress, the spans stay in the span watchlist
+	endSpan()
+	require.Equal(t, errCircuitBreakerOpen, p.sendSpansToExport(context.Background(), false, exportTypeForceFlush))
+	require.Equal(t, 1, p.spanWatchlist.len(), "the span must be kept until the circuit breaker closes")
+	require.EqualValues(t, 1, p.stats.snapshot().ExportsSkippedCircuitOpen, "the skipped export is counted once")
+
+	close(release)
+	select {
+	case err := <-probeDone:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the probe has not finished")
+	}
+	require.Equal(t, CircuitBreakerState_Closed, exporter.breaker.getState())
+
+	require.NoError(t, p.sendSpansToExport(context.Background(), false, exportTypeForceFlush))
+	require.EqualValues(t, 2, atomic.LoadInt32(&numRequests))
+	require.Zero(t, p.spanWatchlist.len(), "the kept span has been exported")
+
+	stats := p.stats.snapshot()
+	require.EqualValues(t, 1, stats.ExportsSkippedCircuitOpen)
+	require.Zero(t, stats.SpansDroppedCircuitOpen)
+}
@@ -0,0 +1,136 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type spanRegistryKey struct {
+	traceId trace.TraceID
+	spanId  trace.SpanID
+}
+
+// dtSpanRegistry is a side-table holding the Dynatrace metadata of spans which are created by an SDK TracerProvider
+// with a DtSpanProcessor, i.e. spans which are not wrapped by dtSpan in the context. The spans are keyed by their
+// span context, so that child spans and the DtTextMapPropagator can find the metadata of their parent span. Each
+// processor has a registry of its own, since several DtSpanProcessors may be registered with the same SDK
+// TracerProvider, each of them tracking the same span with its own metadata.
+type dtSpanRegistry struct {
+	spans map[spanRegistryKey]*dtSpan
+	lock  sync.RWMutex
+}
+
+func newDtSpanRegistry() *dtSpanRegistry {
+	return &dtSpanRegistry{
+		spans: make(map[spanRegistryKey]*dtSpan),
+	}
+}
+
+func newSpanRegistryKey(spanCtx trace.SpanContext) spanRegistryKey {
+	return spanRegistryKey{traceId: spanCtx.TraceID(), spanId: spanCtx.SpanID()}
+}
+
+func (r *dtSpanRegistry) add(s *dtSpan) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.spans[newSpanRegistryKey(s.Span.SpanContext())] = s
+}
+
+// get returns the span with the given span context, nil if the span is not registered.
+func (r *dtSpanRegistry) get(spanCtx trace.SpanContext) *dtSpan {
+	if !spanCtx.IsValid() || spanCtx.IsRemote() {
+		return nil
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.spans[newSpanRegistryKey(spanCtx)]
+}
+
+func (r *dtSpanRegistry) remove(spanCtx trace.SpanContext) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.spans, newSpanRegistryKey(spanCtx))
+}
+
+// clear removes all spans, e.g. spans which have not been ended when the processor is shut down.
+func (r *dtSpanRegistry) clear() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.spans = make(map[spanRegistryKey]*dtSpan)
+}
+
+func (r *dtSpanRegistry) len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return len(r.spans)
+}
+
+// dtSpanRegistries holds the registries of all DtSpanProcessors which have not been shut down, in the order in which
+// the processors have been created.
+type dtSpanRegistries struct {
+	registries []*dtSpanRegistry
+	lock       sync.RWMutex
+}
+
+// spanRegistries is used by the DtTextMapPropagator and by DtTracerProvider, since they do not know which
+// DtSpanProcessor has created a span.
+var spanRegistries = &dtSpanRegistries{}
+
+func (r *dtSpanRegistries) register(registry *dtSpanRegistry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.registries = append(r.registries, registry)
+}
+
+func (r *dtSpanRegistries) unregister(registry *dtSpanRegistry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, registered := range r.registries {
+		if registered == registry {
+			r.registries = append(r.registries[:i:i], r.registries[i+1:]...)
+			return
+		}
+	}
+}
+
+// get returns the span with the given span context of the first registry which contains it, nil if the span is not
+// registered by any DtSpanProcessor.
+func (r *dtSpanRegistries) get(spanCtx trace.SpanContext) *dtSpan {
+	if !spanCtx.IsValid() || spanCtx.IsRemote() {
+		return nil
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, registry := range r.registries {
+		if span := registry.get(spanCtx); span != nil {
+			return span
+		}
+	}
+
+	return nil
+}
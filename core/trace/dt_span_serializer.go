@@ -15,8 +15,12 @@
 package trace
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/sdk/resource"
@@ -34,100 +38,268 @@ import (
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/version"
 )
 
+// Message size limits refer to the uncompressed size of a SpanExport message, since this is what the server enforces,
+// regardless of whether the request body is compressed. The desired size of a message is the chunk size target of
+// the serializer.
 const (
-	cMsgSizeMax  = 64 * 1024 * 1024 // 64 MB
-	cMsgSizeWarn = 1 * 1024 * 1024  // 1 MB
+	cMsgSizeMax = configuration.MaxExportChunkSizeKb * 1024 // 64 MB
 )
 
+// interval in which dropped spans are logged at most once, spans dropped in between are summed up
+const cDroppedSpansWarnInterval = time.Minute
+
 type exportData []byte
 
+// spanDropReason is the reason why a span is dropped during serialization.
+type spanDropReason int
+
+const (
+	spanDropReasonInvalid spanDropReason = iota
+	spanDropReasonTooBig
+	numSpanDropReasons
+)
+
+func (r spanDropReason) String() string {
+	switch r {
+	case spanDropReasonInvalid:
+		return "invalid"
+	case spanDropReasonTooBig:
+		return "too big"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(r))
+	}
+}
+
+// droppedSpanCounts holds the number of dropped spans per drop reason.
+type droppedSpanCounts [numSpanDropReasons]int
+
+func (c droppedSpanCounts) total() int {
+	total := 0
+	for _, count := range c {
+		total += count
+	}
+	return total
+}
+
 type dtSpanSerializer struct {
 	logger            *logger.ComponentLogger
 	tenantUUID        string
 	agentId           int64
 	qualifiedTenantId configuration.QualifiedTenantId
+	// configResource is the resource given by the configuration, it takes precedence over the resource of the spans
+	configResource *resource.Resource
+	stats          *dtStats
+	// chunkSizeTarget is the desired size of a SpanExport message in bytes, accessed atomically. It is lowered once
+	// the server rejects a message as too large.
+	chunkSizeTarget int64
+	// now returns the current time, it is replaced by tests
+	now func() time.Time
+
+	dropWarningLock sync.Mutex
+	lastDropWarning time.Time
+	// unreportedDrops and unreportedExports are the spans dropped since the previous warning and the number of
+	// exports in which they have been dropped
+	unreportedDrops   droppedSpanCounts
+	unreportedExports int
 }
 
 func newSpanSerializer(
 	tenantUUID string,
 	agentId int64,
-	qualifiedTenantId configuration.QualifiedTenantId) *dtSpanSerializer {
+	qualifiedTenantId configuration.QualifiedTenantId,
+	configResource *resource.Resource,
+	stats *dtStats,
+	chunkSizeTarget int) *dtSpanSerializer {
 	return &dtSpanSerializer{
 		logger:            logger.NewComponentLogger("SpanSerializer"),
 		tenantUUID:        tenantUUID,
 		agentId:           agentId,
 		qualifiedTenantId: qualifiedTenantId,
+		configResource:    configResource,
+		stats:             stats,
+		chunkSizeTarget:   int64(chunkSizeTarget),
+		now:               time.Now,
 	}
 }
 
+// getChunkSizeTarget returns the desired size of a SpanExport message in bytes.
+func (s *dtSpanSerializer) getChunkSizeTarget() int {
+	return int(atomic.LoadInt64(&s.chunkSizeTarget))
+}
+
+// lowerChunkSizeTarget sets the desired size of a SpanExport message to the given size if it is smaller than the
+// current one. Returns false if the current size is not larger.
+func (s *dtSpanSerializer) lowerChunkSizeTarget(size int) bool {
+	for {
+		current := atomic.LoadInt64(&s.chunkSizeTarget)
+		if int64(size) >= current {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.chunkSizeTarget, current, int64(size)) {
+			return true
+		}
+	}
+}
+
+// spanGroup is a set of spans sharing the same resource.
+type spanGroup struct {
+	resource *resource.Resource
+	spans    []*dtSpan
+}
+
 // serializeSpans serializes the spans into one or multiple SpanExport messages.
-// Uses a "Next Fit" bin-packing algorithm.
+// Spans are grouped by their resource and every group is serialized into separate SpanExport messages,
+// since a SpanExport message carries a single resource.
 // The spans are serialized in order and SpanExport messages are sent to the exportChannel.
-// If an error occurs, the error is sent to the error channel and the function returns.
-func (s *dtSpanSerializer) serializeSpans(spans dtSpanSet, exportChannel chan exportData, errorChannel chan error) {
-	exportMetaInfo, err := proto.Marshal(&protoCollectorCommon.ExportMetaInfo{
-		TimeSyncMode: protoCollectorCommon.ExportMetaInfo_NTPSync,
-	})
+// Every SpanExport message carries the given time synchronization state.
+// Serialization stops once the context is done, the first error is returned after all groups have been processed.
+func (s *dtSpanSerializer) serializeSpans(
+	ctx context.Context,
+	spans dtSpanSet,
+	metaInfo *protoCollectorCommon.ExportMetaInfo,
+	exportChannel chan<- exportData,
+) error {
+	exportMetaInfo, err := proto.Marshal(metaInfo)
 	if err != nil {
-		errorChannel <- err
-		return
+		return err
 	}
 
-	firstSpanResource, err := getFirstResource(spans)
-	if err != nil {
-		errorChannel <- err
+	var dropped droppedSpanCounts
+	defer s.recordDroppedSpans(&dropped)
+
+	groups := s.groupSpansByResource(spans, &dropped)
+
+	// A group which can not be serialized must not prevent the other groups from being exported,
+	// thus the first error is reported after all other groups have been processed.
+	var groupErr error
+	for _, group := range groups {
+		if err := s.serializeSpanGroup(ctx, group, exportMetaInfo, exportChannel, &dropped); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			s.logger.Warnf("Can not serialize %d spans: %s", len(group.spans), err)
+			if groupErr == nil {
+				groupErr = err
+			}
+		}
+	}
+
+	return groupErr
+}
+
+// recordDroppedSpans adds the spans dropped during a serialization to the total counts and logs them.
+func (s *dtSpanSerializer) recordDroppedSpans(dropped *droppedSpanCounts) {
+	if dropped.total() == 0 {
 		return
 	}
-	serializedResource, err := getSerializedResourceForSpanExport(firstSpanResource)
+
+	s.stats.recordDroppedSpans(dropped)
+
+	if warning, ok := s.droppedSpansWarning(dropped); ok {
+		s.logger.Warn(warning)
+	}
+}
+
+// droppedSpansWarning adds the spans dropped during an export to the unreported ones. Returns a warning about all
+// unreported spans per drop reason, unless the previous warning is more recent than cDroppedSpansWarnInterval.
+func (s *dtSpanSerializer) droppedSpansWarning(dropped *droppedSpanCounts) (string, bool) {
+	s.dropWarningLock.Lock()
+	defer s.dropWarningLock.Unlock()
+
+	for reason, count := range dropped {
+		s.unreportedDrops[reason] += count
+	}
+	s.unreportedExports++
+
+	now := s.now()
+	if !s.lastDropWarning.IsZero() && now.Sub(s.lastDropWarning) < cDroppedSpansWarnInterval {
+		return "", false
+	}
+
+	warning := fmt.Sprintf("Dropped %d spans during serialization in %d exports (%s: %d, %s: %d)",
+		s.unreportedDrops.total(), s.unreportedExports, spanDropReasonInvalid, s.unreportedDrops[spanDropReasonInvalid],
+		spanDropReasonTooBig, s.unreportedDrops[spanDropReasonTooBig])
+	s.lastDropWarning = now
+	s.unreportedDrops = droppedSpanCounts{}
+	s.unreportedExports = 0
+	return warning, true
+}
+
+// serializeSpanGroup serializes spans sharing the same resource into one or multiple SpanExport messages.
+// Uses a "Next Fit" bin-packing algorithm.
+// Spans which can not be serialized are dropped and counted in dropped.
+func (s *dtSpanSerializer) serializeSpanGroup(
+	ctx context.Context,
+	group spanGroup,
+	exportMetaInfo []byte,
+	exportChannel chan<- exportData,
+	dropped *droppedSpanCounts,
+) error {
+	serializedResource, err := getSerializedResourceForSpanExport(group.resource, s.configResource)
 	if err != nil {
-		errorChannel <- err
-		return
+		return err
 	}
 
-	spanExport := &protoCollectorTraces.SpanExport{
-		TenantUUID:     s.tenantUUID,
-		AgentId:        s.agentId,
-		ExportMetaInfo: exportMetaInfo,
-		Resource:       serializedResource,
+	newSpanExport := func() *protoCollectorTraces.SpanExport {
+		return &protoCollectorTraces.SpanExport{
+			TenantUUID:     s.tenantUUID,
+			AgentId:        s.agentId,
+			ExportMetaInfo: exportMetaInfo,
+			Resource:       serializedResource,
+		}
 	}
 
+	spanExport := newSpanExport()
 	spanlessMsgSize := proto.Size(spanExport)
 
 	s.logger.Debugf("spanless message size: %v", spanlessMsgSize)
 
 	if spanlessMsgSize > cMsgSizeMax {
-		err = fmt.Errorf("resource too big (%v), cannot export any spans", spanlessMsgSize)
-		errorChannel <- err
-		return
+		return fmt.Errorf("resource too big (%v), cannot export any spans", spanlessMsgSize)
 	}
 
 	sizeSoFar := spanlessMsgSize
+	chunkSizeTarget := s.getChunkSizeTarget()
 
-	agSpanEnvelopes := make([]*protoCollectorTraces.ActiveGateSpanEnvelope, 0, len(spans))
+	agSpanEnvelopes := make([]*protoCollectorTraces.ActiveGateSpanEnvelope, 0, len(group.spans))
 
 	export := func(exp *protoCollectorTraces.SpanExport) error {
 		serializedExport, err := proto.Marshal(exp)
 		if err != nil {
 			return err
 		}
-		exportChannel <- serializedExport
-		return nil
+
+		select {
+		case exportChannel <- serializedExport:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
-	for span := range spans {
+	for _, span := range group.spans {
+		if span.metadata == nil || span.metadata.fw4Tag == nil {
+			s.logger.Debug("Dropping span without metadata")
+			dropped[spanDropReasonInvalid]++
+			continue
+		}
+
 		fw4Tag := span.metadata.fw4Tag
 		customTag := getProtoCustomTag(fw4Tag.CustomBlob)
 
+		// A span which can not be serialized must not prevent the other spans from being exported
 		spanMsg, err := createProtoSpan(span, customTag, s.qualifiedTenantId)
 		if err != nil {
-			errorChannel <- err
-			return
+			s.logger.Debugf("Dropping span which can not be serialized: %s", err)
+			dropped[spanDropReasonInvalid]++
+			continue
 		}
 
 		serializedClusterSpanEnvelope, err := createSerializedClusterSpanEnvelope(spanMsg, customTag, int32(fw4Tag.PathInfo))
 		if err != nil {
-			errorChannel <- err
-			return
+			s.logger.Debugf("Dropping span which can not be serialized: %s", err)
+			dropped[spanDropReasonInvalid]++
+			continue
 		}
 
 		agSpanEnvelope := createAgSpanEnvelope(serializedClusterSpanEnvelope, int64(fw4Tag.ServerID), spanMsg.TraceId)
@@ -136,11 +308,12 @@ func (s *dtSpanSerializer) serializeSpans(spans dtSpanSet, exportChannel chan ex
 		// encoding the size of the cluster envelope.
 		estimatedEnvelopeSize := proto.Size(agSpanEnvelope) + 1 + 4
 
-		if sizeSoFar+estimatedEnvelopeSize > cMsgSizeWarn {
+		if sizeSoFar+estimatedEnvelopeSize > chunkSizeTarget {
 			if minSize := spanlessMsgSize + estimatedEnvelopeSize; minSize > cMsgSizeMax {
 				// DROP: The size of this span + export msg is too big to ever fit, so we drop this span altogether
 				// and try the next span
-				s.logger.Warnf("span too big (%v), dropping", minSize)
+				s.logger.Debugf("Dropping span which is too big (%v)", minSize)
+				dropped[spanDropReasonTooBig]++
 				continue
 			}
 
@@ -151,17 +324,11 @@ func (s *dtSpanSerializer) serializeSpans(spans dtSpanSet, exportChannel chan ex
 
 				// export the previous spanExport
 				if err := export(spanExport); err != nil {
-					errorChannel <- err
-					return
+					return err
 				}
 
 				// Create a new SpanExport in which to fit the overhanging span
-				spanExport = &protoCollectorTraces.SpanExport{
-					TenantUUID:     s.tenantUUID,
-					AgentId:        s.agentId,
-					ExportMetaInfo: exportMetaInfo,
-					Resource:       serializedResource,
-				}
+				spanExport = newSpanExport()
 				agSpanEnvelopes = make([]*protoCollectorTraces.ActiveGateSpanEnvelope, 0)
 				sizeSoFar = spanlessMsgSize
 			}
@@ -173,21 +340,103 @@ func (s *dtSpanSerializer) serializeSpans(spans dtSpanSet, exportChannel chan ex
 		spanExport.Spans = agSpanEnvelopes
 	}
 
-	if err := export(spanExport); err != nil {
-		errorChannel <- err
-		return
+	if len(agSpanEnvelopes) == 0 {
+		// all spans of the group have been dropped
+		return nil
 	}
+
+	return export(spanExport)
 }
 
-func getFirstResource(spans dtSpanSet) (*resource.Resource, error) {
+// splitSpanExport splits a serialized SpanExport message into two messages which carry half of its spans each and
+// the same resource and meta info. No messages are returned if it carries less than two spans.
+func splitSpanExport(data exportData) (exportData, exportData, int, error) {
+	spanExport := &protoCollectorTraces.SpanExport{}
+	if err := proto.Unmarshal(data, spanExport); err != nil {
+		return nil, nil, 0, err
+	}
+
+	spans := spanExport.Spans
+	if len(spans) < 2 {
+		return nil, nil, len(spans), nil
+	}
+
+	spanExport.Spans = spans[:len(spans)/2]
+	first, err := proto.Marshal(spanExport)
+	if err != nil {
+		return nil, nil, len(spans), err
+	}
+
+	spanExport.Spans = spans[len(spans)/2:]
+	second, err := proto.Marshal(spanExport)
+	if err != nil {
+		return nil, nil, len(spans), err
+	}
+
+	return first, second, len(spans), nil
+}
+
+// oldestSpanEndTime returns the earliest end time of the spans in a serialized SpanExport message. Spans which have not
+// ended, i.e. updates of open spans, are as old as the message, so the given time of its creation is used for them. It
+// is also returned if the message can not be decoded.
+func oldestSpanEndTime(data exportData, createdAt time.Time) time.Time {
+	oldest := createdAt
+
+	spanExport := &protoCollectorTraces.SpanExport{}
+	if err := proto.Unmarshal(data, spanExport); err != nil {
+		return oldest
+	}
+
+	for _, agSpanEnvelope := range spanExport.Spans {
+		clusterSpanEnvelope := &protoCollectorTraces.ClusterSpanEnvelope{}
+		if err := proto.Unmarshal(agSpanEnvelope.ClusterSpanEnvelope, clusterSpanEnvelope); err != nil {
+			continue
+		}
+
+		spanContainer := &protoCollectorTraces.SpanContainer{}
+		if err := proto.Unmarshal(clusterSpanEnvelope.SpanContainer, spanContainer); err != nil {
+			continue
+		}
+
+		for _, span := range spanContainer.Spans {
+			if span.EndTimeUnixnano == 0 {
+				continue
+			}
+			if endTime := time.Unix(0, int64(span.EndTimeUnixnano)); endTime.Before(oldest) {
+				oldest = endTime
+			}
+		}
+	}
+
+	return oldest
+}
+
+// groupSpansByResource partitions the spans by the identity of their resource, i.e. by the set of resource attributes.
+// Spans without access to their resource are dropped and counted in dropped.
+func (s *dtSpanSerializer) groupSpansByResource(spans dtSpanSet, dropped *droppedSpanCounts) []spanGroup {
+	var groups []spanGroup
+	groupIndex := make(map[attribute.Distinct]int)
+
 	for span := range spans {
 		readOnlySpan, err := span.readOnlySpan()
 		if err != nil {
-			return nil, err
+			s.logger.Debugf("Dropping span: %s", err)
+			dropped[spanDropReasonInvalid]++
+			continue
+		}
+
+		res := readOnlySpan.Resource()
+		key := res.Equivalent()
+		idx, found := groupIndex[key]
+		if !found {
+			idx = len(groups)
+			groupIndex[key] = idx
+			groups = append(groups, spanGroup{resource: res})
 		}
-		return readOnlySpan.Resource(), nil
+		groups[idx].spans = append(groups[idx].spans, span)
 	}
-	return nil, errors.New("span set is empty, can't retrieve resource")
+
+	return groups
 }
 
 func createProtoSpan(dtSpan *dtSpan, incomingCustomTag *protoTrace.CustomTag, qualifiedTenantId configuration.QualifiedTenantId) (*protoTrace.Span, error) {
@@ -280,7 +529,6 @@ func createSerializedClusterSpanEnvelope(spanMsg *protoTrace.Span, customTag *pr
 	spanContainer := protoCollectorTraces.SpanContainer{
 		Spans: []*protoTrace.Span{spanMsg},
 	}
-	// TODO we want to do this later??
 	serializedSpanContainer, err := proto.Marshal(&spanContainer)
 	if err != nil {
 		return nil, err
@@ -332,8 +580,27 @@ func createInstrumentationLibAttrs(span sdktrace.ReadOnlySpan) []*protoCommon.At
 	return []*protoCommon.AttributeKeyValue{instrumentationLibNameAttr, instrumentationLibVersionAttr}
 }
 
-func getResourceForSpanExport(spanResource *resource.Resource) (*resource.Resource, error) {
-	mergedResource, err := resource.Merge(spanResource, getExporterResource())
+// getResourceForSpanExport merges the resource of the spans with the resource given by the configuration, e.g. by
+// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES. The configured service name takes precedence, since the resource of
+// the spans always has one, the other attributes of the spans' resource take precedence, e.g. a resource which has
+// been set by the application with sdktrace.WithResource. configResource may be nil.
+func getResourceForSpanExport(spanResource *resource.Resource, configResource *resource.Resource) (*resource.Resource, error) {
+	configuredResource, err := resource.Merge(configResource, spanResource)
+	if err != nil {
+		return nil, err
+	}
+
+	if configResource != nil {
+		if serviceName, found := configResource.Set().Value(semconv.ServiceName); found {
+			configuredResource, err = resource.Merge(configuredResource,
+				resource.NewSchemaless(attribute.KeyValue{Key: semconv.ServiceName, Value: serviceName}))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	mergedResource, err := resource.Merge(configuredResource, getExporterResource())
 	if err != nil {
 		return nil, err
 	}
@@ -349,8 +616,8 @@ func getResourceForSpanExport(spanResource *resource.Resource) (*resource.Resour
 	return mergedResourceWithDefaults, nil
 }
 
-func getSerializedResourceForSpanExport(spanResource *resource.Resource) ([]byte, error) {
-	resourceForExport, err := getResourceForSpanExport(spanResource)
+func getSerializedResourceForSpanExport(spanResource *resource.Resource, configResource *resource.Resource) ([]byte, error) {
+	resourceForExport, err := getResourceForSpanExport(spanResource, configResource)
 	if err != nil {
 		return nil, err
 	}
@@ -246,14 +246,12 @@ func getProtoLinks(links []sdktrace.Link, qualifiedTenantId configuration.Qualif
 			DroppedAttributesCount: uint32(link.DroppedAttributeCount),
 		}
 
+		// A remote link without a matching FW4 tag is still exported, just without the encoded link ID
 		if spanContext.IsRemote() {
-			fw4Tag, err := fw4.GetMatchingFw4FromTracestate(spanContext.TraceState(), qualifiedTenantId)
-			if err != nil {
-				return nil, err
+			if fw4Tag, err := fw4.GetMatchingFw4FromTracestate(spanContext.TraceState(), qualifiedTenantId); err == nil {
+				encodedLinkID := fw4Tag.EncodedLinkID()
+				protoLink.FwtagEncodedLinkId = &encodedLinkID
 			}
-
-			encodedLinkID := fw4Tag.EncodedLinkID()
-			protoLink.FwtagEncodedLinkId = &encodedLinkID
 		}
 
 		protoLinks = append(protoLinks, protoLink)
@@ -26,10 +26,13 @@ import (
 	sdkresource "go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/fw4"
+	protoCollectorCommon "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/collector/common/v1"
 	protoCollectorTraces "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/collector/traces/v1"
+	protoResource "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/resource/v1"
 	protoTrace "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/trace/v1"
 	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/semconv"
 )
@@ -97,32 +100,222 @@ func TestCreateProtoSpan(t *testing.T) {
 	))
 }
 
-func TestGetFirstResource(t *testing.T) {
+func TestGroupSpansByResource(t *testing.T) {
 	tracer := createTracer().(*dtTracer)
 	_, span1 := tracer.Start(context.Background(), "span1")
 	_, span2 := tracer.Start(context.Background(), "span2")
+
+	otherTracer := createTracer(sdktrace.WithResource(sdkresource.NewSchemaless(attribute.String("key", "value"))))
+	_, span3 := otherTracer.Start(context.Background(), "span3")
+
+	// an equal resource from another provider belongs to the same group
+	equalResourceTracer := createTracer(sdktrace.WithResource(sdkresource.NewSchemaless(attribute.String("key", "value"))))
+	_, span4 := equalResourceTracer.Start(context.Background(), "span4")
+
+	var dropped droppedSpanCounts
+	serializer := newSpanSerializer(testConfig.Tenant, testConfig.AgentId, testConfig.QualifiedTenantId(), nil, newDtStats(), configuration.DefaultExportChunkSizeKb*1024)
+	groups := serializer.groupSpansByResource(makeSpanSet(span1, span2, span3, span4), &dropped)
+	require.Len(t, groups, 2)
+	require.Zero(t, dropped.total())
+
+	for _, group := range groups {
+		require.Len(t, group.spans, 2)
+		for _, span := range group.spans {
+			require.True(t, group.resource.Equal(span.Span.(sdktrace.ReadOnlySpan).Resource()))
+		}
+	}
+}
+
+func TestGroupSpansByResource_EmptySet(t *testing.T) {
+	var dropped droppedSpanCounts
+	serializer := newSpanSerializer(testConfig.Tenant, testConfig.AgentId, testConfig.QualifiedTenantId(), nil, newDtStats(), configuration.DefaultExportChunkSizeKb*1024)
+	groups := serializer.groupSpansByResource(make(dtSpanSet), &dropped)
+	require.Empty(t, groups)
+}
+
+func TestGroupSpansByResource_DropsNonReadOnlySpans(t *testing.T) {
+	tracer := createTracer()
+	_, span := tracer.Start(context.Background(), "span")
+
+	_, noopSpan := trace.NewNoopTracerProvider().Tracer("test").Start(context.Background(), "noop span")
+	invalidSpan := &dtSpan{Span: noopSpan, metadata: newDtSpanMetadata(123)}
+
+	var dropped droppedSpanCounts
+	serializer := newSpanSerializer(testConfig.Tenant, testConfig.AgentId, testConfig.QualifiedTenantId(), nil, newDtStats(), configuration.DefaultExportChunkSizeKb*1024)
+	groups := serializer.groupSpansByResource(makeSpanSet(span, invalidSpan), &dropped)
+	require.Len(t, groups, 1)
+	require.Len(t, groups[0].spans, 1)
+	require.Equal(t, 1, dropped[spanDropReasonInvalid])
+}
+
+func TestSerializeSpansDropsInvalidSpans(t *testing.T) {
+	tracer := createTracer()
+	_, span1 := tracer.Start(context.Background(), "span1")
 	_, span3 := tracer.Start(context.Background(), "span3")
 
-	spans := make(dtSpanSet)
-	spans[span1.(*dtSpan)] = struct{}{}
-	spans[span2.(*dtSpan)] = struct{}{}
-	spans[span3.(*dtSpan)] = struct{}{}
-	resource, err := getFirstResource(spans)
+	// a span without metadata can not be serialized, but must not prevent the other spans from being exported
+	_, sdkSpan := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "span2")
+	span2 := &dtSpan{Span: sdkSpan}
+
+	serializer := newSpanSerializer(testConfig.Tenant, testConfig.AgentId, testConfig.QualifiedTenantId(), nil, newDtStats(), configuration.DefaultExportChunkSizeKb*1024)
+	exports := serializeSpansWithSerializerForTest(t, serializer, makeSpanSet(span1, span2, span3))
+	require.Len(t, exports, 1)
+	require.Len(t, exports[0].Spans, 2)
+
+	stats := serializer.stats.snapshot()
+	require.EqualValues(t, 1, stats.SpansDroppedInvalid)
+	require.Zero(t, stats.SpansDroppedTooBig)
+}
+
+func TestDroppedSpansWarningIsRateLimited(t *testing.T) {
+	serializer := newSpanSerializer(testConfig.Tenant, testConfig.AgentId, testConfig.QualifiedTenantId(), nil, newDtStats(), configuration.DefaultExportChunkSizeKb*1024)
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	serializer.now = clock.Now
+
+	warning, ok := serializer.droppedSpansWarning(&droppedSpanCounts{spanDropReasonInvalid: 2})
+	require.True(t, ok)
+	require.Equal(t, "Dropped 2 spans during serialization in 1 exports (invalid: 2, too big: 0)", warning)
+
+	// spans dropped within the interval are summed up until the next warning
+	clock.Advance(cDroppedSpansWarnInterval / 2)
+	_, ok = serializer.droppedSpansWarning(&droppedSpanCounts{spanDropReasonTooBig: 1})
+	require.False(t, ok)
+	_, ok = serializer.droppedSpansWarning(&droppedSpanCounts{spanDropReasonInvalid: 1, spanDropReasonTooBig: 2})
+	require.False(t, ok)
+
+	clock.Advance(cDroppedSpansWarnInterval / 2)
+	warning, ok = serializer.droppedSpansWarning(&droppedSpanCounts{spanDropReasonInvalid: 1})
+	require.True(t, ok)
+	require.Equal(t, "Dropped 5 spans during serialization in 3 exports (invalid: 2, too big: 3)", warning)
+}
+
+func TestSpanDropReasonString(t *testing.T) {
+	require.Equal(t, "invalid", spanDropReasonInvalid.String())
+	require.Equal(t, "too big", spanDropReasonTooBig.String())
+	require.Equal(t, "unknown (42)", spanDropReason(42).String())
+}
+
+func TestSerializeSpansGroupedByResource(t *testing.T) {
+	tracer := createTracer(sdktrace.WithResource(sdkresource.NewSchemaless(attribute.String("service", "a"))))
+	_, span1 := tracer.Start(context.Background(), "span1")
+	_, span2 := tracer.Start(context.Background(), "span2")
+
+	otherTracer := createTracer(sdktrace.WithResource(sdkresource.NewSchemaless(attribute.String("service", "b"))))
+	_, span3 := otherTracer.Start(context.Background(), "span3")
+
+	exports := serializeSpansForTest(t, makeSpanSet(span1, span2, span3))
+	require.Len(t, exports, 2, "spans with different resources must be exported in separate SpanExport messages")
+
+	numSpansByService := make(map[string]int)
+	for _, export := range exports {
+		res := &protoResource.Resource{}
+		require.NoError(t, proto.Unmarshal(export.Resource, res))
+		for _, attr := range res.Attributes {
+			if attr.Key == "service" {
+				numSpansByService[attr.StringValue] += len(export.Spans)
+			}
+		}
+	}
+
+	require.Equal(t, map[string]int{"a": 2, "b": 1}, numSpansByService)
+}
+
+func TestSplitSpanExport(t *testing.T) {
+	tracer := createTracer()
+	spans := make([]trace.Span, 0, 5)
+	for i := 0; i < 5; i++ {
+		_, span := tracer.Start(context.Background(), "span")
+		spans = append(spans, span)
+	}
+
+	exports := serializeSpansForTest(t, makeSpanSet(spans...))
+	require.Len(t, exports, 1)
+	data, err := proto.Marshal(exports[0])
+	require.NoError(t, err)
+
+	first, second, numSpans, err := splitSpanExport(data)
 	require.NoError(t, err)
-	require.NotNil(t, resource)
-	require.Same(t, resource, span1.(*dtSpan).Span.(sdktrace.ReadOnlySpan).Resource())
+	require.Equal(t, 5, numSpans)
+
+	for i, half := range []exportData{first, second} {
+		spanExport := &protoCollectorTraces.SpanExport{}
+		require.NoError(t, proto.Unmarshal(half, spanExport))
+		require.Len(t, spanExport.Spans, 2+i)
+		require.Equal(t, exports[0].Resource, spanExport.Resource)
+		require.Equal(t, exports[0].ExportMetaInfo, spanExport.ExportMetaInfo)
+	}
+
+	single, err := proto.Marshal(&protoCollectorTraces.SpanExport{Spans: exports[0].Spans[:1]})
+	require.NoError(t, err)
+	first, second, numSpans, err = splitSpanExport(single)
+	require.NoError(t, err)
+	require.Equal(t, 1, numSpans)
+	require.Nil(t, first)
+	require.Nil(t, second)
 }
 
-func TestGetFirstResource_FailsIfSetEmpty(t *testing.T) {
-	resource, err := getFirstResource(make(dtSpanSet))
-	require.Error(t, err)
-	require.Nil(t, resource)
+func TestOldestSpanEndTime(t *testing.T) {
+	createdAt := time.Now()
+	endTime := createdAt.Add(-time.Minute)
+	tracer := createTracer()
+	_, ended := tracer.Start(context.Background(), "ended", trace.WithTimestamp(endTime.Add(-time.Second)))
+	ended.End(trace.WithTimestamp(endTime))
+	// the span watchlist marks ended spans like this before they are exported
+	ended.(*dtSpan).metadata.sendState = sendStateSpanEnded
+	_, open := tracer.Start(context.Background(), "open", trace.WithTimestamp(endTime.Add(-time.Hour)))
+	defer open.End()
+
+	exports := serializeSpansForTest(t, makeSpanSet(ended, open))
+	require.Len(t, exports, 1)
+	data, err := proto.Marshal(exports[0])
+	require.NoError(t, err)
+
+	require.True(t, endTime.Equal(oldestSpanEndTime(data, createdAt)), "the open span has not ended yet")
+	require.True(t, createdAt.Equal(oldestSpanEndTime(exportData{0xff}, createdAt)))
+}
+
+func TestLowerChunkSizeTarget(t *testing.T) {
+	serializer := newSpanSerializer(testConfig.Tenant, testConfig.AgentId, testConfig.QualifiedTenantId(), nil, newDtStats(), 1000)
+	require.True(t, serializer.lowerChunkSizeTarget(500))
+	require.False(t, serializer.lowerChunkSizeTarget(800), "the chunk size target is never raised")
+	require.Equal(t, 500, serializer.getChunkSizeTarget())
+}
+
+// serializeSpansForTest serializes the given spans and returns all SpanExport messages.
+func serializeSpansForTest(t *testing.T, spans dtSpanSet) []*protoCollectorTraces.SpanExport {
+	serializer := newSpanSerializer(testConfig.Tenant, testConfig.AgentId, testConfig.QualifiedTenantId(), nil, newDtStats(), configuration.DefaultExportChunkSizeKb*1024)
+	return serializeSpansWithSerializerForTest(t, serializer, spans)
+}
+
+func serializeSpansWithSerializerForTest(
+	t *testing.T,
+	serializer *dtSpanSerializer,
+	spans dtSpanSet,
+) []*protoCollectorTraces.SpanExport {
+	exportChannel := make(chan exportData)
+	errorChannel := make(chan error, 1)
+
+	go func() {
+		metaInfo := &protoCollectorCommon.ExportMetaInfo{TimeSyncMode: protoCollectorCommon.ExportMetaInfo_NTPSync}
+		errorChannel <- serializer.serializeSpans(context.Background(), spans, metaInfo, exportChannel)
+		close(exportChannel)
+	}()
+
+	var exports []*protoCollectorTraces.SpanExport
+	for data := range exportChannel {
+		export := &protoCollectorTraces.SpanExport{}
+		require.NoError(t, proto.Unmarshal(data, export))
+		exports = append(exports, export)
+	}
+
+	require.NoError(t, <-errorChannel)
+	return exports
 }
 
 func TestGetResourceForSpanExport(t *testing.T) {
 	spanResource := sdkresource.NewSchemaless(attribute.String("key", "value"))
 
-	exportResource, err := getResourceForSpanExport(spanResource)
+	exportResource, err := getResourceForSpanExport(spanResource, nil)
 	require.NoError(t, err)
 
 	exportResourceAttributes := exportResource.Attributes()
@@ -152,7 +345,7 @@ func TestGetResourceForSpanExport_SpanResourceTakesPrecedence(t *testing.T) {
 		attribute.String("key", "value"),
 		attribute.String("telemetry.sdk.language", "test_sdk_language"),
 	)
-	exportResource, err := getResourceForSpanExport(spanResource)
+	exportResource, err := getResourceForSpanExport(spanResource, nil)
 	require.NoError(t, err)
 
 	exportResourceAttributes := exportResource.Attributes()
@@ -416,3 +609,53 @@ func TestLinksWithoutFw4TagGetNoLinkId(t *testing.T) {
 		require.Nil(t, protoLink.FwtagEncodedLinkId)
 	}
 }
+
+func TestRemoteLinksWithoutMatchingFw4TagGetNoLinkId(t *testing.T) {
+	traceState, err := trace.ParseTraceState("vendor=value")
+	require.NoError(t, err)
+
+	links := []sdktrace.Link{
+		{
+			SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8},
+				SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+				TraceState: traceState,
+				Remote:     true,
+			}),
+		},
+	}
+
+	protoLinks, err := getProtoLinks(links, configuration.QualifiedTenantId{TenantId: 0, ClusterId: 0})
+	require.NoError(t, err, "a remote link without matching FW4 tag must not fail the span")
+	require.Len(t, protoLinks, 1)
+	require.Nil(t, protoLinks[0].FwtagEncodedLinkId)
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0, 0, 0, 0, 0, 0, 0, 0}, protoLinks[0].TraceId)
+}
+
+func TestSerializeSpansSendsDroppedUpdateForTimedOutSpans(t *testing.T) {
+	tp, _ := newDtTracerProviderWithTestExporter()
+	tr := tp.Tracer("Dynatrace Tracer")
+
+	_, span := tr.Start(context.Background(), "open span")
+	s := span.(*dtSpan)
+
+	// the span has been sent as new before and exceeds the open span timeout now
+	require.Equal(t, prepareResultSend, s.prepareSend(s.metadata.firstSeenMs+s.metadata.options.updateIntervalMs))
+	s.metadata.firstSeenMs -= s.metadata.options.openSpanTimeoutMs
+
+	spans := tp.processor.spanWatchlist.getSpansToExport()
+	require.Contains(t, spans, s)
+	require.False(t, tp.processor.spanWatchlist.contains(s), "a dropped span must no longer be tracked")
+
+	exports := serializeSpansForTest(t, spans)
+	require.Len(t, exports, 1)
+	require.Len(t, exports[0].Spans, 1)
+
+	envelope := &protoCollectorTraces.ClusterSpanEnvelope{}
+	require.NoError(t, proto.Unmarshal(exports[0].Spans[0].ClusterSpanEnvelope, envelope))
+	container := &protoCollectorTraces.SpanContainer{}
+	require.NoError(t, proto.Unmarshal(envelope.SpanContainer, container))
+	require.Len(t, container.Spans, 1)
+	require.Equal(t, protoTrace.Span_Dropped, container.Spans[0].SendReason)
+	require.EqualValues(t, 1, container.Spans[0].UpdateSequenceNo, "the drop must be sent as a new update")
+}
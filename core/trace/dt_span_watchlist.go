@@ -15,43 +15,141 @@
 package trace
 
 import (
+	"container/list"
 	"sync"
 	"time"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
 )
 
 type dtSpanSet map[*dtSpan]struct{}
 
 type dtSpanWatchlist struct {
-	spans    dtSpanSet
-	lock     sync.Mutex
-	maxSpans int
+	spans dtSpanSet
+	// evictedSpans are exported as dropped with the next export operation, at most maxSpans spans are evicted until then
+	evictedSpans dtSpanSet
+	// openSpans holds the spans which have been open when they have been added, oldest first, so that the oldest open
+	// span is evicted without searching for it. Spans which have ended in the meantime are removed once they are found
+	// at the front of the list.
+	openSpans        *list.List
+	openSpanElements map[*dtSpan]*list.Element
+	lock             sync.Mutex
+	maxSpans         int
+	overflowPolicy   configuration.SpanWatchlistOverflowPolicy
+	stats            *dtStats
+	// spanRegistry is the registry of the processor, spans which are dropped without being ended are removed from it
+	spanRegistry *dtSpanRegistry
 }
 
-func newDtSpanWatchlist(watchlistSize int) dtSpanWatchlist {
+func newDtSpanWatchlist(
+	watchlistSize int,
+	overflowPolicy configuration.SpanWatchlistOverflowPolicy,
+	stats *dtStats,
+) dtSpanWatchlist {
 	return dtSpanWatchlist{
-		spans:    make(dtSpanSet),
-		lock:     sync.Mutex{},
-		maxSpans: watchlistSize,
+		spans:            make(dtSpanSet),
+		evictedSpans:     make(dtSpanSet),
+		openSpans:        list.New(),
+		openSpanElements: make(map[*dtSpan]*list.Element),
+		lock:             sync.Mutex{},
+		maxSpans:         watchlistSize,
+		overflowPolicy:   overflowPolicy,
+		stats:            stats,
 	}
 }
 
+// add adds the span to the watchlist. If the watchlist is full and the overflow policy allows it, the oldest open
+// span is evicted to make room for the given span. Returns false if the span could not be added.
 func (p *dtSpanWatchlist) add(s *dtSpan) bool {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
 	if len(p.spans) >= p.maxSpans {
-		return false
+		if p.overflowPolicy != configuration.SpanWatchlistOverflowPolicy_EvictOldest || !p.evictOldestOpenSpan() {
+			return false
+		}
 	}
 
 	p.spans[s] = struct{}{}
+	if isOpenSpan(s) {
+		p.openSpanElements[s] = p.openSpans.PushBack(s)
+	}
 	return true
 }
 
+// evictOldestOpenSpan removes the open span which has been started first from the watchlist. The evicted span is
+// exported as dropped with the next export operation. Must be called with lock held.
+// Returns false if there is no open span in the watchlist, ended spans are never evicted, or if the maximum number of
+// evicted spans has been reached.
+func (p *dtSpanWatchlist) evictOldestOpenSpan() bool {
+	if len(p.evictedSpans) >= p.maxSpans {
+		return false
+	}
+
+	for element := p.openSpans.Front(); element != nil; element = p.openSpans.Front() {
+		oldest := element.Value.(*dtSpan)
+		p.removeOpenSpan(oldest)
+
+		// a span which has ended is never evicted, thus it does not need to be kept in the list
+		if !isOpenSpan(oldest) {
+			continue
+		}
+
+		delete(p.spans, oldest)
+		oldest.metadata.evicted = true
+		p.evictedSpans[oldest] = struct{}{}
+		p.stats.recordSpanEvicted()
+		return true
+	}
+
+	return false
+}
+
+// removeOpenSpan removes the span from the list of open spans. Must be called with lock held.
+func (p *dtSpanWatchlist) removeOpenSpan(s *dtSpan) {
+	if element, found := p.openSpanElements[s]; found {
+		p.openSpans.Remove(element)
+		delete(p.openSpanElements, s)
+	}
+}
+
+// isOpenSpan reports whether the span has not ended yet.
+func isOpenSpan(s *dtSpan) bool {
+	readOnlySpan, err := s.readOnlySpan()
+	return err == nil && readOnlySpan.EndTime().IsZero()
+}
+
+// isEvictionFull reports whether the maximum number of evicted spans has been reached, no more spans are evicted
+// until they have been exported.
+func (p *dtSpanWatchlist) isEvictionFull() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.evictedSpans) >= p.maxSpans
+}
+
+// isEvicted reports whether the span has been evicted from the watchlist, such a span must not be added again.
+func (p *dtSpanWatchlist) isEvicted(s *dtSpan) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return s.metadata.evicted
+}
+
+// isFull reports whether no more spans can be added without evicting another one.
+func (p *dtSpanWatchlist) isFull() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.spans) >= p.maxSpans
+}
+
 func (p *dtSpanWatchlist) remove(s *dtSpan) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
 	delete(p.spans, s)
+	p.removeOpenSpan(s)
 }
 
 func (p *dtSpanWatchlist) contains(s *dtSpan) bool {
@@ -77,9 +175,14 @@ func (p *dtSpanWatchlist) getSpansToExport() dtSpanSet {
 	for k, v := range p.spans {
 		spansToExport[k] = v
 	}
+	evictedSpans := p.evictedSpans
+	p.evictedSpans = make(dtSpanSet)
 	p.lock.Unlock()
 
 	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for span := range evictedSpans {
+		span.prepareDrop(now)
+	}
 	for span := range spansToExport {
 		prepareResult := span.prepareSend(now)
 
@@ -87,11 +190,24 @@ func (p *dtSpanWatchlist) getSpansToExport() dtSpanSet {
 			delete(spansToExport, span)
 		}
 
+		// spans exceeding the open span timeout are exported as dropped once and are no longer tracked afterwards
+		if prepareResult == prepareResultDrop {
+			p.stats.recordSpanDroppedOpenSpanTimeout()
+			// the span may never be ended, so it must not be kept in the registry of a DtSpanProcessor
+			if p.spanRegistry != nil {
+				p.spanRegistry.remove(span.Span.SpanContext())
+			}
+		}
+
 		if prepareResult == prepareResultDrop ||
 			(prepareResult == prepareResultSend && span.metadata.sendState == sendStateSpanEnded) {
 			p.remove(span)
 		}
 	}
 
+	for span := range evictedSpans {
+		spansToExport[span] = struct{}{}
+	}
+
 	return spansToExport
 }
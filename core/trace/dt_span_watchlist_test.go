@@ -17,6 +17,7 @@ package trace
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -81,3 +82,146 @@ func TestSpanWatchlistSpansToExport(t *testing.T) {
 	spans := tp.processor.spanWatchlist.getSpansToExport()
 	require.Equal(t, len(spans), numSpans)
 }
+
+// newDtTracerProviderWithWatchlist creates a Dynatrace Tracer Provider with testExporter and the given span watchlist configuration
+func newDtTracerProviderWithWatchlist(
+	t *testing.T,
+	size int,
+	overflowPolicy configuration.SpanWatchlistOverflowPolicy,
+) *DtTracerProvider {
+	config := *testConfig
+	config.SpanWatchlistSize = size
+	config.SpanWatchlistOverflowPolicy = overflowPolicy
+
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(&config))
+	require.NoError(t, err)
+	tp.processor.exporter = newTestExporter(testExporterOptions{
+		iterationIntervalMs: 10,
+		numIterations:       1,
+	})
+
+	return tp
+}
+
+func TestSpanWatchlistOverflowPolicyReject(t *testing.T) {
+	tp := newDtTracerProviderWithWatchlist(t, 2, configuration.SpanWatchlistOverflowPolicy_Reject)
+	tr := tp.Tracer("Dynatrace Tracer")
+
+	generateSpans(tr, spanGeneratorOptions{
+		numSpans:   3,
+		endedSpans: true,
+	})
+
+	require.Equal(t, 2, tp.processor.spanWatchlist.len())
+	require.EqualValues(t, 1, tp.Stats().SpansRejected)
+	require.Zero(t, tp.Stats().SpansEvicted)
+}
+
+func TestSpanWatchlistOverflowPolicyEvictOldest(t *testing.T) {
+	tp := newDtTracerProviderWithWatchlist(t, 2, configuration.SpanWatchlistOverflowPolicy_EvictOldest)
+	tr := tp.Tracer("Dynatrace Tracer")
+
+	_, spanA := tr.Start(context.Background(), "Span A")
+	_, spanB := tr.Start(context.Background(), "Span B")
+	spanB.End()
+
+	// span B is ended, thus span A is the oldest open span
+	_, spanC := tr.Start(context.Background(), "Span C")
+	require.False(t, tp.processor.spanWatchlist.contains(spanA.(*dtSpan)))
+	require.True(t, tp.processor.spanWatchlist.contains(spanB.(*dtSpan)))
+	require.True(t, tp.processor.spanWatchlist.contains(spanC.(*dtSpan)))
+	require.EqualValues(t, 1, tp.Stats().SpansEvicted)
+
+	// an evicted span is not tracked again when it ends
+	spanA.End()
+	require.False(t, tp.processor.spanWatchlist.contains(spanA.(*dtSpan)))
+	require.Zero(t, tp.Stats().SpansRejected)
+
+	// the evicted span is exported as dropped once
+	spans := tp.processor.spanWatchlist.getSpansToExport()
+	require.Contains(t, spans, spanA.(*dtSpan))
+	require.Equal(t, sendStateDrop, spanA.(*dtSpan).metadata.sendState)
+	require.NotContains(t, tp.processor.spanWatchlist.getSpansToExport(), spanA.(*dtSpan))
+}
+
+func TestSpanWatchlistOverflowPolicyEvictOldestLimitsEvictedSpans(t *testing.T) {
+	tp := newDtTracerProviderWithWatchlist(t, 2, configuration.SpanWatchlistOverflowPolicy_EvictOldest)
+	watchlist := &tp.processor.spanWatchlist
+	tr := tp.Tracer("Dynatrace Tracer")
+
+	// the spans are evicted in the order they have been started
+	_, spanA := tr.Start(context.Background(), "Span A")
+	_, spanB := tr.Start(context.Background(), "Span B")
+	_, spanC := tr.Start(context.Background(), "Span C")
+	require.False(t, watchlist.contains(spanA.(*dtSpan)))
+	require.True(t, watchlist.contains(spanB.(*dtSpan)))
+	_, spanD := tr.Start(context.Background(), "Span D")
+	require.False(t, watchlist.contains(spanB.(*dtSpan)))
+	require.True(t, watchlist.contains(spanC.(*dtSpan)))
+	require.True(t, watchlist.contains(spanD.(*dtSpan)))
+	require.EqualValues(t, 2, tp.Stats().SpansEvicted)
+
+	// as many spans as the watchlist holds are evicted until they have been exported, an export is requested then
+	require.True(t, watchlist.isEvictionFull())
+	require.Positive(t, tp.Stats().OverflowExports)
+	require.Eventually(t, func() bool {
+		return !watchlist.isEvictionFull()
+	}, time.Second, 10*time.Millisecond)
+
+	_, spanE := tr.Start(context.Background(), "Span E")
+	require.False(t, watchlist.contains(spanC.(*dtSpan)))
+	require.True(t, watchlist.contains(spanE.(*dtSpan)))
+	require.EqualValues(t, 3, tp.Stats().SpansEvicted)
+}
+
+func TestSpanWatchlistEvictionFull(t *testing.T) {
+	watchlist := newDtSpanWatchlist(1, configuration.SpanWatchlistOverflowPolicy_EvictOldest, newDtStats())
+	tracer := createTracer()
+	_, spanA := tracer.Start(context.Background(), "Span A")
+	_, spanB := tracer.Start(context.Background(), "Span B")
+	_, spanC := tracer.Start(context.Background(), "Span C")
+
+	require.True(t, watchlist.add(spanA.(*dtSpan)))
+	require.True(t, watchlist.add(spanB.(*dtSpan)), "span A is evicted")
+	require.True(t, watchlist.isEvictionFull())
+	require.False(t, watchlist.add(spanC.(*dtSpan)), "no more spans are evicted until the evicted ones are exported")
+
+	require.Contains(t, watchlist.getSpansToExport(), spanA.(*dtSpan))
+	require.True(t, watchlist.add(spanC.(*dtSpan)), "span B is evicted")
+}
+
+func TestSpanWatchlistOverflowPolicyEvictOldestWithoutOpenSpans(t *testing.T) {
+	tp := newDtTracerProviderWithWatchlist(t, 2, configuration.SpanWatchlistOverflowPolicy_EvictOldest)
+	tr := tp.Tracer("Dynatrace Tracer")
+
+	// ended spans are never evicted
+	generateSpans(tr, spanGeneratorOptions{
+		numSpans:   3,
+		endedSpans: true,
+	})
+
+	require.Equal(t, 2, tp.processor.spanWatchlist.len())
+	require.EqualValues(t, 1, tp.Stats().SpansRejected)
+}
+
+func TestSpanWatchlistOverflowPolicyExport(t *testing.T) {
+	tp := newDtTracerProviderWithWatchlist(t, 2, configuration.SpanWatchlistOverflowPolicy_Export)
+	tr := tp.Tracer("Dynatrace Tracer")
+
+	_, spanA := tr.Start(context.Background(), "Span A")
+	_, spanB := tr.Start(context.Background(), "Span B")
+	spanA.End()
+	spanB.End()
+
+	_, spanC := tr.Start(context.Background(), "Span C")
+
+	// the ended spans are exported without waiting for the periodic send operation
+	require.Eventually(t, func() bool {
+		return tp.processor.spanWatchlist.len() == 0
+	}, time.Second, 10*time.Millisecond)
+	require.Positive(t, tp.Stats().OverflowExports)
+
+	spanC.End()
+	require.True(t, tp.processor.spanWatchlist.contains(spanC.(*dtSpan)))
+	require.Zero(t, tp.Stats().SpansRejected)
+}
@@ -0,0 +1,253 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the self-monitoring statistics of a DtTracerProvider.
+// All counters are cumulative since the DtTracerProvider has been created.
+type Stats struct {
+	// SpansStarted is the number of recording spans that have been started.
+	SpansStarted int64
+	// SpansEnded is the number of recording spans that have been ended.
+	SpansEnded int64
+	// SpansRejected is the number of ended spans that could not be added to the span watchlist because it was full.
+	// These spans are never exported.
+	SpansRejected int64
+	// SpansEvicted is the number of open spans that have been evicted from the full span watchlist to make room for
+	// other spans. These spans are exported as dropped.
+	SpansEvicted int64
+	// OverflowExports is the number of export operations that have been triggered because the span watchlist was full.
+	OverflowExports int64
+	// SpansDroppedOpenSpanTimeout is the number of spans that have been dropped because they were not ended within
+	// the open span timeout.
+	SpansDroppedOpenSpanTimeout int64
+	// SpansDroppedTooBig is the number of spans that have been dropped because their serialized size exceeds the
+	// maximum size of a span export request.
+	SpansDroppedTooBig int64
+	// SpansDroppedInvalid is the number of spans that have been dropped because they could not be serialized.
+	SpansDroppedInvalid int64
+	// SpansDroppedCircuitOpen is the number of spans that have been dropped because the circuit breaker was open and
+	// its retention policy is to drop spans.
+	SpansDroppedCircuitOpen int64
+
+	// ChunksExported is the number of span export requests that have been accepted by Dynatrace Cluster.
+	ChunksExported int64
+	// BytesExported is the number of request body bytes of all accepted span export requests.
+	BytesExported int64
+	// EndpointFailovers is the number of times requests have been sent to another endpoint because the one they were
+	// sent to has failed. It is only counted if several base URLs are configured.
+	EndpointFailovers int64
+	// ChunksSplit is the number of chunks that have been split and sent again because Dynatrace Cluster rejected them
+	// as too large.
+	ChunksSplit int64
+	// HttpStatusCodes is the number of span export responses per HTTP status code.
+	HttpStatusCodes map[int]int64
+
+	// ExportRequests is the number of span export request attempts, including failed ones.
+	ExportRequests int64
+	// ExportLatencyTotal is the sum of the durations of all span export requests.
+	ExportLatencyTotal time.Duration
+	// ExportLatencyMax is the duration of the slowest span export request.
+	ExportLatencyMax time.Duration
+	// LastExportLatency is the duration of the most recent span export request.
+	LastExportLatency time.Duration
+
+	// CircuitBreakerState is the current state of the circuit breaker around the export endpoint.
+	CircuitBreakerState CircuitBreakerState
+	// CircuitBreakerOpened is the number of times the circuit breaker has been opened.
+	CircuitBreakerOpened int64
+	// ExportsSkippedCircuitOpen is the number of export operations that have been skipped because the circuit breaker
+	// was open.
+	ExportsSkippedCircuitOpen int64
+
+	// LastError is the error of the most recent failed export operation, nil if no export operation has failed yet.
+	LastError error
+	// LastErrorTime is the point in time at which LastError occurred.
+	LastErrorTime time.Time
+}
+
+// AverageExportLatency returns the average duration of a span export request.
+func (s Stats) AverageExportLatency() time.Duration {
+	if s.ExportRequests == 0 {
+		return 0
+	}
+
+	return s.ExportLatencyTotal / time.Duration(s.ExportRequests)
+}
+
+// dtStats collects the self-monitoring statistics which are shared by the span processor, span watchlist,
+// span exporter and span serializer of a DtTracerProvider.
+type dtStats struct {
+	spansStarted                int64
+	spansEnded                  int64
+	spansRejected               int64
+	spansEvicted                int64
+	overflowExports             int64
+	spansDroppedOpenSpanTimeout int64
+	spansDroppedTooBig          int64
+	spansDroppedInvalid         int64
+	chunksExported              int64
+	bytesExported               int64
+	chunksSplit                 int64
+	spansDroppedCircuitOpen     int64
+	circuitBreakerOpened        int64
+	exportsSkippedCircuitOpen   int64
+	endpointFailovers           int64
+
+	lock               sync.Mutex
+	httpStatusCodes    map[int]int64
+	exportRequests     int64
+	exportLatencyTotal time.Duration
+	exportLatencyMax   time.Duration
+	lastExportLatency  time.Duration
+	lastError          error
+	lastErrorTime      time.Time
+
+	circuitBreakerState CircuitBreakerState
+}
+
+func newDtStats() *dtStats {
+	return &dtStats{
+		httpStatusCodes: make(map[int]int64),
+	}
+}
+
+func (s *dtStats) recordSpanStarted() {
+	atomic.AddInt64(&s.spansStarted, 1)
+}
+
+func (s *dtStats) recordSpanEnded() {
+	atomic.AddInt64(&s.spansEnded, 1)
+}
+
+func (s *dtStats) recordSpanRejected() {
+	atomic.AddInt64(&s.spansRejected, 1)
+}
+
+func (s *dtStats) recordSpanEvicted() {
+	atomic.AddInt64(&s.spansEvicted, 1)
+}
+
+func (s *dtStats) recordOverflowExport() {
+	atomic.AddInt64(&s.overflowExports, 1)
+}
+
+func (s *dtStats) recordSpanDroppedOpenSpanTimeout() {
+	atomic.AddInt64(&s.spansDroppedOpenSpanTimeout, 1)
+}
+
+func (s *dtStats) recordDroppedSpans(dropped *droppedSpanCounts) {
+	atomic.AddInt64(&s.spansDroppedTooBig, int64(dropped[spanDropReasonTooBig]))
+	atomic.AddInt64(&s.spansDroppedInvalid, int64(dropped[spanDropReasonInvalid]))
+}
+
+func (s *dtStats) recordChunkExported(numBytes int) {
+	atomic.AddInt64(&s.chunksExported, 1)
+	atomic.AddInt64(&s.bytesExported, int64(numBytes))
+}
+
+func (s *dtStats) recordChunkSplit() {
+	atomic.AddInt64(&s.chunksSplit, 1)
+}
+
+func (s *dtStats) recordSpansDroppedCircuitOpen(count int) {
+	atomic.AddInt64(&s.spansDroppedCircuitOpen, int64(count))
+}
+
+func (s *dtStats) recordCircuitBreakerOpened() {
+	atomic.AddInt64(&s.circuitBreakerOpened, 1)
+}
+
+func (s *dtStats) recordExportSkippedCircuitOpen() {
+	atomic.AddInt64(&s.exportsSkippedCircuitOpen, 1)
+}
+
+func (s *dtStats) recordEndpointFailover() {
+	atomic.AddInt64(&s.endpointFailovers, 1)
+}
+
+func (s *dtStats) setCircuitBreakerState(state CircuitBreakerState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.circuitBreakerState = state
+}
+
+// recordExportRequest records the duration and the response status code of a span export request attempt.
+// The status code is 0 if no response has been received.
+func (s *dtStats) recordExportRequest(statusCode int, latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if statusCode != 0 {
+		s.httpStatusCodes[statusCode]++
+	}
+	s.exportRequests++
+	s.exportLatencyTotal += latency
+	s.lastExportLatency = latency
+	if latency > s.exportLatencyMax {
+		s.exportLatencyMax = latency
+	}
+}
+
+func (s *dtStats) recordError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastError = err
+	s.lastErrorTime = time.Now()
+}
+
+// snapshot returns a consistent copy of the collected statistics.
+func (s *dtStats) snapshot() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	httpStatusCodes := make(map[int]int64, len(s.httpStatusCodes))
+	for statusCode, count := range s.httpStatusCodes {
+		httpStatusCodes[statusCode] = count
+	}
+
+	return Stats{
+		SpansStarted:                atomic.LoadInt64(&s.spansStarted),
+		SpansEnded:                  atomic.LoadInt64(&s.spansEnded),
+		SpansRejected:               atomic.LoadInt64(&s.spansRejected),
+		SpansEvicted:                atomic.LoadInt64(&s.spansEvicted),
+		OverflowExports:             atomic.LoadInt64(&s.overflowExports),
+		SpansDroppedOpenSpanTimeout: atomic.LoadInt64(&s.spansDroppedOpenSpanTimeout),
+		SpansDroppedTooBig:          atomic.LoadInt64(&s.spansDroppedTooBig),
+		SpansDroppedInvalid:         atomic.LoadInt64(&s.spansDroppedInvalid),
+		SpansDroppedCircuitOpen:     atomic.LoadInt64(&s.spansDroppedCircuitOpen),
+		ChunksExported:              atomic.LoadInt64(&s.chunksExported),
+		BytesExported:               atomic.LoadInt64(&s.bytesExported),
+		ChunksSplit:                 atomic.LoadInt64(&s.chunksSplit),
+		EndpointFailovers:           atomic.LoadInt64(&s.endpointFailovers),
+		HttpStatusCodes:             httpStatusCodes,
+		ExportRequests:              s.exportRequests,
+		ExportLatencyTotal:          s.exportLatencyTotal,
+		ExportLatencyMax:            s.exportLatencyMax,
+		LastExportLatency:           s.lastExportLatency,
+		CircuitBreakerState:         s.circuitBreakerState,
+		CircuitBreakerOpened:        atomic.LoadInt64(&s.circuitBreakerOpened),
+		ExportsSkippedCircuitOpen:   atomic.LoadInt64(&s.exportsSkippedCircuitOpen),
+		LastError:                   s.lastError,
+		LastErrorTime:               s.lastErrorTime,
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsSpansStartedEndedAndRejected(t *testing.T) {
+	tp, _ := newDtTracerProviderWithTestExporter()
+	tp.processor.spanWatchlist.maxSpans = 2
+	tr := tp.Tracer("Dynatrace Tracer")
+
+	_, span1 := tr.Start(context.Background(), "span1")
+	_, span2 := tr.Start(context.Background(), "span2")
+	_, span3 := tr.Start(context.Background(), "span3")
+	span1.End()
+	span2.End()
+	span3.End()
+
+	stats := tp.Stats()
+	require.EqualValues(t, 3, stats.SpansStarted)
+	require.EqualValues(t, 3, stats.SpansEnded)
+	require.EqualValues(t, 1, stats.SpansRejected, "span3 can not be added to the full watchlist")
+}
+
+func TestStatsSpansDroppedByOpenSpanTimeout(t *testing.T) {
+	tp, _ := newDtTracerProviderWithTestExporter()
+	tr := tp.Tracer("Dynatrace Tracer")
+
+	_, span := tr.Start(context.Background(), "open span")
+	span.(*dtSpan).metadata.firstSeenMs -= span.(*dtSpan).metadata.options.openSpanTimeoutMs
+
+	_ = tp.processor.spanWatchlist.getSpansToExport()
+	require.Zero(t, tp.processor.spanWatchlist.len())
+	require.EqualValues(t, 1, tp.Stats().SpansDroppedOpenSpanTimeout)
+}
+
+func TestStatsExportRequests(t *testing.T) {
+	statusCodes := []int{http.StatusOK, http.StatusBadRequest, http.StatusOK}
+	numRequests := 0
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(statusCodes[numRequests])
+		numRequests++
+	})
+	defer testServer.Close()
+
+	stats := newDtStats()
+	exporter := newDtSpanExporter(config, stats, nil).(*dtSpanExporterImpl)
+
+	require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3}))
+	require.Error(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2}))
+	require.NoError(t, exporter.doExportRequest(context.Background(), exportTypePeriodic, exportData{1, 2, 3, 4}))
+
+	snapshot := stats.snapshot()
+	require.EqualValues(t, 2, snapshot.ChunksExported)
+	require.EqualValues(t, 7, snapshot.BytesExported)
+	require.Equal(t, map[int]int64{http.StatusOK: 2, http.StatusBadRequest: 1}, snapshot.HttpStatusCodes)
+	require.EqualValues(t, 3, snapshot.ExportRequests)
+	require.Positive(t, int64(snapshot.ExportLatencyTotal))
+	require.GreaterOrEqual(t, int64(snapshot.ExportLatencyMax), int64(snapshot.LastExportLatency))
+	require.LessOrEqual(t, int64(snapshot.AverageExportLatency()), int64(snapshot.ExportLatencyMax))
+}
+
+func TestStatsLastError(t *testing.T) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusBadRequest)
+	})
+	defer testServer.Close()
+
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(config))
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+
+	require.Nil(t, tp.Stats().LastError)
+
+	_, span := tp.Tracer("Dynatrace Tracer").Start(context.Background(), "span")
+	span.End()
+	before := time.Now()
+	require.Error(t, tp.ForceFlush(context.Background()))
+
+	stats := tp.Stats()
+	require.EqualError(t, stats.LastError, "unexpected response code: 400")
+	require.False(t, stats.LastErrorTime.Before(before))
+	require.EqualValues(t, 1, stats.HttpStatusCodes[http.StatusBadRequest])
+	require.Zero(t, stats.ChunksExported)
+}
+
+func TestStatsSnapshotIsACopy(t *testing.T) {
+	stats := newDtStats()
+	stats.recordExportRequest(http.StatusOK, time.Millisecond)
+	stats.recordError(errors.New("export failed"))
+
+	snapshot := stats.snapshot()
+	snapshot.HttpStatusCodes[http.StatusOK] = 42
+	require.EqualValues(t, 1, stats.snapshot().HttpStatusCodes[http.StatusOK])
+	require.Equal(t, time.Millisecond, snapshot.AverageExportLatency())
+}
+
+func TestStatsWithoutProcessor(t *testing.T) {
+	tp := &DtTracerProvider{}
+	require.Equal(t, Stats{}, tp.Stats())
+}
@@ -16,6 +16,7 @@ package trace
 
 import (
 	"context"
+	"sync/atomic"
 
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
@@ -35,25 +36,69 @@ type DtTextMapPropagator struct {
 	sdkPropagator propagation.TraceContext
 	logger        *logger.ComponentLogger
 	config        *configuration.DtConfiguration
+	// configured is set once the propagator has a valid configuration. A propagator created in lenient mode gets its
+	// config once a valid configuration is found, it must not be accessed before.
+	configured int32
 }
 
-func NewTextMapPropagator() (*DtTextMapPropagator, error) {
-	config, err := configuration.GlobalConfigurationProvider.GetConfiguration()
+// NewTextMapPropagator creates a DtTextMapPropagator. Unless WithDtConfiguration is given, the configuration provided
+// by configuration.GlobalConfigurationProvider is used.
+func NewTextMapPropagator(opts ...Option) (*DtTextMapPropagator, error) {
+	o := newOptions(opts)
+	config, err := o.resolveConfiguration()
 	if err != nil {
-		return nil, err
+		if !o.lenient {
+			return nil, err
+		}
+		return newLenientTextMapPropagator(o, err), nil
 	}
 
 	p := &DtTextMapPropagator{
 		sdkPropagator: propagation.TraceContext{},
 		logger:        logger.NewComponentLogger("TextMapPropagator"),
 		config:        config,
+		configured:    1,
 	}
 
 	p.logger.Debug("TextMapPropagator created")
 	return p, nil
 }
 
+// newLenientTextMapPropagator creates a DtTextMapPropagator for a missing or invalid configuration, which only
+// propagates W3C trace context until a valid configuration is found. The propagator does not retry loading the
+// configuration on its own, it follows the DtTracerProvider in lenient mode, so that retrying stops once the
+// provider is shut down.
+func newLenientTextMapPropagator(o *options, err error) *DtTextMapPropagator {
+	warnConfigurationUnavailable(err)
+
+	p := &DtTextMapPropagator{
+		sdkPropagator: propagation.TraceContext{},
+		logger:        logger.NewComponentLogger("TextMapPropagator"),
+	}
+
+	if o.config == nil {
+		o.retrier().followConfigLoaded(func(config *configuration.DtConfiguration) {
+			p.config = config
+			atomic.StoreInt32(&p.configured, 1)
+		})
+	}
+
+	return p
+}
+
+// w3cOnly reports whether only W3C trace context is propagated, i.e. if there is no valid configuration yet or if
+// the agent is not active.
+func (p *DtTextMapPropagator) w3cOnly() bool {
+	return atomic.LoadInt32(&p.configured) == 0 || p.config.AgentDisabled
+}
+
 func (p *DtTextMapPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	if p.w3cOnly() {
+		// pass W3C trace context through if the agent is not active
+		p.sdkPropagator.Inject(ctx, carrier)
+		return
+	}
+
 	span := dtSpanFromContext(ctx)
 	if span == nil {
 		p.logger.Debug("Attempted to inject non DT Span")
@@ -84,6 +129,11 @@ func (p *DtTextMapPropagator) Inject(ctx context.Context, carrier propagation.Te
 	// set x-dynatrace header
 	xDt := tag.ToXDynatrace()
 	carrier.Set(xDtHeader, xDt)
+
+	if trace.SpanFromContext(ctx) != trace.Span(span) {
+		// the context holds the SDK span of a span started with a DtSpanProcessor, whose span context lacks the FW4 tag
+		ctx = trace.ContextWithSpanContext(ctx, span.SpanContext())
+	}
 	p.sdkPropagator.Inject(ctx, carrier)
 
 	if p.logger.DebugEnabled() {
@@ -94,6 +144,10 @@ func (p *DtTextMapPropagator) Inject(ctx context.Context, carrier propagation.Te
 }
 
 func (p *DtTextMapPropagator) Extract(parentCtx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	if p.w3cOnly() {
+		return p.sdkPropagator.Extract(parentCtx, carrier)
+	}
+
 	remoteContext := p.sdkPropagator.Extract(parentCtx, carrier)
 	remoteSpanCtx := trace.SpanContextFromContext(remoteContext)
 	if p.logger.DebugEnabled() {
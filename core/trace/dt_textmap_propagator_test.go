@@ -695,3 +695,24 @@ func newTestDtSpan(spanConfig trace.SpanContextConfig, dtConfig *configuration.D
 	ctx = trace.ContextWithSpan(ctx, span)
 	return ctx, span
 }
+
+func TestPropagatorWithDtConfiguration(t *testing.T) {
+	config, err := configuration.BuildConfiguration(configuration.DtConfiguration{
+		ClusterId: 456,
+		Tenant:    "otherTenant",
+		BaseUrl:   "https://other.example.com",
+		AuthToken: "otherAuthToken",
+	})
+	require.NoError(t, err)
+
+	p, err := NewTextMapPropagator(WithDtConfiguration(config))
+	require.NoError(t, err)
+	require.Same(t, config, p.config)
+
+	c := propagation.HeaderCarrier{}
+	c.Set(traceparentHeader, "00-11223344556677889900112233445566-8877665544332211-01")
+	c.Set(tracestateHeader, fw4.TraceStateKey(config.QualifiedTenantId())+"=fw4;fffffff8;0;0;0;0;0;0;7db5;2h01;7h8877665544332211")
+
+	ctx := p.Extract(context.Background(), c)
+	require.NotNil(t, fw4.Fw4TagFromContext(ctx))
+}
@@ -0,0 +1,277 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/logger"
+	protoCollectorCommon "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/collector/common/v1"
+	protoCollectorTraces "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/collector/traces/v1"
+)
+
+const (
+	cClusterTimePath = "/odin/v1/clustertime"
+
+	// number of cluster time queries per synchronization, the one with the lowest round trip time is used
+	cTimeSyncSamples = 3
+	// interval in which a successfully measured offset is refreshed
+	cTimeSyncRefreshInterval = 30 * time.Minute
+	// interval in which a failed synchronization is retried
+	cTimeSyncRetryInterval = time.Minute
+	// a measured offset is no longer used if it could not be refreshed for this long
+	cTimeSyncMaxOffsetAge = 2 * time.Hour
+	// timeout of a single cluster time query
+	cTimeSyncQueryTimeout = 5 * time.Second
+)
+
+// dtTimeSync measures the offset between the local clock and the clock of Dynatrace Cluster.
+// Clocks of serverless containers are frequently skewed, so the offset is sent along with the spans and allows
+// Dynatrace Cluster to correct the span timestamps.
+type dtTimeSync struct {
+	logger     *logger.ComponentLogger
+	config     *configuration.DtConfiguration
+	connection *dtConnection
+	endpoints  *dtEndpoints
+	client     *http.Client
+	now        func() time.Time
+
+	lock         sync.Mutex
+	mode         protoCollectorCommon.ExportMetaInfo_TimeSyncMode
+	offsetMs     int64
+	lastAttempt  time.Time
+	lastSyncTime time.Time
+
+	// stopSync cancels the background synchronization, done is closed once it has ended
+	stopSync context.CancelFunc
+	done     chan struct{}
+	stopped  bool
+}
+
+func newDtTimeSync(
+	config *configuration.DtConfiguration,
+	connection *dtConnection,
+	endpoints *dtEndpoints,
+	client *http.Client,
+) *dtTimeSync {
+	return &dtTimeSync{
+		logger:     logger.NewComponentLogger("TimeSync"),
+		config:     config,
+		connection: connection,
+		endpoints:  endpoints,
+		client:     client,
+		now:        time.Now,
+		mode:       protoCollectorCommon.ExportMetaInfo_Unsynced,
+	}
+}
+
+// exportMetaInfo returns the time synchronization state which is sent along with the spans.
+func (t *dtTimeSync) exportMetaInfo() *protoCollectorCommon.ExportMetaInfo {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return &protoCollectorCommon.ExportMetaInfo{
+		TimeSyncMode:        t.mode,
+		ClusterTimeOffsetMs: t.offsetMs,
+	}
+}
+
+// start measures the offset in the background, so that exports never wait for the cluster time queries.
+// The offset is measured right away and refreshed or retried whenever it is due.
+func (t *dtTimeSync) start() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.stopped || t.stopSync != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stopSync = cancel
+	t.done = make(chan struct{})
+	go t.run(ctx)
+}
+
+func (t *dtTimeSync) run(ctx context.Context) {
+	defer close(t.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			t.syncIfDue(ctx)
+			timer.Reset(t.nextSyncDelay())
+		}
+	}
+}
+
+// stop ends the background synchronization and waits until a query in progress is cancelled.
+func (t *dtTimeSync) stop() {
+	t.lock.Lock()
+	t.stopped = true
+	stopSync, done := t.stopSync, t.done
+	t.lock.Unlock()
+
+	if stopSync == nil {
+		return
+	}
+
+	stopSync()
+	<-done
+}
+
+// isSyncDue reports whether the offset has to be measured (again).
+func (t *dtTimeSync) isSyncDue() bool {
+	return t.nextSyncDelay() == 0
+}
+
+// nextSyncDelay returns the time until the offset has to be measured (again), 0 if it is due.
+func (t *dtTimeSync) nextSyncDelay() time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var due time.Time
+	switch t.mode {
+	case protoCollectorCommon.ExportMetaInfo_Unsynced:
+		return 0
+	case protoCollectorCommon.ExportMetaInfo_ClusterSync:
+		due = t.lastAttempt.Add(cTimeSyncRefreshInterval)
+		if t.lastAttempt.After(t.lastSyncTime) {
+			// the most recent refresh has failed
+			due = t.lastAttempt.Add(cTimeSyncRetryInterval)
+		}
+	default:
+		due = t.lastAttempt.Add(cTimeSyncRetryInterval)
+	}
+
+	if delay := due.Sub(t.now()); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// syncIfDue measures the offset if it has never been measured or if it has to be refreshed.
+func (t *dtTimeSync) syncIfDue(ctx context.Context) {
+	if !t.isSyncDue() {
+		return
+	}
+
+	if err := t.sync(ctx); err != nil {
+		t.logger.Infof("Can not synchronize time with Dynatrace Cluster: %s", err)
+	}
+}
+
+// sync queries the cluster time several times and keeps the offset measured with the lowest round trip time,
+// since it has the lowest uncertainty.
+func (t *dtTimeSync) sync(ctx context.Context) error {
+	var bestOffsetMs int64
+	var bestRtt time.Duration
+	var lastErr error
+	numSamples := 0
+
+	for i := 0; i < cTimeSyncSamples; i++ {
+		offsetMs, rtt, err := t.querySample(ctx)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		if numSamples == 0 || rtt < bestRtt {
+			bestOffsetMs, bestRtt = offsetMs, rtt
+		}
+		numSamples++
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	t.lastAttempt = now
+	if numSamples == 0 {
+		// keep using a previously measured offset unless it is outdated
+		if t.mode != protoCollectorCommon.ExportMetaInfo_ClusterSync || now.Sub(t.lastSyncTime) >= cTimeSyncMaxOffsetAge {
+			t.mode = protoCollectorCommon.ExportMetaInfo_FailedSync
+			t.offsetMs = 0
+		}
+		return lastErr
+	}
+
+	t.logger.Debugf("Cluster time offset is %d ms (round trip time %s)", bestOffsetMs, bestRtt)
+	t.mode = protoCollectorCommon.ExportMetaInfo_ClusterSync
+	t.offsetMs = bestOffsetMs
+	t.lastSyncTime = now
+	return nil
+}
+
+// querySample queries the cluster time once. Returns the offset of the cluster clock to the local clock,
+// assuming that the cluster time has been taken half way through the round trip.
+func (t *dtTimeSync) querySample(ctx context.Context) (int64, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, cTimeSyncQueryTimeout)
+	defer cancel()
+
+	settings := t.connection.settings()
+	baseUrl := t.endpoints.pick(settings.baseUrl)
+	req, err := http.NewRequestWithContext(ctx, "GET", baseUrl+cClusterTimePath, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	setDtRequestHeaders(req, t.config, settings.authToken)
+
+	sendTime := t.now()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	receiveTime := t.now()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, errors.New("unexpected response code: " + strconv.Itoa(resp.StatusCode))
+	}
+
+	clusterTime := &protoCollectorTraces.ClusterTimeResponse{}
+	if err := proto.Unmarshal(body, clusterTime); err != nil {
+		return 0, 0, err
+	}
+	if clusterTime.ClusterTimestampMs == 0 {
+		return 0, 0, errors.New("cluster time response does not contain a timestamp")
+	}
+
+	rtt := receiveTime.Sub(sendTime)
+	localTimeMs := sendTime.Add(rtt/2).UnixNano() / int64(time.Millisecond)
+	return int64(clusterTime.ClusterTimestampMs) - localTimeMs, rtt, nil
+}
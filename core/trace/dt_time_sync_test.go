@@ -0,0 +1,254 @@
+// Copyright 2022 Dynatrace LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	protoCollectorCommon "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/collector/common/v1"
+	protoCollectorTraces "github.com/dynatrace-oss/opentelemetry-exporter-go/core/internal/odin-proto/collector/traces/v1"
+)
+
+func writeClusterTimeResponse(rw http.ResponseWriter, clusterTime time.Time) {
+	body, _ := proto.Marshal(&protoCollectorTraces.ClusterTimeResponse{
+		ClusterTimestampMs: uint64(clusterTime.UnixNano() / int64(time.Millisecond)),
+	})
+	rw.Write(body) //nolint:errcheck
+}
+
+// fakeClock is a manually advanced clock for dtTimeSync.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTimeSyncForTest creates a dtTimeSync with a fake clock which queries the given cluster time handler.
+func newTimeSyncForTest(t *testing.T, handler http.HandlerFunc) (*dtTimeSync, *fakeClock) {
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		t.Fatalf("unexpected request: %s", req.URL.Path)
+	})
+	testServer.Config.Handler = handler
+	t.Cleanup(testServer.Close)
+
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	timeSync := newDtTimeSync(config, newDtConnection(config), nil, http.DefaultClient)
+	timeSync.now = clock.Now
+	return timeSync, clock
+}
+
+func TestTimeSyncUnsyncedInitially(t *testing.T) {
+	timeSync, _ := newTimeSyncForTest(t, nil)
+
+	require.True(t, timeSync.isSyncDue())
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_Unsynced, timeSync.exportMetaInfo().TimeSyncMode)
+	require.Zero(t, timeSync.exportMetaInfo().ClusterTimeOffsetMs)
+}
+
+func TestTimeSyncMeasuresClusterTimeOffset(t *testing.T) {
+	var timeSync *dtTimeSync
+	var clock *fakeClock
+	timeSync, clock = newTimeSyncForTest(t, func(rw http.ResponseWriter, req *http.Request) {
+		require.Equal(t, cClusterTimePath, req.URL.Path)
+		require.Equal(t, "GET", req.Method)
+		require.Equal(t, "Dynatrace testDtToken", req.Header.Get("Authorization"))
+
+		// the cluster clock is 5 s ahead, the response is received 200 ms after the request has been sent
+		clock.Advance(100 * time.Millisecond)
+		writeClusterTimeResponse(rw, clock.Now().Add(5*time.Second))
+		clock.Advance(100 * time.Millisecond)
+	})
+
+	require.NoError(t, timeSync.sync(context.Background()))
+
+	metaInfo := timeSync.exportMetaInfo()
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_ClusterSync, metaInfo.TimeSyncMode)
+	require.EqualValues(t, 5000, metaInfo.ClusterTimeOffsetMs)
+	require.False(t, timeSync.isSyncDue())
+}
+
+func TestTimeSyncUsesSampleWithLowestRoundTripTime(t *testing.T) {
+	var timeSync *dtTimeSync
+	var clock *fakeClock
+	numRequests := 0
+	timeSync, clock = newTimeSyncForTest(t, func(rw http.ResponseWriter, req *http.Request) {
+		numRequests++
+		if numRequests == 2 {
+			// fast round trip, cluster time is taken half way through
+			clock.Advance(10 * time.Millisecond)
+			writeClusterTimeResponse(rw, clock.Now().Add(-3*time.Second))
+			clock.Advance(10 * time.Millisecond)
+			return
+		}
+
+		// slow round trip, cluster time is taken right after the request has been sent
+		writeClusterTimeResponse(rw, clock.Now().Add(-3*time.Second))
+		clock.Advance(2 * time.Second)
+	})
+
+	require.NoError(t, timeSync.sync(context.Background()))
+	require.Equal(t, cTimeSyncSamples, numRequests)
+	require.EqualValues(t, -3000, timeSync.exportMetaInfo().ClusterTimeOffsetMs)
+}
+
+func TestTimeSyncFailed(t *testing.T) {
+	timeSync, clock := newTimeSyncForTest(t, func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	})
+
+	require.Error(t, timeSync.sync(context.Background()))
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_FailedSync, timeSync.exportMetaInfo().TimeSyncMode)
+	require.Zero(t, timeSync.exportMetaInfo().ClusterTimeOffsetMs)
+
+	// a failed synchronization is retried after the retry interval
+	require.False(t, timeSync.isSyncDue())
+	clock.Advance(cTimeSyncRetryInterval)
+	require.True(t, timeSync.isSyncDue())
+}
+
+func TestTimeSyncInvalidResponse(t *testing.T) {
+	timeSync, _ := newTimeSyncForTest(t, func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte{}) //nolint:errcheck
+	})
+
+	require.ErrorContains(t, timeSync.sync(context.Background()), "does not contain a timestamp")
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_FailedSync, timeSync.exportMetaInfo().TimeSyncMode)
+}
+
+func TestTimeSyncRefreshesOffset(t *testing.T) {
+	var timeSync *dtTimeSync
+	var clock *fakeClock
+	var failing int32
+	timeSync, clock = newTimeSyncForTest(t, func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) != 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeClusterTimeResponse(rw, clock.Now().Add(time.Second))
+	})
+
+	timeSync.syncIfDue(context.Background())
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_ClusterSync, timeSync.exportMetaInfo().TimeSyncMode)
+	require.EqualValues(t, 1000, timeSync.exportMetaInfo().ClusterTimeOffsetMs)
+
+	clock.Advance(cTimeSyncRefreshInterval - time.Second)
+	require.False(t, timeSync.isSyncDue())
+	clock.Advance(time.Second)
+	require.True(t, timeSync.isSyncDue())
+
+	// the previously measured offset is kept if the refresh fails
+	atomic.StoreInt32(&failing, 1)
+	timeSync.syncIfDue(context.Background())
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_ClusterSync, timeSync.exportMetaInfo().TimeSyncMode)
+	require.EqualValues(t, 1000, timeSync.exportMetaInfo().ClusterTimeOffsetMs)
+
+	// the failed refresh is retried after the retry interval
+	require.False(t, timeSync.isSyncDue())
+	clock.Advance(cTimeSyncRetryInterval)
+	require.True(t, timeSync.isSyncDue())
+
+	// the offset is discarded once it is outdated
+	clock.Advance(cTimeSyncMaxOffsetAge)
+	timeSync.syncIfDue(context.Background())
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_FailedSync, timeSync.exportMetaInfo().TimeSyncMode)
+	require.Zero(t, timeSync.exportMetaInfo().ClusterTimeOffsetMs)
+}
+
+func TestSpanExportContainsTimeSyncState(t *testing.T) {
+	var exportMetaInfos []*protoCollectorCommon.ExportMetaInfo
+	testServer, config := createTestServerAndConfig(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		export := &protoCollectorTraces.SpanExport{}
+		require.NoError(t, proto.Unmarshal(body, export))
+		metaInfo := &protoCollectorCommon.ExportMetaInfo{}
+		require.NoError(t, proto.Unmarshal(export.ExportMetaInfo, metaInfo))
+		exportMetaInfos = append(exportMetaInfos, metaInfo)
+	})
+	defer testServer.Close()
+
+	tp, _ := newDtTracerProviderWithTestExporter()
+	exporter := newDtSpanExporter(config, tp.processor.stats, nil).(*dtSpanExporterImpl)
+	tp.processor.exporter = exporter
+	defer tp.Shutdown(context.Background())
+
+	exporter.timeSync.start()
+	require.Eventually(t, func() bool {
+		return exporter.timeSync.exportMetaInfo().TimeSyncMode == protoCollectorCommon.ExportMetaInfo_ClusterSync
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	span.End()
+	require.NoError(t, tp.ForceFlush(context.Background()))
+
+	require.Len(t, exportMetaInfos, 1)
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_ClusterSync, exportMetaInfos[0].TimeSyncMode)
+	// test server and exporter share the same clock
+	require.InDelta(t, 0, exportMetaInfos[0].ClusterTimeOffsetMs, 1000)
+}
+
+func TestSpanExportDoesNotWaitForTimeSync(t *testing.T) {
+	release := make(chan struct{})
+	var numExports int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == cClusterTimePath {
+			// the cluster time endpoint does not respond until the test has ended
+			select {
+			case <-req.Context().Done():
+			case <-release:
+			}
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&numExports, 1)
+	}))
+	defer testServer.Close()
+	defer close(release)
+
+	unused, config := createTestServerAndConfig(nil)
+	unused.Close()
+	config.BaseUrl = testServer.URL
+	exporter := newDtSpanExporter(config, newDtStats(), nil).(*dtSpanExporterImpl)
+	defer exporter.stop()
+
+	_, span := createTracer().Start(context.Background(), "span")
+	start := time.Now()
+	require.NoError(t, exporter.export(context.Background(), exportTypeForceFlush, makeSpanSet(span)))
+	require.Less(t, time.Since(start), cTimeSyncQueryTimeout, "the export must not wait for the cluster time")
+	require.EqualValues(t, 1, atomic.LoadInt32(&numExports))
+	require.Equal(t, protoCollectorCommon.ExportMetaInfo_Unsynced, exporter.timeSync.exportMetaInfo().TimeSyncMode)
+}
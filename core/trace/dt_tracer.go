@@ -18,17 +18,19 @@ import (
 	"context"
 
 	"go.opentelemetry.io/otel/trace"
-
-	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
 )
 
 type dtTracer struct {
 	trace.Tracer
 	provider *DtTracerProvider
-	config   *configuration.DtConfiguration
 }
 
 func (tr *dtTracer) Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if !tr.provider.isConfigured() {
+		// there is no valid configuration yet, the span is recorded by the SDK TracerProvider only
+		return tr.Tracer.Start(ctx, name, options...)
+	}
+
 	parentCtx := ctx
 	if parentSpan := dtSpanFromContext(ctx); parentSpan != nil {
 		parentCtx = trace.ContextWithSpan(ctx, parentSpan.Span)
@@ -38,12 +40,7 @@ func (tr *dtTracer) Start(ctx context.Context, name string, options ...trace.Spa
 	span := &dtSpan{
 		Span:   sdkSpan,
 		tracer: tr,
-		metadata: createSpanMetadata(
-			ctx,
-			sdkSpan,
-			tr.config.ClusterId,
-			tr.config.TenantId(),
-			int64(tr.config.SpanProcessingIntervalMs)),
+		metadata: createSpanMetadata(ctx, sdkSpan, tr.provider.config, nil),
 	}
 
 	if sdkSpan.IsRecording() {
@@ -17,7 +17,9 @@ package trace
 import (
 	"context"
 	"errors"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
@@ -36,28 +38,124 @@ type DtTracerProvider struct {
 	processor      *dtSpanProcessor
 	logger         *logger.ComponentLogger
 	config         *configuration.DtConfiguration
+	// configured is set once the provider has a valid configuration. A provider created in lenient mode gets its
+	// config and processor once a valid configuration is found, they must not be accessed before.
+	configured int32
+	lenient    bool
+	// shutdown is set if a provider in lenient mode is shut down before it has been configured, guarded by mu
+	shutdown bool
+	// transport is the custom HTTP transport of a provider in lenient mode, which is used once it is configured
+	transport http.RoundTripper
+	// stopConfigRetry stops waiting for a valid configuration if a provider in lenient mode is shut down before
+	stopConfigRetry func()
 }
 
+// NewTracerProvider creates a DtTracerProvider using the configuration provided by
+// configuration.GlobalConfigurationProvider. The given options are passed to the wrapped SDK TracerProvider.
 func NewTracerProvider(opts ...sdktrace.TracerProviderOption) (*DtTracerProvider, error) {
-	config, err := configuration.GlobalConfigurationProvider.GetConfiguration()
+	return NewTracerProviderWithOptions(WithTracerProviderOptions(opts...))
+}
+
+// NewTracerProviderWithOptions creates a DtTracerProvider configured by the given options.
+// Several providers with different configurations can be used in one process.
+// If the agent is not active, the returned provider does not record spans.
+func NewTracerProviderWithOptions(opts ...Option) (*DtTracerProvider, error) {
+	o := newOptions(opts)
+	config, err := o.resolveConfiguration()
 	if err != nil {
-		return nil, err
+		if !o.lenient {
+			return nil, err
+		}
+		return newLenientDtTracerProvider(o, err), nil
 	}
 
+	if config.AgentDisabled {
+		return newNoopDtTracerProvider(config), nil
+	}
+
+	sdkOptions := append(sdkOptionsFromConfiguration(config), o.sdkOptions...)
 	tp := &DtTracerProvider{
-		TracerProvider: sdktrace.NewTracerProvider(opts...),
+		TracerProvider: sdktrace.NewTracerProvider(sdkOptions...),
 		mu:             sync.Mutex{},
 		wrappedTracers: make(map[trace.Tracer]*dtTracer),
-		processor:      newDtSpanProcessor(config),
+		processor:      newDtSpanProcessor(config, o.transport),
 		logger:         logger.NewComponentLogger("TracerProvider"),
 		config:         config,
+		configured:     1,
 	}
 
 	tp.logger.Debug("TracerProvider created")
 	return tp, nil
 }
 
+// newLenientDtTracerProvider creates a DtTracerProvider for a missing or invalid configuration. Spans are recorded
+// by the SDK TracerProvider, e.g. for other span processors, but not exported to Dynatrace Cluster until a valid
+// configuration is found. Loading the global configuration is retried periodically, an explicitly provided
+// configuration is not loaded again.
+func newLenientDtTracerProvider(o *options, err error) *DtTracerProvider {
+	warnConfigurationUnavailable(err)
+
+	tp := &DtTracerProvider{
+		TracerProvider: sdktrace.NewTracerProvider(o.sdkOptions...),
+		wrappedTracers: make(map[trace.Tracer]*dtTracer),
+		logger:         logger.NewComponentLogger("TracerProvider"),
+		lenient:        true,
+		transport:      o.transport,
+	}
+
+	if o.config == nil {
+		tp.stopConfigRetry = o.retrier().onConfigLoaded(tp.configure)
+	}
+
+	return tp
+}
+
+// configure starts exporting spans of a provider created in lenient mode once a valid configuration is found.
+func (p *DtTracerProvider) configure(config *configuration.DtConfiguration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.shutdown {
+		return
+	}
+
+	if config.AgentDisabled {
+		p.logger.Info("Agent is not active, spans are not exported")
+		return
+	}
+
+	p.config = config
+	p.processor = newDtSpanProcessor(config, p.transport)
+	atomic.StoreInt32(&p.configured, 1)
+	p.logger.Debug("TracerProvider configured")
+}
+
+// isConfigured reports whether the provider has a valid configuration and exports spans.
+func (p *DtTracerProvider) isConfigured() bool {
+	return atomic.LoadInt32(&p.configured) == 1
+}
+
+// newNoopDtTracerProvider creates a DtTracerProvider for an inactive agent. Its tracers do not record spans but pass
+// the span context of the parent through, so that propagated W3C trace context is preserved. Options of the SDK
+// TracerProvider are ignored, no exporting goroutine is started and no network requests are sent.
+func newNoopDtTracerProvider(config *configuration.DtConfiguration) *DtTracerProvider {
+	tp := &DtTracerProvider{
+		TracerProvider: trace.NewNoopTracerProvider(),
+		wrappedTracers: make(map[trace.Tracer]*dtTracer),
+		logger:         logger.NewComponentLogger("TracerProvider"),
+		config:         config,
+		configured:     1,
+	}
+
+	tp.logger.Info("Agent is not active, spans are not recorded")
+	return tp
+}
+
 func (p *DtTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	if p.agentDisabled() {
+		return p.TracerProvider.Tracer(name, opts...)
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -69,7 +167,6 @@ func (p *DtTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace
 		tr = &dtTracer{
 			Tracer:   sdkTracer,
 			provider: p,
-			config:   p.config,
 		}
 		p.wrappedTracers[sdkTracer] = tr
 		p.logger.Debugf("Tracer '%s' created", name)
@@ -78,36 +175,82 @@ func (p *DtTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace
 	return tr
 }
 
+// agentDisabled reports whether the provider has been created for an inactive agent.
+func (p *DtTracerProvider) agentDisabled() bool {
+	return p.isConfigured() && p.config.AgentDisabled
+}
+
 // ForceFlush exports spans that have not been exported yet to Dynatrace Cluster
 func (p *DtTracerProvider) ForceFlush(ctx context.Context) error {
+	if p.agentDisabled() || (p.lenient && !p.isConfigured()) {
+		return nil
+	}
+
 	if p.processor == nil {
 		return errInvalidSpanProcessor
 	}
 
-	return measureExecutionTime(ctx, p.processor.forceFlush, "ForceFlush", p.logger)
+	return measureExecutionTime(ctx, p.processor.forceFlush, "ForceFlush", p.config, p.logger)
 }
 
 // Shutdown stops exporting goroutine and exports all remaining spans to Dynatrace Cluster.
 // It executes only once, subsequent call does nothing.
 func (p *DtTracerProvider) Shutdown(ctx context.Context) error {
+	if p.agentDisabled() {
+		return nil
+	}
+
+	if p.lenient {
+		p.mu.Lock()
+		configured := p.isConfigured()
+		p.shutdown = !configured
+		p.mu.Unlock()
+		if !configured {
+			if p.stopConfigRetry != nil {
+				p.stopConfigRetry()
+			}
+			return nil
+		}
+	}
+
 	if p.processor == nil {
 		return errInvalidSpanProcessor
 	}
 
-	return measureExecutionTime(ctx, p.processor.shutdown, "Shutdown", p.logger)
+	return measureExecutionTime(ctx, p.processor.shutdown, "Shutdown", p.config, p.logger)
+}
+
+// SetAuthToken replaces the auth token which is used to send spans to Dynatrace Cluster, e.g. once it has been
+// rotated. If exporting has been stopped because the previous auth token has been rejected, it is resumed.
+// Has no effect before a provider created in lenient mode is configured or if the agent is not active.
+func (p *DtTracerProvider) SetAuthToken(authToken string) {
+	if !p.isConfigured() || p.processor == nil {
+		return
+	}
+
+	p.processor.setAuthToken(authToken)
+}
+
+// Stats returns a snapshot of the self-monitoring statistics, e.g. the number of dropped spans and the
+// outcome of span export requests. All counters are cumulative since the DtTracerProvider has been created.
+func (p *DtTracerProvider) Stats() Stats {
+	if !p.isConfigured() || p.processor == nil {
+		return Stats{}
+	}
+
+	return p.processor.stats.snapshot()
 }
 
 // measureExecutionTime measure execution time of a given function
 // and log a warning message if it takes more than a third of the operation timeout
-func measureExecutionTime(ctx context.Context, f func(context.Context) error, opName string, logger *logger.ComponentLogger) error {
-	timeout := time.Millisecond * time.Duration(configuration.DefaultFlushOrShutdownTimeoutMs)
-	deadline, ok := ctx.Deadline()
-	if ok {
-		deadlineTimeout := time.Until(deadline)
-		if timeout > deadlineTimeout {
-			timeout = deadlineTimeout
-		}
-	}
+func measureExecutionTime(
+	ctx context.Context,
+	f func(context.Context) error,
+	opName string,
+	config *configuration.DtConfiguration,
+	logger *logger.ComponentLogger,
+) error {
+	timeout := flushOrShutdownTimeout(ctx, config)
 
 	start := time.Now()
 	err := f(ctx)
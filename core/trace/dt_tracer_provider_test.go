@@ -19,6 +19,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/dynatrace-oss/opentelemetry-exporter-go/core/configuration"
 )
 
 func TestTracerProviderCreatesDtTracer(t *testing.T) {
@@ -64,3 +68,109 @@ func TestTracerProviderForceFlush(t *testing.T) {
 	tp.ForceFlush(context.Background())
 	require.EqualValues(t, tp.processor.spanWatchlist.len(), 0)
 }
+
+func TestTracerProviderWithDtConfiguration(t *testing.T) {
+	config, err := configuration.BuildConfiguration(configuration.DtConfiguration{
+		ClusterId: 456,
+		Tenant:    "otherTenant",
+		BaseUrl:   "https://other.example.com",
+		AuthToken: "otherAuthToken",
+	})
+	require.NoError(t, err)
+
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(config))
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+
+	require.Same(t, config, tp.config)
+	require.Same(t, config, tp.processor.config)
+
+	_, span := tp.Tracer("Dynatrace Tracer").Start(context.Background(), "span")
+	tag := span.(*dtSpan).metadata.getFw4Tag()
+	require.EqualValues(t, 456, tag.ClusterID)
+	require.Equal(t, config.TenantId(), tag.TenantID)
+
+	// providers with different configurations can coexist
+	globalTp, err := NewTracerProvider()
+	require.NoError(t, err)
+	defer globalTp.Shutdown(context.Background())
+	require.Same(t, testConfig, globalTp.config)
+}
+
+func TestTracerProviderWithDtConfigurationLiteral(t *testing.T) {
+	config := &configuration.DtConfiguration{
+		ClusterId: 456,
+		Tenant:    "otherTenant",
+		BaseUrl:   "https://other.example.com",
+		AuthToken: "otherAuthToken",
+	}
+
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(config))
+	require.NoError(t, err)
+	defer tp.Shutdown(context.Background())
+
+	// default values are applied like BuildConfiguration does, the passed configuration is not changed
+	require.NotSame(t, config, tp.config)
+	require.Zero(t, config.AgentId)
+	require.NotZero(t, tp.config.AgentId)
+	require.NotZero(t, tp.config.TenantId())
+	require.Equal(t, configuration.DefaultSpanProcessingIntervalMs, tp.config.SpanProcessingIntervalMs)
+	require.Equal(t, configuration.LoggingDestination_Off, tp.config.LoggingDestination)
+
+	_, span := tp.Tracer("Dynatrace Tracer").Start(context.Background(), "span")
+	tag := span.(*dtSpan).metadata.getFw4Tag()
+	require.Equal(t, tp.config.TenantId(), tag.TenantID)
+}
+
+func TestTracerProviderWithInvalidDtConfiguration(t *testing.T) {
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(&configuration.DtConfiguration{}))
+	require.Nil(t, tp)
+	require.Error(t, err)
+}
+
+func TestTracerProviderAgentInactive(t *testing.T) {
+	config, err := configuration.BuildConfiguration(configuration.DtConfiguration{AgentDisabled: true})
+	require.NoError(t, err)
+
+	tp, err := NewTracerProviderWithOptions(WithDtConfiguration(config),
+		WithTracerProviderOptions(sdktrace.WithSampler(sdktrace.AlwaysSample())))
+	require.NoError(t, err)
+	require.Nil(t, tp.processor, "no exporting goroutine must be started")
+
+	p, err := NewTextMapPropagator(WithDtConfiguration(config))
+	require.NoError(t, err)
+
+	// incoming W3C trace context is passed through unchanged
+	traceparent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx := p.Extract(context.Background(), propagation.HeaderCarrier{"Traceparent": []string{traceparent}})
+	ctx, span := tp.Tracer("test").Start(ctx, "span")
+	require.False(t, span.IsRecording())
+	span.End()
+
+	c := propagation.HeaderCarrier{}
+	p.Inject(ctx, c)
+	require.Equal(t, traceparent, c.Get(traceparentHeader))
+	require.Empty(t, c.Get(xDtHeader))
+
+	require.NoError(t, tp.ForceFlush(context.Background()))
+	require.NoError(t, tp.Shutdown(context.Background()))
+	require.Equal(t, Stats{}, tp.Stats())
+}
+
+func TestSpanProcessorAgentInactive(t *testing.T) {
+	config, err := configuration.BuildConfiguration(configuration.DtConfiguration{AgentDisabled: true})
+	require.NoError(t, err)
+
+	processor, err := NewSpanProcessor(WithDtConfiguration(config))
+	require.NoError(t, err)
+	require.Nil(t, processor.processor, "no exporting goroutine must be started")
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	require.Nil(t, spanRegistries.get(span.SpanContext()))
+	span.End()
+
+	require.NoError(t, tp.ForceFlush(context.Background()))
+	require.NoError(t, tp.Shutdown(context.Background()))
+	require.Zero(t, processor.Stats().SpansStarted)
+}